@@ -0,0 +1,394 @@
+package jmespath
+
+import (
+	"reflect"
+	"sort"
+)
+
+// The interpreter and the builtins see input data through the functions in
+// this file, so that typed Go data works the same on every code path: any
+// slice or array is a JMESPath array, and any map with string keys, struct
+// or marshaler that encodes to an object is a JMESPath object.  Values
+// decoded by encoding/json take the fast paths and are never copied, and
+// values implementing Accessor are asked before reflection is tried.
+// Marshalers are encoded and decoded again each time they are accessed,
+// since their JSON form may change between accesses.
+
+// Kind is the JMESPath type of a value.
+type Kind int
+
+// The JMESPath types.
+const (
+	NullKind    Kind = iota // null, and nil Go values
+	BooleanKind             // true and false
+	NumberKind              // Go numbers and json.Number
+	StringKind              // strings
+	ArrayKind               // slices, arrays and Accessor arrays
+	ObjectKind              // string keyed maps, structs and Accessor objects
+)
+
+// String returns the JMESPath name of the type, such as "number".
+func (k Kind) String() string {
+	switch k {
+	case NullKind:
+		return "null"
+	case BooleanKind:
+		return "boolean"
+	case NumberKind:
+		return "number"
+	case StringKind:
+		return "string"
+	case ArrayKind:
+		return "array"
+	case ObjectKind:
+		return "object"
+	}
+	return "unknown"
+}
+
+// Accessor lets a Go type that is not a map, slice or struct, such as a
+// protobuf message, a YAML node or an ordered map, be searched in place.
+// Which methods are called depends on Kind:
+//
+//   - ObjectKind: Len, Keys and Field.
+//   - ArrayKind: Len and Index.
+//   - NullKind, BooleanKind, NumberKind and StringKind: Scalar.
+//
+// Values returned by Field, Index and Scalar may be plain Go values or
+// further Accessors.  Scalars should be nil, a bool, a float64 or
+// json.Number, or a string; an Accessor with a scalar kind is replaced by
+// its Scalar value wherever the interpreter reaches it.
+type Accessor interface {
+	// Kind returns the JMESPath type of the value.
+	Kind() Kind
+	// Len returns the number of elements of an array or members of an
+	// object.
+	Len() int
+	// Field returns the member of an object named key, and whether there
+	// is one.
+	Field(key string) (interface{}, bool)
+	// Index returns the element of an array at i, with 0 <= i < Len().
+	Index(i int) interface{}
+	// Keys returns the names of the members of an object, in order.
+	Keys() []string
+	// Scalar returns the value of a null, boolean, number or string.
+	Scalar() interface{}
+}
+
+var accessorType = reflect.TypeOf((*Accessor)(nil)).Elem()
+
+// accessed replaces an Accessor with a scalar kind by its value.
+func accessed(v interface{}) interface{} {
+	if a, ok := v.(Accessor); ok {
+		switch a.Kind() {
+		case ArrayKind, ObjectKind:
+			return v
+		}
+		return accessed(a.Scalar())
+	}
+	return v
+}
+
+// accessorValue converts the array or object a to a []interface{} or a
+// map[string]interface{}.  Its elements are left as they are.
+func accessorValue(a Accessor) interface{} {
+	switch a.Kind() {
+	case ArrayKind:
+		items := make([]interface{}, a.Len())
+		for i := range items {
+			items[i] = accessed(a.Index(i))
+		}
+		return items
+	case ObjectKind:
+		keys := a.Keys()
+		object := make(map[string]interface{}, len(keys))
+		for _, key := range keys {
+			v, _ := a.Field(key)
+			object[key] = accessed(v)
+		}
+		return object
+	}
+	return accessed(a.Scalar())
+}
+
+// isSliceType reports whether v is an array: a slice or array of any
+// element type, or an array Accessor.
+func isSliceType(v interface{}) bool {
+	if v == nil {
+		return false
+	}
+	if a, ok := v.(Accessor); ok {
+		return a.Kind() == ArrayKind
+	}
+	switch reflect.TypeOf(v).Kind() {
+	case reflect.Slice, reflect.Array:
+		return true
+	}
+	return false
+}
+
+// isMapType reports whether v is a map with string keys or an object
+// Accessor.  Structs are objects too, but are converted by jsonValue
+// rather than accessed as maps.
+func isMapType(v interface{}) bool {
+	if _, ok := v.(map[string]interface{}); ok {
+		return true
+	}
+	if v == nil {
+		return false
+	}
+	if a, ok := v.(Accessor); ok {
+		return a.Kind() == ObjectKind
+	}
+	t := reflect.TypeOf(v)
+	return t.Kind() == reflect.Map && t.Key().Kind() == reflect.String
+}
+
+// toInterfaceSlice returns the elements of an array value as a
+// []interface{}.  Typed Go slices and arrays are copied element by
+// element; anything that is not an array returns false.
+func toInterfaceSlice(v interface{}) ([]interface{}, bool) {
+	if items, ok := v.([]interface{}); ok {
+		return items, true
+	}
+	if converted, ok := jsonValue(v); ok {
+		items, ok := converted.([]interface{})
+		return items, ok
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, false
+	}
+	items := make([]interface{}, rv.Len())
+	for i := range items {
+		items[i] = elementValue(rv.Index(i))
+	}
+	return items, true
+}
+
+// toInterfaceMap returns the members of an object value as a
+// map[string]interface{}.  Typed Go maps are copied, structs and
+// marshalers are converted with jsonValue; anything that is not an object
+// returns false.
+func toInterfaceMap(v interface{}) (map[string]interface{}, bool) {
+	if object, ok := v.(map[string]interface{}); ok {
+		return object, true
+	}
+	if converted, ok := jsonValue(v); ok {
+		object, ok := converted.(map[string]interface{})
+		return object, ok
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+		return nil, false
+	}
+	object := make(map[string]interface{}, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		object[iter.Key().String()] = elementValue(iter.Value())
+	}
+	return object, true
+}
+
+// elementValue returns an element of a typed slice or map, with nil
+// pointers and interfaces turned into nil and scalar Accessors into their
+// values.
+func elementValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+	}
+	return accessed(v.Interface())
+}
+
+// objectValues returns the values of the members of an object, in order
+// for an Accessor.
+func objectValues(v interface{}) ([]interface{}, bool) {
+	if a, ok := v.(Accessor); ok {
+		if a.Kind() != ObjectKind {
+			return nil, false
+		}
+		keys := a.Keys()
+		values := make([]interface{}, 0, len(keys))
+		for _, key := range keys {
+			value, _ := a.Field(key)
+			values = append(values, accessed(value))
+		}
+		return values, true
+	}
+	object, ok := toInterfaceMap(v)
+	if !ok {
+		return nil, false
+	}
+	values := make([]interface{}, 0, len(object))
+	for _, value := range object {
+		values = append(values, value)
+	}
+	return values, true
+}
+
+// descendants returns the values nested in v at any depth: the elements
+// of arrays and the values of the members of objects, each followed by the
+// values nested in it.  Members come in key order, or in field order for
+// structs and in the order of Keys for Accessors.  Go data can contain
+// itself through pointers, maps and slices; an array or object reached
+// again inside itself is left out instead of being walked forever.
+func descendants(v interface{}) []interface{} {
+	d := &descent{collected: []interface{}{}, visiting: map[valueKey]bool{}}
+	d.walk(v, reflect.ValueOf(v))
+	return d.collected
+}
+
+// descent collects the descendants of a value.
+type descent struct {
+	collected []interface{}
+	visiting  map[valueKey]bool // Arrays and objects the walk is inside
+}
+
+// valueKey identifies the array or object that a pointer, map or slice
+// refers to.
+type valueKey struct {
+	typ    reflect.Type
+	ptr    uintptr
+	length int
+}
+
+// identity returns the key of what rv refers to, if it is a pointer, map
+// or slice, or an interface holding one.
+func identity(rv reflect.Value) (valueKey, bool) {
+	if rv.Kind() == reflect.Interface && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map:
+		if !rv.IsNil() {
+			return valueKey{rv.Type(), rv.Pointer(), 0}, true
+		}
+	case reflect.Slice:
+		if rv.Len() > 0 {
+			return valueKey{rv.Type(), rv.Pointer(), rv.Len()}, true
+		}
+	}
+	return valueKey{}, false
+}
+
+// walk collects the descendants of v.  raw is v as it was found, before
+// the pointers to it were followed, so that it can be recognised if the
+// walk reaches it again.
+func (d *descent) walk(v interface{}, raw reflect.Value) {
+	if key, ok := identity(raw); ok {
+		d.visiting[key] = true
+		defer delete(d.visiting, key)
+	}
+	eachMember(v, func(member interface{}, raw reflect.Value) {
+		if key, ok := identity(raw); ok && d.visiting[key] {
+			return
+		}
+		d.collected = append(d.collected, member)
+		d.walk(member, raw)
+	})
+}
+
+// eachMember calls visit with each element of an array, or the value of
+// each member of an object, along with the reflect.Value it was read
+// from.  Other values have no members.
+func eachMember(v interface{}, visit func(member interface{}, raw reflect.Value)) {
+	switch v := v.(type) {
+	case nil:
+		return
+	case []interface{}:
+		for _, item := range v {
+			visit(item, reflect.ValueOf(item))
+		}
+		return
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			visit(v[key], reflect.ValueOf(v[key]))
+		}
+		return
+	case Accessor:
+		switch v.Kind() {
+		case ArrayKind:
+			for i := 0; i < v.Len(); i++ {
+				item := v.Index(i)
+				visit(accessed(item), reflect.ValueOf(item))
+			}
+		case ObjectKind:
+			for _, key := range v.Keys() {
+				field, _ := v.Field(key)
+				visit(accessed(field), reflect.ValueOf(field))
+			}
+		}
+		return
+	}
+	rv, err := stripPtrs(reflect.ValueOf(v))
+	if err != nil {
+		return
+	}
+	if isMarshaler(v) || (rv.Kind() == reflect.Struct && isMarshaler(rv.Interface())) {
+		// Marshaled values are decoded afresh, so they cannot contain
+		// themselves.
+		converted, _ := jsonValue(v)
+		eachMember(converted, visit)
+		return
+	}
+	switch rv.Kind() {
+	case reflect.Struct:
+		for _, field := range cachedStructFields(rv.Type()).list {
+			if member, ok := fieldValue(rv, field); ok {
+				raw, _ := fieldByIndex(rv, field.index)
+				visit(member, raw)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			visit(elementValue(rv.Index(i)), rv.Index(i))
+		}
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return
+		}
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, key := range keys {
+			visit(elementValue(rv.MapIndex(key)), rv.MapIndex(key))
+		}
+	}
+}
+
+// valueLen returns the length of an array or object.
+func valueLen(v interface{}) int {
+	if a, ok := v.(Accessor); ok {
+		return a.Len()
+	}
+	return reflect.ValueOf(v).Len()
+}
+
+// marshalable returns v with the Accessors in it converted to plain
+// values, so that json.Marshal can encode it.
+func marshalable(v interface{}) interface{} {
+	switch v := v.(type) {
+	case Accessor:
+		return marshalable(accessorValue(v))
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = marshalable(item)
+		}
+		return items
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			object[key] = marshalable(value)
+		}
+		return object
+	}
+	return v
+}
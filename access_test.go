@@ -0,0 +1,212 @@
+package jmespath
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testNode is a tree of YAML-like nodes that implements Accessor.
+type testNode struct {
+	kind     Kind
+	scalar   interface{}
+	keys     []string
+	children []*testNode
+}
+
+func (n *testNode) Kind() Kind              { return n.kind }
+func (n *testNode) Len() int                { return len(n.children) }
+func (n *testNode) Index(i int) interface{} { return n.children[i] }
+func (n *testNode) Keys() []string          { return n.keys }
+func (n *testNode) Scalar() interface{}     { return n.scalar }
+
+func (n *testNode) Field(key string) (interface{}, bool) {
+	for i, k := range n.keys {
+		if k == key {
+			return n.children[i], true
+		}
+	}
+	return nil, false
+}
+
+func scalarNode(v interface{}) *testNode {
+	kind := NullKind
+	switch v.(type) {
+	case bool:
+		kind = BooleanKind
+	case float64:
+		kind = NumberKind
+	case string:
+		kind = StringKind
+	}
+	return &testNode{kind: kind, scalar: v}
+}
+
+func seqNode(children ...*testNode) *testNode {
+	return &testNode{kind: ArrayKind, children: children}
+}
+
+func mapNode(pairs ...interface{}) *testNode {
+	n := &testNode{kind: ObjectKind}
+	for i := 0; i < len(pairs); i += 2 {
+		n.keys = append(n.keys, pairs[i].(string))
+		n.children = append(n.children, pairs[i+1].(*testNode))
+	}
+	return n
+}
+
+// fieldOnly is an object Accessor that can only be searched through
+// Field, to check that field lookups do not convert the whole object.
+type fieldOnly map[string]interface{}
+
+func (f fieldOnly) Kind() Kind              { return ObjectKind }
+func (f fieldOnly) Len() int                { return len(f) }
+func (f fieldOnly) Index(i int) interface{} { panic("Index called on an object") }
+func (f fieldOnly) Keys() []string          { panic("Keys called") }
+func (f fieldOnly) Scalar() interface{}     { panic("Scalar called on an object") }
+
+func (f fieldOnly) Field(key string) (interface{}, bool) {
+	v, ok := f[key]
+	return v, ok
+}
+
+func TestKindString(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("null", NullKind.String())
+	assert.Equal("boolean", BooleanKind.String())
+	assert.Equal("number", NumberKind.String())
+	assert.Equal("string", StringKind.String())
+	assert.Equal("array", ArrayKind.String())
+	assert.Equal("object", ObjectKind.String())
+	assert.Equal("unknown", Kind(42).String())
+}
+
+func TestSearchAccessors(t *testing.T) {
+	doc := mapNode(
+		"store", scalarNode("corner"),
+		"open", scalarNode(true),
+		"owner", scalarNode(nil),
+		"items", seqNode(
+			mapNode("name", scalarNode("pear"), "price", scalarNode(3.0), "tags", seqNode(scalarNode("green"))),
+			mapNode("name", scalarNode("apple"), "price", scalarNode(1.0), "tags", seqNode()),
+			mapNode("name", scalarNode("plum"), "price", scalarNode(2.0), "tags", seqNode(scalarNode("purple"), scalarNode("sour"))),
+		),
+		"empty", mapNode(),
+	)
+	var tests = []struct {
+		expression string
+		expected   interface{}
+	}{
+		{"store", "corner"},
+		{"owner", nil},
+		{"missing", nil},
+		{"items[0].name", "pear"},
+		{"items[-1].price", 2.0},
+		{"items[5]", nil},
+		{"items[1:].name", []interface{}{"apple", "plum"}},
+		{"items[*].name", []interface{}{"pear", "apple", "plum"}},
+		{"items[?price > `1`].name", []interface{}{"pear", "plum"}},
+		{"items[?name == 'apple'].price", []interface{}{1.0}},
+		{"items[*].tags[]", []interface{}{"green", "purple", "sour"}},
+		{"items[0].* | [:2]", []interface{}{"pear", 3.0}},
+		{"items[0].[name, price]", []interface{}{"pear", 3.0}},
+		{"sort_by(items, &price)[*].name", []interface{}{"apple", "plum", "pear"}},
+		{"max_by(items, &price).name", "pear"},
+		{"sum(items[*].price)", 6.0},
+		{"length(items)", 3.0},
+		{"length(items[0])", 3.0},
+		{"sort(keys(items[0]))", []interface{}{"name", "price", "tags"}},
+		{"type(items)", "array"},
+		{"type(items[0])", "object"},
+		{"type(open)", "boolean"},
+		{"type(items[0].price)", "number"},
+		{"open && store", "corner"},
+		{"empty || 'none'", "none"},
+		{"items[1].tags || 'none'", "none"},
+		{"!empty", true},
+		{"merge(items[1], `{\"price\": 5}`).price", 5.0},
+		{"to_string(items[0].tags)", `["green"]`},
+		{"to_string(items[1])", `{"name":"apple","price":1,"tags":[]}`},
+		{"contains(items[2].tags, 'sour')", true},
+		{"join(',', items[2].tags)", "purple,sour"},
+		{"descendants(@)[*].name", []interface{}{"pear", "apple", "plum"}},
+		{"descendants(items[2])[?type(@) == 'string']", []interface{}{"plum", "purple", "sour"}},
+	}
+	for _, tt := range tests {
+		for _, opts := range [][]Option{nil, {WithBytecode()}} {
+			actual, err := Search(tt.expression, doc, opts...)
+			assert.Nil(t, err, tt.expression)
+			assert.Equal(t, tt.expected, actual, tt.expression)
+		}
+	}
+}
+
+func TestSearchScalarAccessor(t *testing.T) {
+	assert := assert.New(t)
+	result, err := Search("@", scalarNode("value"))
+	assert.Nil(err)
+	assert.Equal("value", result)
+	result, err = Search("length(@)", scalarNode("value"))
+	assert.Nil(err)
+	assert.Equal(5.0, result)
+}
+
+func TestScalarAccessorInDecodedObject(t *testing.T) {
+	assert := assert.New(t)
+	data := map[string]interface{}{"a": scalarNode("value")}
+	for _, opts := range [][]Option{nil, {WithBytecode()}} {
+		result, err := Search("a", data, opts...)
+		assert.Nil(err)
+		assert.Equal("value", result)
+	}
+}
+
+func TestAccessorFieldsAreLookedUpInPlace(t *testing.T) {
+	assert := assert.New(t)
+	data := fieldOnly{"a": fieldOnly{"b": "c"}}
+	for _, opts := range [][]Option{nil, {WithBytecode()}} {
+		result, err := Search("a.b", data, opts...)
+		assert.Nil(err)
+		assert.Equal("c", result)
+		result, err = Search("a.missing", data, opts...)
+		assert.Nil(err)
+		assert.Nil(result)
+	}
+}
+
+// linkedNode is Go data that can contain itself through pointers.
+type linkedNode struct {
+	ID       int           `json:"id"`
+	Next     *linkedNode   `json:"next,omitempty"`
+	Children []*linkedNode `json:"children,omitempty"`
+}
+
+func TestDescendantsStopAtCycles(t *testing.T) {
+	a := &linkedNode{ID: 1}
+	b := &linkedNode{ID: 2, Next: a}
+	a.Next = b
+	a.Children = []*linkedNode{a, b}
+	object := map[string]interface{}{"id": "x"}
+	object["self"] = object
+	object["list"] = []interface{}{object, "y"}
+	var tests = []struct {
+		data       interface{}
+		expression string
+		expected   interface{}
+	}{
+		// a is left out wherever it recurs, b only inside itself.
+		{a, "descendants(@)[*].id", []interface{}{2, 2}},
+		// next is a copy of b, so b is recognised once it is reached
+		// through a pointer.
+		{a, "next.descendants(@)[*].id", []interface{}{1, 2, 2}},
+		{object, "descendants(@)", []interface{}{"x", []interface{}{object, "y"}, "y"}},
+	}
+	for _, tt := range tests {
+		for _, opts := range [][]Option{nil, {WithBytecode()}} {
+			actual, err := Search(tt.expression, tt.data, opts...)
+			if assert.Nil(t, err, tt.expression) {
+				assert.Equal(t, tt.expected, actual, tt.expression)
+			}
+		}
+	}
+}
@@ -1,31 +1,41 @@
 package jmespath
 
-import "strconv"
+import (
+	"context"
+	"strconv"
+)
 
 // JmesPath is the epresentation of a compiled JMES path query. A JmesPath is
 // safe for concurrent use by multiple goroutines.
 type JMESPath struct {
-	ast  ASTNode
-	intr *treeInterpreter
+	expression string
+	ast        ASTNode
+	prog       *program // Set when the expression runs on the bytecode VM
+	intr       *treeInterpreter
+	variables  []ASTNode // First reference to each variable SearchWithVars must supply
 }
 
 // Compile parses a JMESPath expression and returns, if successful, a JMESPath
 // object that can be used to match against data.
-func Compile(expression string) (*JMESPath, error) {
-	parser := NewParser()
-	ast, err := parser.Parse(expression)
+//
+// Function calls are resolved each time the expression is evaluated, so
+// functions registered on its FunctionRegistry after Compile, including
+// ones that replace a builtin, are used by later searches.  Only
+// WithTypeCheck and WithOptimization look functions up at compile time.
+func Compile(expression string, opts ...Option) (*JMESPath, error) {
+	cfg := newConfig(opts)
+	ast, err := cfg.parse(expression)
 	if err != nil {
 		return nil, err
 	}
-	jmespath := &JMESPath{ast: ast, intr: newInterpreter()}
-	return jmespath, nil
+	return cfg.build(expression, ast), nil
 }
 
 // MustCompile is like Compile but panics if the expression cannot be parsed.
 // It simplifies safe initialization of global variables holding compiled
 // JMESPaths.
-func MustCompile(expression string) *JMESPath {
-	jmespath, err := Compile(expression)
+func MustCompile(expression string, opts ...Option) *JMESPath {
+	jmespath, err := Compile(expression, opts...)
 	if err != nil {
 		panic(`jmespath: Compile(` + strconv.Quote(expression) + `): ` + err.Error())
 	}
@@ -34,16 +44,39 @@ func MustCompile(expression string) *JMESPath {
 
 // Search evaluates a JMESPath expression against input data and returns the result.
 func (jp *JMESPath) Search(data interface{}) (interface{}, error) {
-	return jp.intr.Execute(jp.ast, data)
+	return jp.run(jp.intr.search(nil), data)
+}
+
+// SearchContext is like Search but stops evaluating the expression once ctx
+// is done, returning ctx.Err().  The context is checked periodically while
+// projections, filters and function calls are evaluated, so a query over a
+// large document is abandoned promptly after a cancellation or deadline.
+func (jp *JMESPath) SearchContext(ctx context.Context, data interface{}) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return jp.run(jp.intr.search(ctx), data)
+}
+
+// run evaluates the expression against data with intr, on the backend it
+// was compiled for.
+func (jp *JMESPath) run(intr *treeInterpreter, data interface{}) (interface{}, error) {
+	var result interface{}
+	var err error
+	data = accessed(data)
+	if jp.prog != nil {
+		result, err = intr.run(jp.prog, data, data)
+	} else {
+		result, err = intr.Execute(jp.ast, data)
+	}
+	return result, withExpression(err, jp.expression)
 }
 
 // Search evaluates a JMESPath expression against input data and returns the result.
-func Search(expression string, data interface{}) (interface{}, error) {
-	intr := newInterpreter()
-	parser := NewParser()
-	ast, err := parser.Parse(expression)
+func Search(expression string, data interface{}, opts ...Option) (interface{}, error) {
+	jmespath, err := Compile(expression, opts...)
 	if err != nil {
 		return nil, err
 	}
-	return intr.Execute(ast, data)
+	return jmespath.Search(data)
 }
@@ -1,7 +1,9 @@
 package jmespath
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -30,3 +32,78 @@ func TestInvalidMustCompilePanics(t *testing.T) {
 	}()
 	MustCompile("not a valid expression")
 }
+
+func TestSearchContextReturnsResult(t *testing.T) {
+	assert := assert.New(t)
+	precompiled := MustCompile("foo[?bar > `1`].bar | sort(@)")
+	data := map[string]interface{}{"foo": []interface{}{
+		map[string]interface{}{"bar": 3.0},
+		map[string]interface{}{"bar": 1.0},
+		map[string]interface{}{"bar": 2.0},
+	}}
+	result, err := precompiled.SearchContext(context.Background(), data)
+	assert.Nil(err)
+	assert.Equal([]interface{}{2.0, 3.0}, result)
+}
+
+func TestSearchContextAlreadyCancelled(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := MustCompile("foo").SearchContext(ctx, map[string]interface{}{"foo": "bar"})
+	assert.Equal(context.Canceled, err)
+}
+
+func TestSearchContextStopsProjection(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls := 0
+	registry := NewFunctionRegistry()
+	registry.Register("visit", func(input []Value, executor *Executor) (interface{}, error) {
+		calls++
+		if calls == 10 {
+			cancel()
+		}
+		return calls, nil
+	})
+	items := make([]interface{}, 100000)
+	for i := range items {
+		items[i] = map[string]interface{}{"a": float64(i)}
+	}
+	precompiled := MustCompile("[*].visit(a)", WithFunctionRegistry(registry))
+	_, err := precompiled.SearchContext(ctx, items)
+	assert.Equal(context.Canceled, err)
+	assert.True(calls < 10+cancelCheckInterval, "evaluation continued after cancel: %d calls", calls)
+}
+
+func TestSearchContextCancelsSortBy(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	registry := NewFunctionRegistry()
+	registry.Register("key", func(input []Value, executor *Executor) (interface{}, error) {
+		cancel()
+		return input[0].Interface(), nil
+	})
+	items := make([]interface{}, 1000)
+	for i := range items {
+		items[i] = float64(len(items) - i)
+	}
+	precompiled := MustCompile("sort_by(@, &key(@))", WithFunctionRegistry(registry))
+	_, err := precompiled.SearchContext(ctx, items)
+	assert.Equal(context.Canceled, err)
+}
+
+func TestSearchContextDeadline(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	items := make([]interface{}, 1000)
+	for i := range items {
+		items[i] = []interface{}{float64(i)}
+	}
+	_, err := MustCompile("[*][*].to_string(@)").SearchContext(ctx, items)
+	assert.Equal(context.DeadlineExceeded, err)
+}
@@ -0,0 +1,133 @@
+package jmespath
+
+import "math"
+
+// Arithmetic follows the rules for numbers that builtins compute: integer
+// operands give an exact int64 result while it is integral and fits, and
+// anything else gives a float64.  "/" divides exactly, "//" divides and
+// rounds down, and "%" is the remainder of "//", with the sign of the
+// divisor, so that a == (a // b) * b + a % b.
+
+// arithmetic applies the arithmetic operator op to left and right.  Unless
+// WithExactIntegers is set, the result is a float64.
+func (intr *treeInterpreter) arithmetic(op tokType, left, right interface{}) (interface{}, error) {
+	x, ok := asNumber(left)
+	if !ok {
+		return nil, operandError(op, left)
+	}
+	y, ok := asNumber(right)
+	if !ok {
+		return nil, operandError(op, right)
+	}
+	if y.float() == 0 && (op == tDivide || op == tIntDivide || op == tModulo) {
+		return nil, runtimeError(InvalidValue, "operator %s: division by zero", arithmeticOperators[op])
+	}
+	var result interface{}
+	if i, ok := integerArithmetic(op, x, y); ok {
+		result = i
+	} else {
+		f := floatArithmetic(op, x.float(), y.float())
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, runtimeError(InvalidValue, "operator %s: result out of range", arithmeticOperators[op])
+		}
+		result = f
+	}
+	if intr.exactIntegers {
+		return result, nil
+	}
+	return floatNumbers(result), nil
+}
+
+// negate returns the negation of the number value.
+func (intr *treeInterpreter) negate(value interface{}) (interface{}, error) {
+	n, ok := asNumber(value)
+	if !ok {
+		return nil, operandError(tMinus, value)
+	}
+	var result interface{}
+	if n.kind == intNumber && n.i != math.MinInt64 {
+		result = -n.i
+	} else {
+		result = -n.float()
+	}
+	if intr.exactIntegers {
+		return result, nil
+	}
+	return floatNumbers(result), nil
+}
+
+func operandError(op tokType, operand interface{}) error {
+	name, err := jpfType([]interface{}{operand})
+	if err != nil {
+		name = "unknown type"
+	}
+	return runtimeError(TypeMismatch, "operator %s: expected a number, got %s", arithmeticOperators[op], name)
+}
+
+// integerArithmetic applies op to x and y exactly, if both are int64s and
+// the result is an integer that fits in one.  The divisor is not zero.
+func integerArithmetic(op tokType, x, y number) (int64, bool) {
+	if x.kind != intNumber || y.kind != intNumber {
+		return 0, false
+	}
+	a, b := x.i, y.i
+	switch op {
+	case tPlus:
+		sum := a + b
+		return sum, (b >= 0) == (sum >= a)
+	case tMinus:
+		difference := a - b
+		return difference, (b >= 0) == (difference <= a)
+	case tStar:
+		if a == 0 || b == 0 {
+			return 0, true
+		}
+		product := a * b
+		return product, product/b == a && !(a == -1 && b == math.MinInt64) && !(b == -1 && a == math.MinInt64)
+	case tDivide:
+		if b == -1 && a == math.MinInt64 || a%b != 0 {
+			return 0, false
+		}
+		return a / b, true
+	case tIntDivide:
+		if b == -1 && a == math.MinInt64 {
+			return 0, false
+		}
+		quotient := a / b
+		if a%b != 0 && (a < 0) != (b < 0) {
+			quotient--
+		}
+		return quotient, true
+	case tModulo:
+		if b == -1 {
+			return 0, true
+		}
+		remainder := a % b
+		if remainder != 0 && (remainder < 0) != (b < 0) {
+			remainder += b
+		}
+		return remainder, true
+	}
+	return 0, false
+}
+
+// floatArithmetic applies op to x and y.  The divisor is not zero.
+func floatArithmetic(op tokType, x, y float64) float64 {
+	switch op {
+	case tPlus:
+		return x + y
+	case tMinus:
+		return x - y
+	case tStar:
+		return x * y
+	case tDivide:
+		return x / y
+	case tIntDivide:
+		return math.Floor(x / y)
+	}
+	remainder := math.Mod(x, y)
+	if remainder != 0 && (remainder < 0) != (y < 0) {
+		remainder += y
+	}
+	return remainder
+}
@@ -0,0 +1,365 @@
+package jmespath
+
+import (
+	"fmt"
+)
+
+// comparators maps the comparison tokens to the operators ASTComparator
+// nodes report as their Value.
+var comparators = map[tokType]string{
+	tEQ:  "==",
+	tNE:  "!=",
+	tLT:  "<",
+	tLTE: "<=",
+	tGT:  ">",
+	tGTE: ">=",
+}
+
+// arithmeticOperators maps the arithmetic tokens to the operators
+// ASTArithmetic nodes report as their Value.
+var arithmeticOperators = map[tokType]string{
+	tPlus:      "+",
+	tMinus:     "-",
+	tStar:      "*",
+	tDivide:    "/",
+	tModulo:    "%",
+	tIntDivide: "//",
+}
+
+// operators returns the operators of the nodes of nodeType, by token, or
+// nil if their values are not operators.
+func operators(nodeType NodeType) map[tokType]string {
+	switch nodeType {
+	case ASTComparator:
+		return comparators
+	case ASTArithmetic:
+		return arithmeticOperators
+	}
+	return nil
+}
+
+// NewASTNode returns a node of the given type.  The value and children
+// each type expects are given with the NodeType constants; use CompileAST
+// to check a tree built from these nodes and evaluate it.
+func NewASTNode(nodeType NodeType, value interface{}, children ...ASTNode) ASTNode {
+	for tok, op := range operators(nodeType) {
+		if value == op {
+			value = tok
+		}
+	}
+	if nodeType == ASTSlice {
+		value = copySlice(value)
+	}
+	return ASTNode{
+		nodeType: nodeType,
+		value:    value,
+		children: append([]ASTNode(nil), children...),
+	}
+}
+
+// Type returns the kind of node.
+func (node ASTNode) Type() NodeType {
+	return node.nodeType
+}
+
+// Position returns the offset in the expression the node was parsed
+// from, or 0 for nodes built with NewASTNode.
+func (node ASTNode) Position() int {
+	return node.position
+}
+
+// Value returns the node's value, as described for its type.
+func (node ASTNode) Value() interface{} {
+	switch node.nodeType {
+	case ASTComparator, ASTArithmetic:
+		if tok, ok := node.value.(tokType); ok {
+			return operators(node.nodeType)[tok]
+		}
+	case ASTSlice:
+		return copySlice(node.value)
+	}
+	return node.value
+}
+
+// Children returns the node's children, as described for its type.
+func (node ASTNode) Children() []ASTNode {
+	return append([]ASTNode(nil), node.children...)
+}
+
+// copySlice copies the bounds of a slice, so that callers cannot change
+// the ones in a compiled expression.
+func copySlice(value interface{}) interface{} {
+	parts, ok := value.([]*int)
+	if !ok {
+		return value
+	}
+	copied := make([]*int, len(parts))
+	for i, part := range parts {
+		if part != nil {
+			n := *part
+			copied[i] = &n
+		}
+	}
+	return copied
+}
+
+// A Visitor's Visit method is invoked for each node encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children of
+// node with the visitor w.
+type Visitor interface {
+	Visit(node ASTNode) (w Visitor)
+}
+
+// Walk traverses an AST in depth-first order: it starts by calling
+// v.Visit(node).  If the visitor w returned by v.Visit(node) is not nil,
+// Walk is invoked recursively with visitor w for each of the children of
+// node.  The key expression of an ASTKeyValExprPair is visited
+// before its child.
+func Walk(v Visitor, node ASTNode) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+	if keyExpr, ok := node.value.(ASTNode); ok {
+		Walk(v, keyExpr)
+	}
+	for _, child := range node.children {
+		Walk(v, child)
+	}
+}
+
+type inspector func(ASTNode) bool
+
+func (f inspector) Visit(node ASTNode) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses an AST in depth-first order: it starts by calling
+// f(node).  If f returns true, Inspect invokes f recursively for each of
+// the children of node.
+func Inspect(node ASTNode, f func(ASTNode) bool) {
+	Walk(inspector(f), node)
+}
+
+// Rewrite returns a copy of an AST in which each node has been replaced by
+// the result of calling f with it.  Nodes are rewritten bottom up, so f
+// sees each node with its children, and the key expression of an
+// ASTKeyValExprPair, already rewritten.  The AST passed in is unchanged.
+func Rewrite(node ASTNode, f func(ASTNode) ASTNode) ASTNode {
+	if keyExpr, ok := node.value.(ASTNode); ok {
+		node.value = Rewrite(keyExpr, f)
+	}
+	if len(node.children) > 0 {
+		children := make([]ASTNode, len(node.children))
+		for i, child := range node.children {
+			children[i] = Rewrite(child, f)
+		}
+		node.children = children
+	}
+	return f(node)
+}
+
+// AST returns the parsed form of the expression.
+func (jp *JMESPath) AST() ASTNode {
+	return jp.ast
+}
+
+// CompileAST is like Compile, but evaluates an AST built with NewASTNode,
+// or taken from another expression and modified, instead of parsing an
+// expression.  It returns an error if a node does not have the value or
+// children its type requires.
+func CompileAST(ast ASTNode, opts ...Option) (*JMESPath, error) {
+	if err := validateAST(ast); err != nil {
+		return nil, err
+	}
+	cfg := newConfig(opts)
+	if err := cfg.check(ast, ""); err != nil {
+		return nil, err
+	}
+	return cfg.build("", ast), nil
+}
+
+// ASTError is returned by CompileAST for a node that does not have the
+// value or children its type requires.
+type ASTError struct {
+	Node ASTNode
+	msg  string
+}
+
+func (e ASTError) Error() string {
+	return fmt.Sprintf("invalid %s node: %s", e.Node.nodeType, e.msg)
+}
+
+// validateAST checks that each node of ast has the shape the parser would
+// have given it.
+func validateAST(node ASTNode) error {
+	invalid := func(format string, args ...interface{}) error {
+		return ASTError{Node: node, msg: fmt.Sprintf(format, args...)}
+	}
+	children := func(min, max int) error {
+		n := len(node.children)
+		switch {
+		case min == max && n != min:
+			return invalid("expected %d children, got %d", min, n)
+		case n < min:
+			return invalid("expected at least %d children, got %d", min, n)
+		case max >= 0 && n > max:
+			return invalid("expected at most %d children, got %d", max, n)
+		}
+		return nil
+	}
+	noValue := func() error {
+		if node.value != nil {
+			return invalid("unexpected value %v", node.value)
+		}
+		return nil
+	}
+	var err error
+	switch node.nodeType {
+	case ASTEmpty:
+		err = invalid("not an expression")
+	case ASTCurrentNode, ASTIdentity, ASTRootNode:
+		if err = noValue(); err == nil {
+			err = children(0, 0)
+		}
+	case ASTExpRef, ASTFlatten, ASTNotExpression, ASTNegate:
+		if err = noValue(); err == nil {
+			err = children(1, 1)
+		}
+	case ASTIndexExpression, ASTOrExpression, ASTAndExpression, ASTProjection, ASTValueProjection:
+		if err = noValue(); err == nil {
+			err = children(2, 2)
+		}
+	case ASTPipe, ASTSubexpression:
+		if err = noValue(); err == nil {
+			err = children(2, -1)
+		}
+	case ASTFilterProjection, ASTConditional:
+		if err = noValue(); err == nil {
+			err = children(3, 3)
+		}
+	case ASTMultiSelectList:
+		if err = noValue(); err == nil {
+			err = children(1, -1)
+		}
+	case ASTMultiSelectHash:
+		if err = noValue(); err == nil {
+			err = children(1, -1)
+		}
+		for _, child := range node.children {
+			if err == nil && child.nodeType != ASTKeyValPair && child.nodeType != ASTKeyValExprPair {
+				err = invalid("unexpected %s child", child.nodeType)
+			}
+		}
+	case ASTComparator:
+		if _, ok := comparators[asTokType(node.value)]; !ok {
+			err = invalid("unknown comparator %v", node.value)
+		} else {
+			err = children(2, 2)
+		}
+	case ASTArithmetic:
+		if _, ok := arithmeticOperators[asTokType(node.value)]; !ok {
+			err = invalid("unknown operator %v", node.value)
+		} else {
+			err = children(2, 2)
+		}
+	case ASTFunctionExpression:
+		if _, ok := node.value.(string); !ok {
+			err = invalid("expected a function name, got %v", node.value)
+		}
+	case ASTField, ASTKeyValPair:
+		if _, ok := node.value.(string); !ok {
+			err = invalid("expected a string value, got %v", node.value)
+		} else if node.nodeType == ASTField {
+			err = children(0, 0)
+		} else {
+			err = children(1, 1)
+		}
+	case ASTKeyValExprPair:
+		if keyExpr, ok := node.value.(ASTNode); !ok || keyExpr.nodeType != ASTExpRef {
+			err = invalid("expected an ASTExpRef node as its value")
+		} else if err = validateAST(keyExpr); err == nil {
+			err = children(1, 1)
+		}
+	case ASTLetExpression:
+		if err = noValue(); err == nil {
+			err = children(2, -1)
+		}
+		for i, child := range node.children {
+			if err == nil && i < len(node.children)-1 && child.nodeType != ASTVariableBinding {
+				err = invalid("unexpected %s child", child.nodeType)
+			}
+		}
+	case ASTVariableBinding, ASTVariable:
+		if name, ok := node.value.(string); !ok || !isUnquotedIdentifier(name) {
+			err = invalid("expected a variable name, got %v", node.value)
+		} else if node.nodeType == ASTVariable {
+			err = children(0, 0)
+		} else {
+			err = children(1, 1)
+		}
+	case ASTIndex:
+		if _, ok := node.value.(int); !ok {
+			err = invalid("expected an int value, got %v", node.value)
+		} else {
+			err = children(0, 0)
+		}
+	case ASTSlice:
+		if parts, ok := node.value.([]*int); !ok || len(parts) != 3 {
+			err = invalid("expected a []*int value of length 3, got %v", node.value)
+		} else {
+			err = children(0, 0)
+		}
+	case ASTLiteral:
+		if !isJSONValue(node.value) {
+			err = invalid("%v (%T) is not a JSON value", node.value, node.value)
+		} else {
+			err = children(0, 0)
+		}
+	default:
+		err = invalid("unknown node type")
+	}
+	if err != nil {
+		return err
+	}
+	for _, child := range node.children {
+		if err := validateAST(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// asTokType returns the token of an operator node's value, or tUnknown.
+func asTokType(value interface{}) tokType {
+	if tok, ok := value.(tokType); ok {
+		return tok
+	}
+	return tUnknown
+}
+
+// isJSONValue reports whether v is made of the values the parser decodes
+// JSON literals to.
+func isJSONValue(v interface{}) bool {
+	switch v := v.(type) {
+	case nil, bool, string:
+		return true
+	case []interface{}:
+		for _, item := range v {
+			if !isJSONValue(item) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		for _, value := range v {
+			if !isJSONValue(value) {
+				return false
+			}
+		}
+		return true
+	}
+	return isNumber(v)
+}
@@ -0,0 +1,194 @@
+package jmespath
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type typeCollector []NodeType
+
+func (c *typeCollector) Visit(node ASTNode) Visitor {
+	*c = append(*c, node.Type())
+	return c
+}
+
+func TestWalk(t *testing.T) {
+	var types typeCollector
+	Walk(&types, MustCompile("{k: a}[?b > `1`] | {&c: d}").AST())
+	assert.Equal(t, typeCollector{
+		ASTPipe,
+		ASTFilterProjection,
+		ASTMultiSelectHash, ASTKeyValPair, ASTField,
+		ASTIdentity,
+		ASTComparator, ASTField, ASTLiteral,
+		ASTMultiSelectHash, ASTKeyValExprPair, ASTExpRef, ASTField, ASTField,
+	}, types)
+}
+
+func TestInspect(t *testing.T) {
+	assert := assert.New(t)
+	var fields []string
+	Inspect(MustCompile("a.b || sort_by(c, &d)").AST(), func(node ASTNode) bool {
+		if node.Type() == ASTField {
+			fields = append(fields, node.Value().(string))
+		}
+		return node.Type() != ASTExpRef
+	})
+	assert.Equal([]string{"a", "b", "c"}, fields)
+}
+
+func TestASTAccessors(t *testing.T) {
+	assert := assert.New(t)
+	ast := MustCompile("foo[?bar >= `2`].baz[1:-1]").AST()
+	assert.Equal(ASTFilterProjection, ast.Type())
+	comparator := ast.Children()[2]
+	assert.Equal(ASTComparator, comparator.Type())
+	assert.Equal(">=", comparator.Value())
+	assert.Equal(5, comparator.Children()[0].Position())
+	assert.Equal(2.0, comparator.Children()[1].Value())
+
+	slice := ast.Children()[1].Children()[0].Children()[1]
+	assert.Equal(ASTSlice, slice.Type())
+	parts := slice.Value().([]*int)
+	assert.Equal(1, *parts[0])
+	assert.Nil(parts[2])
+	*parts[0] = 0
+	assert.Equal(1, *slice.Value().([]*int)[0], "Value returns a copy")
+}
+
+func TestRewrite(t *testing.T) {
+	assert := assert.New(t)
+	jp := MustCompile("user.name || {n: name}")
+	renamed := Rewrite(jp.AST(), func(node ASTNode) ASTNode {
+		if node.Type() == ASTField && node.Value() == "name" {
+			return NewASTNode(ASTField, "login")
+		}
+		return node
+	})
+	compiled, err := CompileAST(renamed)
+	if assert.Nil(err) {
+		data := map[string]interface{}{"login": "x", "name": "y"}
+		result, err := compiled.Search(data)
+		assert.Nil(err)
+		assert.Equal(map[string]interface{}{"n": "x"}, result)
+	}
+	result, err := jp.Search(map[string]interface{}{"user": map[string]interface{}{"name": "z"}})
+	assert.Nil(err)
+	assert.Equal("z", result, "the original AST is unchanged")
+}
+
+func TestCompileAST(t *testing.T) {
+	assert := assert.New(t)
+	two := 2
+	// people[?age > `30`].name | sort(@)[:2]
+	ast := NewASTNode(ASTPipe, nil,
+		NewASTNode(ASTFilterProjection, nil,
+			NewASTNode(ASTField, "people"),
+			NewASTNode(ASTField, "name"),
+			NewASTNode(ASTComparator, ">",
+				NewASTNode(ASTField, "age"),
+				NewASTNode(ASTLiteral, 30.0))),
+		NewASTNode(ASTProjection, nil,
+			NewASTNode(ASTIndexExpression, nil,
+				NewASTNode(ASTFunctionExpression, "sort", NewASTNode(ASTCurrentNode, nil)),
+				NewASTNode(ASTSlice, []*int{nil, &two, nil})),
+			NewASTNode(ASTIdentity, nil)))
+	data := map[string]interface{}{"people": []interface{}{
+		map[string]interface{}{"name": "c", "age": 40.0},
+		map[string]interface{}{"name": "a", "age": 35.0},
+		map[string]interface{}{"name": "b", "age": 20.0},
+		map[string]interface{}{"name": "d", "age": 31.0},
+	}}
+	for _, opts := range [][]Option{nil, {WithBytecode()}} {
+		jp, err := CompileAST(ast, opts...)
+		if assert.Nil(err) {
+			result, err := jp.Search(data)
+			assert.Nil(err)
+			assert.Equal([]interface{}{"a", "c"}, result)
+		}
+	}
+
+	_, err := CompileAST(NewASTNode(ASTFunctionExpression, "nope"), WithFunctionValidation())
+	assert.EqualError(err, "SyntaxError: Unknown function: nope()")
+}
+
+func TestCompileASTInvalid(t *testing.T) {
+	var tests = []struct {
+		ast     ASTNode
+		message string
+	}{
+		{NewASTNode(ASTField, 1), "invalid ASTField node: expected a string value, got 1"},
+		{NewASTNode(ASTField, "a", NewASTNode(ASTField, "b")), "invalid ASTField node: expected 0 children, got 1"},
+		{NewASTNode(ASTOrExpression, nil, NewASTNode(ASTField, "a")), "invalid ASTOrExpression node: expected 2 children, got 1"},
+		{NewASTNode(ASTSubexpression, nil, NewASTNode(ASTField, "a")), "invalid ASTSubexpression node: expected at least 2 children, got 1"},
+		{NewASTNode(ASTComparator, "=~", NewASTNode(ASTField, "a"), NewASTNode(ASTField, "b")), "invalid ASTComparator node: unknown comparator =~"},
+		{NewASTNode(ASTIndex, "0"), "invalid ASTIndex node: expected an int value, got 0"},
+		{NewASTNode(ASTSlice, []*int{nil}), "invalid ASTSlice node: expected a []*int value of length 3, got [<nil>]"},
+		{NewASTNode(ASTLiteral, struct{}{}), "invalid ASTLiteral node: {} (struct {}) is not a JSON value"},
+		{NewASTNode(ASTMultiSelectList, nil), "invalid ASTMultiSelectList node: expected at least 1 children, got 0"},
+		{NewASTNode(ASTMultiSelectHash, nil, NewASTNode(ASTField, "a")), "invalid ASTMultiSelectHash node: unexpected ASTField child"},
+		{NewASTNode(ASTKeyValExprPair, NewASTNode(ASTField, "a"), NewASTNode(ASTField, "b")), "invalid ASTKeyValExprPair node: expected an ASTExpRef node as its value"},
+		{NewASTNode(ASTNotExpression, nil, NewASTNode(ASTIndex, nil)), "invalid ASTIndex node: expected an int value, got <nil>"},
+		{NewASTNode(ASTLetExpression, nil, NewASTNode(ASTField, "a"), NewASTNode(ASTField, "b")), "invalid ASTLetExpression node: unexpected ASTField child"},
+		{NewASTNode(ASTLetExpression, nil), "invalid ASTLetExpression node: expected at least 2 children, got 0"},
+		{NewASTNode(ASTVariable, "a b"), "invalid ASTVariable node: expected a variable name, got a b"},
+		{NewASTNode(ASTArithmetic, "^", NewASTNode(ASTField, "a"), NewASTNode(ASTField, "b")), "invalid ASTArithmetic node: unknown operator ^"},
+		{NewASTNode(ASTNegate, nil), "invalid ASTNegate node: expected 1 children, got 0"},
+		{NewASTNode(ASTConditional, nil, NewASTNode(ASTField, "a"), NewASTNode(ASTField, "b")), "invalid ASTConditional node: expected 3 children, got 2"},
+		{ASTNode{}, "invalid ASTEmpty node: not an expression"},
+		{NewASTNode(NodeType(100), nil), "invalid NodeType(100) node: unknown node type"},
+	}
+	for _, tt := range tests {
+		_, err := CompileAST(tt.ast)
+		if _, ok := err.(ASTError); assert.True(t, ok, tt.message) {
+			assert.EqualError(t, err, tt.message)
+		}
+	}
+}
+
+// rebuild copies ast using only the exported API.
+func rebuild(ast ASTNode) ASTNode {
+	var children []ASTNode
+	for _, child := range ast.Children() {
+		children = append(children, rebuild(child))
+	}
+	value := ast.Value()
+	if keyExpr, ok := value.(ASTNode); ok {
+		value = rebuild(keyExpr)
+	}
+	return NewASTNode(ast.Type(), value, children...)
+}
+
+// TestCompileASTCompliance rebuilds the AST of every passing compliance
+// test and checks that it gives the same result.
+func TestCompileASTCompliance(t *testing.T) {
+	for _, filename := range whiteListed {
+		var testSuites []TestSuite
+		data, err := ioutil.ReadFile(filename)
+		if !assert.Nil(t, err) {
+			continue
+		}
+		assert.Nil(t, json.Unmarshal(data, &testSuites))
+		for _, testsuite := range testSuites {
+			for _, testcase := range testsuite.TestCases {
+				if testcase.Error != "" {
+					continue
+				}
+				ast := rebuild(MustCompile(testcase.Expression).AST())
+				for _, opts := range [][]Option{nil, {WithBytecode()}} {
+					jp, err := CompileAST(ast, opts...)
+					if !assert.Nil(t, err, testcase.Expression) {
+						continue
+					}
+					result, err := jp.Search(testsuite.Given)
+					if assert.Nil(t, err, testcase.Expression) {
+						assert.Equal(t, force_parse(testcase.Result), force_parse(result), testcase.Expression)
+					}
+				}
+			}
+		}
+	}
+}
@@ -6,10 +6,24 @@ Only print the AST for the expression:
 
     jp.go -ast "foo.bar.baz"
 
+Only print the canonical form of the expression:
+
+    jp.go -format "foo . bar[?(a == `1`)]"
+
 Evaluate the JMESPath expression against JSON data from a file:
 
     jp.go -input /tmp/data.json "foo.bar.baz"
 
+Print every step of the evaluation to stderr, with the value each part of
+the expression was evaluated against and what it produced:
+
+    jp.go -explain -input /tmp/data.json "foo[?bar > `1`].baz"
+
+Evaluate the expression against each record of newline-delimited JSON,
+printing one result per line:
+
+    jp.go -ndjson -input /tmp/records.ndjson "foo.bar.baz"
+
 This program can also be used as an executable to the jp-compliance
 runner (github.com/jmespath/jmespath.test).
 
@@ -17,10 +31,12 @@ runner (github.com/jmespath/jmespath.test).
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"runtime"
 )
 
 import (
@@ -38,7 +54,11 @@ func errMsg(msg string, a ...interface{}) int {
 func run() int {
 
 	astOnly := flag.Bool("ast", false, "Print the AST for the input expression and exit.")
+	formatOnly := flag.Bool("format", false, "Print the canonical form of the input expression and exit.")
 	inputFile := flag.String("input", "", "Filename containing JSON data to search. If not provided, data is read from stdin.")
+	explain := flag.Bool("explain", false, "Print a trace of the evaluation to stderr before the result.")
+	ndjson := flag.Bool("ndjson", false, "Search each line of the input as a separate JSON record.")
+	workers := flag.Int("workers", runtime.NumCPU(), "Number of records to search in parallel with -ndjson.")
 
 	flag.Parse()
 	args := flag.Args()
@@ -62,7 +82,18 @@ func run() int {
 		fmt.Printf("%s\n", parsed)
 		return 0
 	}
+	if *formatOnly {
+		fmt.Println(jmespath.Format(parsed))
+		return 0
+	}
 
+	jp, err := jmespath.Compile(expression)
+	if err != nil {
+		return errMsg("%s", err)
+	}
+	if *ndjson {
+		return searchNDJSON(jp, *inputFile, *workers)
+	}
 	var inputData []byte
 	if *inputFile != "" {
 		inputData, err = ioutil.ReadFile(*inputFile)
@@ -77,9 +108,22 @@ func run() int {
 			return errMsg("Error reading from stdin: %s", err)
 		}
 	}
-	var data interface{}
-	json.Unmarshal(inputData, &data)
-	result, err := jmespath.Search(expression, data)
+	var result interface{}
+	if *explain {
+		var data interface{}
+		if err := json.Unmarshal(inputData, &data); err != nil {
+			return errMsg("Error parsing JSON input: %s", err)
+		}
+		var trace jmespath.Trace
+		result, trace, err = jp.Explain(data)
+		fmt.Fprint(os.Stderr, trace)
+	} else {
+		// Only the parts of the input the expression visits are decoded.
+		result, err = jp.SearchJSON(inputData)
+	}
+	if syntaxError, ok := err.(*json.SyntaxError); ok {
+		return errMsg("Error parsing JSON input: %s", syntaxError)
+	}
 	if err != nil {
 		return errMsg("Error executing expression: %s", err)
 	}
@@ -91,6 +135,28 @@ func run() int {
 	return 0
 }
 
+// searchNDJSON streams the records in inputFile, or stdin, through jp.
+func searchNDJSON(jp *jmespath.JMESPath, inputFile string, workers int) int {
+	input := os.Stdin
+	if inputFile != "" {
+		file, err := os.Open(inputFile)
+		if err != nil {
+			return errMsg("Error loading file %s: %s", inputFile, err)
+		}
+		defer file.Close()
+		input = file
+	}
+	output := bufio.NewWriter(os.Stdout)
+	err := jp.SearchNDJSON(input, output, workers)
+	if flushErr := output.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		return errMsg("Error executing expression: %s", err)
+	}
+	return 0
+}
+
 func main() {
 	os.Exit(run())
 }
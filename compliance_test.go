@@ -41,6 +41,10 @@ var whiteListed = []string{
 	"compliance/unicode.json",
 	"compliance/wildcard.json",
 	"compliance/boolean.json",
+	"compliance/letexpr.json",
+	"compliance/arithmetic.json",
+	"compliance/conditional.json",
+	"compliance/descendants.json",
 }
 
 func allowed(path string) bool {
@@ -64,27 +68,31 @@ func TestCompliance(t *testing.T) {
 		return nil
 	})
 	if assert.Nil(err) {
-		for _, filename := range complianceFiles {
-			runComplianceTest(assert, filename)
-			runComplianceTestJsonNumber(assert, filename)
+		// Run the suite on the tree interpreter and on the bytecode VM.
+		for _, opts := range [][]Option{nil, {WithBytecode()}} {
+			for _, filename := range complianceFiles {
+				runComplianceTest(assert, filename, opts...)
+				runComplianceTestJsonNumber(assert, filename, opts...)
+				runComplianceTestRawJSON(assert, filename, opts...)
+			}
 		}
 	}
 }
 
-func runComplianceTest(assert *assert.Assertions, filename string) {
+func runComplianceTest(assert *assert.Assertions, filename string, opts ...Option) {
 	var testSuites []TestSuite
 	data, err := ioutil.ReadFile(filename)
 	if assert.Nil(err) {
 		err := json.Unmarshal(data, &testSuites)
 		if assert.Nil(err) {
 			for _, testsuite := range testSuites {
-				runTestSuite(assert, testsuite, filename)
+				runTestSuite(assert, testsuite, filename, opts...)
 			}
 		}
 	}
 }
 
-func runComplianceTestJsonNumber(assert *assert.Assertions, filename string) {
+func runComplianceTestJsonNumber(assert *assert.Assertions, filename string, opts ...Option) {
 	var testSuites []TestSuite
 	data, err := ioutil.ReadFile(filename)
 	if assert.Nil(err) {
@@ -93,31 +101,66 @@ func runComplianceTestJsonNumber(assert *assert.Assertions, filename string) {
 		err := d.Decode(&testSuites)
 		if assert.Nil(err) {
 			for _, testsuite := range testSuites {
-				runTestSuite(assert, testsuite, filename)
+				runTestSuite(assert, testsuite, filename, opts...)
+			}
+		}
+	}
+}
+
+// runComplianceTestRawJSON runs the suite against the encoded documents
+// with SearchJSON.
+func runComplianceTestRawJSON(assert *assert.Assertions, filename string, opts ...Option) {
+	var testSuites []TestSuite
+	data, err := ioutil.ReadFile(filename)
+	if assert.Nil(err) {
+		err := json.Unmarshal(data, &testSuites)
+		if assert.Nil(err) {
+			for _, testsuite := range testSuites {
+				given, err := json.Marshal(testsuite.Given)
+				if !assert.Nil(err) {
+					continue
+				}
+				for _, testcase := range testsuite.TestCases {
+					runRawJSONTestCase(assert, given, testcase, opts...)
+				}
 			}
 		}
 	}
 }
 
-func runTestSuite(assert *assert.Assertions, testsuite TestSuite, filename string) {
+func runRawJSONTestCase(assert *assert.Assertions, given []byte, testcase TestCase, opts ...Option) {
+	message := fmt.Sprintf("Expression: %s (SearchJSON)", testcase.Expression)
+	jp, err := Compile(testcase.Expression, opts...)
+	var actual interface{}
+	if err == nil {
+		actual, err = jp.SearchJSON(given)
+	}
+	if testcase.Error != "" {
+		assert.NotNil(err, message)
+	} else if assert.Nil(err, message) {
+		assert.Equal(force_parse(testcase.Result), force_parse(actual), message)
+	}
+}
+
+func runTestSuite(assert *assert.Assertions, testsuite TestSuite, filename string, opts ...Option) {
 	for _, testcase := range testsuite.TestCases {
 		if testcase.Error != "" {
 			// This is a test case that verifies we error out properly.
-			runSyntaxTestCase(assert, testsuite.Given, testcase, filename)
+			runSyntaxTestCase(assert, testsuite.Given, testcase, filename, opts...)
 		} else {
-			runTestCase(assert, testsuite.Given, testcase, filename)
+			runTestCase(assert, testsuite.Given, testcase, filename, opts...)
 		}
 	}
 }
 
-func runSyntaxTestCase(assert *assert.Assertions, given interface{}, testcase TestCase, filename string) {
+func runSyntaxTestCase(assert *assert.Assertions, given interface{}, testcase TestCase, filename string, opts ...Option) {
 	// Anything with an .Error means that we expect that JMESPath should return
 	// an error when we try to evaluate the expression.
-	_, err := Search(testcase.Expression, given)
+	_, err := Search(testcase.Expression, given, opts...)
 	assert.NotNil(err, fmt.Sprintf("Expression: %s", testcase.Expression))
 }
 
-func runTestCase(assert *assert.Assertions, given interface{}, testcase TestCase, filename string) {
+func runTestCase(assert *assert.Assertions, given interface{}, testcase TestCase, filename string, opts ...Option) {
 	lexer := NewLexer()
 	var err error
 	_, err = lexer.tokenize(testcase.Expression)
@@ -133,7 +176,7 @@ func runTestCase(assert *assert.Assertions, given interface{}, testcase TestCase
 		assert.Fail(errMsg)
 		return
 	}
-	actual, err := Search(testcase.Expression, given)
+	actual, err := Search(testcase.Expression, given, opts...)
 	if assert.Nil(err, fmt.Sprintf("Expression: %s", testcase.Expression)) {
 		assert.Equal(force_parse(testcase.Result), force_parse(actual), fmt.Sprintf("Expression: %s", testcase.Expression))
 	}
@@ -8,7 +8,7 @@ func convToString(inp interface{}) (string, error) {
 	if v, ok := inp.(string); ok {
 		return v, nil
 	}
-	result, err := json.Marshal(inp)
+	result, err := json.Marshal(marshalable(inp))
 	if err != nil {
 		return "", err
 	}
@@ -2,80 +2,201 @@ package jmespath
 
 import (
 	"errors"
+	"fmt"
+	"sync"
 )
 
+// Executor lets a CustomFunction evaluate expression references ("&expr")
+// it was passed as arguments.
 type Executor struct {
-	intr     *treeInterpreter
-	root     interface{}
+	intr *treeInterpreter
+	root interface{}
 }
 
+// Execute evaluates the expression reference expr against item.
 func (ex *Executor) Execute(expr Value, item interface{}) (interface{}, error) {
 	if !expr.IsExpression() {
-		return nil, errors.New("Non-expression passed to Execute")
+		return nil, runtimeError(TypeMismatch, "Non-expression passed to Execute")
 	}
-	return ex.intr.execute(expr.expression.ref, item, ex.root)
+	return ex.intr.executeRef(expr.expression, item, ex.root)
 }
 
+// CustomFunction is a user supplied function that can be called from a
+// JMESPath expression.
 type CustomFunction func(input []Value, executor *Executor) (interface{}, error)
 
+// ArgType is a JMESPath type that a function argument may be required
+// to have.
+type ArgType = jpType
+
+// The argument types available to custom function signatures.  These
+// are the same types the builtin functions are declared with.
+const (
+	TypeNumber      = jpNumber
+	TypeString      = jpString
+	TypeArray       = jpArray
+	TypeObject      = jpObject
+	TypeArrayNumber = jpArrayNumber
+	TypeArrayString = jpArrayString
+	TypeExpref      = jpExpref
+	TypeAny         = jpAny
+)
 
-var globalCustomFunctions map[string]CustomFunction
+// ArgSpec declares one parameter of a custom function.
+type ArgSpec struct {
+	// Types lists the types the argument may have.  An argument
+	// matching none of them fails the call with an "invalid type" error.
+	Types []ArgType
+	// Optional allows the argument to be omitted.  Optional parameters
+	// must follow all required parameters.
+	Optional bool
+	// Variadic allows the parameter to be repeated any number of times.
+	// Only the last parameter may be variadic.
+	Variadic bool
+}
 
-func assertGlobalCustomFunctionsInit() {
-	if globalCustomFunctions == nil {
-		globalCustomFunctions = make(map[string]CustomFunction)
+func newArgSpecs(name string, specs []ArgSpec) ([]argSpec, error) {
+	converted := make([]argSpec, len(specs))
+	for i, spec := range specs {
+		if len(spec.Types) == 0 {
+			return nil, fmt.Errorf("argument %d of %s() declares no types", i, name)
+		}
+		if spec.Variadic && i != len(specs)-1 {
+			return nil, fmt.Errorf("argument %d of %s() is variadic but not last", i, name)
+		}
+		if !spec.Optional && i > 0 && converted[i-1].optional {
+			return nil, fmt.Errorf("argument %d of %s() is required but follows an optional argument", i, name)
+		}
+		converted[i] = argSpec{
+			types:    append([]jpType(nil), spec.Types...),
+			variadic: spec.Variadic,
+			optional: spec.Optional,
+		}
 	}
+	return converted, nil
 }
 
+// FunctionRegistry is the set of functions an expression can call: the
+// JMESPath builtins plus any custom functions registered on it.  Custom
+// functions take precedence over builtins of the same name.
+//
+// A FunctionRegistry is safe for concurrent registration and lookup, so
+// functions may be registered while expressions using the registry are
+// being compiled or evaluated.
+type FunctionRegistry struct {
+	mu       sync.RWMutex
+	builtins map[string]functionEntry
+	custom   map[string]functionEntry
+}
 
-type customFunctionCaller struct {
-	functionList map[string]CustomFunction
+// NewFunctionRegistry creates a FunctionRegistry containing only the
+// JMESPath builtin functions.
+func NewFunctionRegistry() *FunctionRegistry {
+	return &FunctionRegistry{
+		builtins: builtinFunctions(),
+		custom:   make(map[string]functionEntry),
+	}
 }
 
-func newCustomFunctionCaller() *customFunctionCaller {
-	fn_caller := &customFunctionCaller{}
-	fn_caller.functionList = make(map[string]CustomFunction)
+// defaultRegistry is used by expressions compiled without an explicit
+// FunctionRegistry, and is the registry RegisterFunction adds to.
+var defaultRegistry = NewFunctionRegistry()
+
+// Register adds a custom function to the registry under name, replacing
+// any custom function previously registered with that name, including in
+// expressions already compiled against the registry.  The
+// function receives its arguments unchecked; use RegisterWithSignature
+// to have arity and argument types validated before it is called.
+func (r *FunctionRegistry) Register(name string, fn CustomFunction) error {
+	return r.register(name, nil, true, fn)
+}
 
-	assertGlobalCustomFunctionsInit()
+// RegisterWithSignature is like Register, but calls to the function are
+// checked against args the same way calls to builtin functions are.  A
+// call with the wrong number of arguments, or with an argument whose type
+// is not one of those its ArgSpec lists, fails without calling fn.  An
+// empty args declares a function that takes no arguments.
+func (r *FunctionRegistry) RegisterWithSignature(name string, args []ArgSpec, fn CustomFunction) error {
+	return r.register(name, args, false, fn)
+}
 
-	for name, fn := range globalCustomFunctions {
-		fn_caller.functionList[name] = fn
+// register adds fn to the registry, with calls checked against args
+// unless unchecked is set.
+func (r *FunctionRegistry) register(name string, args []ArgSpec, unchecked bool, fn CustomFunction) error {
+	if name == "" {
+		return errors.New("function name must not be empty")
 	}
-
-	return fn_caller
+	if fn == nil {
+		return errors.New("function must not be nil: " + name)
+	}
+	specs, err := newArgSpecs(name, args)
+	if err != nil {
+		return err
+	}
+	entry := functionEntry{
+		name:      name,
+		arguments: specs,
+		handler: func(arguments []interface{}) (interface{}, error) {
+			intr := arguments[0].(*treeInterpreter)
+			return callCustomFunction(fn, arguments[2:], intr, arguments[1])
+		},
+		hasExpRef: true,
+		unchecked: unchecked,
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.custom[name] = entry
+	return nil
 }
 
-func (fc *customFunctionCaller) canCall(name string) bool {
-	if _, ok := fc.functionList[name]; ok {
-		return true
-	} else {
-		return false
+// Clone returns a new FunctionRegistry with the same functions as r.
+// Functions registered on either registry afterwards are not visible
+// to the other.
+func (r *FunctionRegistry) Clone() *FunctionRegistry {
+	clone := NewFunctionRegistry()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for name, entry := range r.custom {
+		clone.custom[name] = entry
 	}
+	return clone
 }
 
-func (fc *customFunctionCaller) callFunction(name string, arguments []interface{}, intr *treeInterpreter, rootValue interface{}) (interface{}, error) {
-	fn, ok := fc.functionList[name]
-	if !ok {
-		return nil, errors.New("Function not registered")
+func (r *FunctionRegistry) lookup(name string) (functionEntry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if entry, ok := r.custom[name]; ok {
+		return entry, true
 	}
+	entry, ok := r.builtins[name]
+	return entry, ok
+}
 
-	ex := &Executor {
+func callCustomFunction(fn CustomFunction, arguments []interface{}, intr *treeInterpreter, rootValue interface{}) (interface{}, error) {
+	ex := &Executor{
 		intr: intr,
 		root: rootValue,
 	}
 
-	val_arguments := make([]Value, len(arguments))
+	valArguments := make([]Value, len(arguments))
 	for i := 0; i < len(arguments); i++ {
-		val_arguments[i] = *AsValue(arguments[i])
+		valArguments[i] = *AsValue(arguments[i])
 	}
 
-	return fn(val_arguments, ex)
+	return fn(valArguments, ex)
 }
 
-
+// RegisterFunction adds a custom function to the default FunctionRegistry,
+// which is used by Compile and Search unless another registry is supplied
+// with WithFunctionRegistry.  Expressions compiled before the function was
+// registered can call it too.
 func RegisterFunction(name string, fn CustomFunction) error {
-	assertGlobalCustomFunctionsInit()
+	return defaultRegistry.Register(name, fn)
+}
 
-	globalCustomFunctions[name] = fn
-	return nil
+// RegisterFunctionWithSignature adds a custom function with a checked
+// signature to the default FunctionRegistry.  See
+// FunctionRegistry.RegisterWithSignature.
+func RegisterFunctionWithSignature(name string, args []ArgSpec, fn CustomFunction) error {
+	return defaultRegistry.RegisterWithSignature(name, args, fn)
 }
@@ -0,0 +1,269 @@
+package jmespath
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func constantFunction(result interface{}) CustomFunction {
+	return func(input []Value, executor *Executor) (interface{}, error) {
+		return result, nil
+	}
+}
+
+func TestFunctionRegistriesAreIsolated(t *testing.T) {
+	assert := assert.New(t)
+	first := NewFunctionRegistry()
+	second := NewFunctionRegistry()
+	assert.Nil(first.Register("team", constantFunction("first")))
+	assert.Nil(second.Register("team", constantFunction("second")))
+
+	result, err := Search("team()", nil, WithFunctionRegistry(first))
+	assert.Nil(err)
+	assert.Equal("first", result)
+
+	compiled, err := Compile("team()", WithFunctionRegistry(second))
+	assert.Nil(err)
+	result, err = compiled.Search(nil)
+	assert.Nil(err)
+	assert.Equal("second", result)
+
+	_, err = Search("team()", nil, WithFunctionRegistry(NewFunctionRegistry()))
+	assert.NotNil(err)
+}
+
+func TestFunctionRegistryIncludesBuiltins(t *testing.T) {
+	assert := assert.New(t)
+	registry := NewFunctionRegistry()
+	result, err := Search("length(@)", []interface{}{1.0, 2.0}, WithFunctionRegistry(registry))
+	assert.Nil(err)
+	assert.Equal(2.0, result)
+}
+
+func TestCustomFunctionOverridesBuiltin(t *testing.T) {
+	assert := assert.New(t)
+	registry := NewFunctionRegistry()
+	assert.Nil(registry.Register("length", constantFunction("custom")))
+	result, err := Search("length(@)", []interface{}{}, WithFunctionRegistry(registry))
+	assert.Nil(err)
+	assert.Equal("custom", result)
+}
+
+func TestFunctionRegistryRejectsInvalidRegistrations(t *testing.T) {
+	assert := assert.New(t)
+	registry := NewFunctionRegistry()
+	assert.NotNil(registry.Register("", constantFunction(nil)))
+	assert.NotNil(registry.Register("nilfn", nil))
+}
+
+func TestFunctionRegistryClone(t *testing.T) {
+	assert := assert.New(t)
+	original := NewFunctionRegistry()
+	assert.Nil(original.Register("before", constantFunction(true)))
+	clone := original.Clone()
+	assert.Nil(original.Register("after", constantFunction(true)))
+
+	_, err := Search("before()", nil, WithFunctionRegistry(clone))
+	assert.Nil(err)
+	_, err = Search("after()", nil, WithFunctionRegistry(clone))
+	assert.NotNil(err)
+}
+
+func TestCompiledExpressionSeesLaterRegistrations(t *testing.T) {
+	assert := assert.New(t)
+	registry := NewFunctionRegistry()
+	compiled, err := Compile("late()", WithFunctionRegistry(registry))
+	assert.Nil(err)
+	assert.Nil(registry.Register("late", constantFunction("registered")))
+	result, err := compiled.Search(nil)
+	assert.Nil(err)
+	assert.Equal("registered", result)
+}
+
+func TestCompiledExpressionSeesLaterOverrides(t *testing.T) {
+	assert := assert.New(t)
+	registry := NewFunctionRegistry()
+	for _, opts := range [][]Option{nil, {WithBytecode()}} {
+		compiled := MustCompile("length(@)", append(opts, WithFunctionRegistry(registry))...)
+		registry.Register("length", constantFunction("before"))
+		result, err := compiled.Search("abc")
+		assert.Nil(err)
+		assert.Equal("before", result)
+		registry.Register("length", constantFunction("after"))
+		result, err = compiled.Search("abc")
+		assert.Nil(err)
+		assert.Equal("after", result)
+	}
+}
+
+func TestRegisterFunctionAfterCompile(t *testing.T) {
+	assert := assert.New(t)
+	compiled := MustCompile("registered_after_compile()")
+	_, err := compiled.Search(nil)
+	assert.NotNil(err)
+	assert.Nil(RegisterFunction("registered_after_compile", constantFunction("late")))
+	result, err := compiled.Search(nil)
+	assert.Nil(err)
+	assert.Equal("late", result)
+}
+
+func TestCustomFunctionExecutesExpressionReferences(t *testing.T) {
+	assert := assert.New(t)
+	registry := NewFunctionRegistry()
+	err := registry.Register("apply", func(input []Value, executor *Executor) (interface{}, error) {
+		return executor.Execute(input[0], input[1].Interface())
+	})
+	assert.Nil(err)
+	data := map[string]interface{}{"foo": map[string]interface{}{"bar": "baz"}}
+	result, err := Search("apply(&bar, foo)", data, WithFunctionRegistry(registry))
+	assert.Nil(err)
+	assert.Equal("baz", result)
+}
+
+func TestCustomFunctionWithNoParameters(t *testing.T) {
+	assert := assert.New(t)
+	registry := NewFunctionRegistry()
+	assert.Nil(registry.RegisterWithSignature("now", []ArgSpec{}, constantFunction("time")))
+	assert.Nil(registry.Register("anything", constantFunction("ok")))
+	result, err := Search("now()", nil, WithFunctionRegistry(registry))
+	assert.Nil(err)
+	assert.Equal("time", result)
+	_, err = Search("now(@)", nil, WithFunctionRegistry(registry))
+	if runtimeErr, ok := err.(RuntimeError); assert.True(ok, "%v", err) {
+		assert.Equal(ArityMismatch, runtimeErr.Kind)
+	}
+	_, err = Compile("now(@)", WithFunctionRegistry(registry), WithFunctionValidation())
+	assert.IsType(SyntaxError{}, err)
+	result, err = Search("anything(@, @)", nil, WithFunctionRegistry(registry))
+	assert.Nil(err)
+	assert.Equal("ok", result)
+}
+
+func TestConcurrentRegistrationAndSearch(t *testing.T) {
+	registry := NewFunctionRegistry()
+	compiled := MustCompile("length(@)", WithFunctionRegistry(registry))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			registry.Register(fmt.Sprintf("fn%d", i), constantFunction(i))
+		}(i)
+		go func() {
+			defer wg.Done()
+			compiled.Search("abc")
+		}()
+	}
+	wg.Wait()
+	result, err := Search("fn3()", nil, WithFunctionRegistry(registry))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, result)
+}
+
+func TestCustomFunctionSignatureChecksTypes(t *testing.T) {
+	assert := assert.New(t)
+	registry := NewFunctionRegistry()
+	called := false
+	err := registry.RegisterWithSignature("shout", []ArgSpec{
+		{Types: []ArgType{TypeString}},
+	}, func(input []Value, executor *Executor) (interface{}, error) {
+		called = true
+		return input[0].String() + "!", nil
+	})
+	assert.Nil(err)
+
+	result, err := Search("shout(foo)", map[string]interface{}{"foo": "hi"}, WithFunctionRegistry(registry))
+	assert.Nil(err)
+	assert.Equal("hi!", result)
+
+	called = false
+	_, err = Search("shout(`1`)", nil, WithFunctionRegistry(registry))
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), "invalid type")
+	}
+	assert.False(called)
+}
+
+func TestCustomFunctionSignatureChecksArity(t *testing.T) {
+	assert := assert.New(t)
+	registry := NewFunctionRegistry()
+	err := registry.RegisterWithSignature("pad", []ArgSpec{
+		{Types: []ArgType{TypeString}},
+		{Types: []ArgType{TypeNumber}, Optional: true},
+	}, func(input []Value, executor *Executor) (interface{}, error) {
+		return float64(len(input)), nil
+	})
+	assert.Nil(err)
+
+	result, err := Search("pad('a')", nil, WithFunctionRegistry(registry))
+	assert.Nil(err)
+	assert.Equal(1.0, result)
+	result, err = Search("pad('a', `2`)", nil, WithFunctionRegistry(registry))
+	assert.Nil(err)
+	assert.Equal(2.0, result)
+
+	_, err = Search("pad()", nil, WithFunctionRegistry(registry))
+	assert.NotNil(err)
+	_, err = Search("pad('a', `2`, `3`)", nil, WithFunctionRegistry(registry))
+	assert.NotNil(err)
+}
+
+func TestCustomFunctionSignatureVariadic(t *testing.T) {
+	assert := assert.New(t)
+	registry := NewFunctionRegistry()
+	err := registry.RegisterWithSignature("total", []ArgSpec{
+		{Types: []ArgType{TypeNumber}, Variadic: true},
+	}, func(input []Value, executor *Executor) (interface{}, error) {
+		sum := 0.0
+		for _, arg := range input {
+			sum += arg.Float()
+		}
+		return sum, nil
+	})
+	assert.Nil(err)
+
+	result, err := Search("total(`1`, `2`, `3`)", nil, WithFunctionRegistry(registry))
+	assert.Nil(err)
+	assert.Equal(6.0, result)
+	_, err = Search("total()", nil, WithFunctionRegistry(registry))
+	assert.NotNil(err)
+	_, err = Search("total(`1`, 'two')", nil, WithFunctionRegistry(registry))
+	assert.NotNil(err)
+}
+
+func TestCustomFunctionSignatureExpref(t *testing.T) {
+	assert := assert.New(t)
+	registry := NewFunctionRegistry()
+	err := registry.RegisterWithSignature("apply", []ArgSpec{
+		{Types: []ArgType{TypeExpref}},
+		{Types: []ArgType{TypeAny}},
+	}, func(input []Value, executor *Executor) (interface{}, error) {
+		return executor.Execute(input[0], input[1].Interface())
+	})
+	assert.Nil(err)
+
+	data := map[string]interface{}{"foo": map[string]interface{}{"bar": "baz"}}
+	result, err := Search("apply(&bar, foo)", data, WithFunctionRegistry(registry))
+	assert.Nil(err)
+	assert.Equal("baz", result)
+	_, err = Search("apply(bar, foo)", data, WithFunctionRegistry(registry))
+	assert.NotNil(err)
+}
+
+func TestInvalidCustomFunctionSignatures(t *testing.T) {
+	assert := assert.New(t)
+	registry := NewFunctionRegistry()
+	fn := constantFunction(nil)
+	assert.NotNil(registry.RegisterWithSignature("notypes", []ArgSpec{{}}, fn))
+	assert.NotNil(registry.RegisterWithSignature("variadicfirst", []ArgSpec{
+		{Types: []ArgType{TypeAny}, Variadic: true},
+		{Types: []ArgType{TypeAny}},
+	}, fn))
+	assert.NotNil(registry.RegisterWithSignature("requiredafteroptional", []ArgSpec{
+		{Types: []ArgType{TypeAny}, Optional: true},
+		{Types: []ArgType{TypeAny}},
+	}, fn))
+}
@@ -30,6 +30,19 @@ type visit struct {
 // comparisons that have already been seen, which allows short circuiting on
 // recursive types.
 func deepValueEqual(v1, v2 reflect.Value, visited map[visit]bool, depth int) bool {
+	v1, v2 = accessedValue(v1), accessedValue(v2)
+	if v1.IsValid() && v2.IsValid() && v1.Type() != v2.Type() {
+		// Pointers are remembered before what they point to is
+		// converted, so that cyclic data is only compared once.
+		if v1.Kind() == reflect.Ptr && v2.Kind() == reflect.Ptr {
+			v := visit{unsafe.Pointer(v1.Pointer()), unsafe.Pointer(v2.Pointer()), v1.Type()}
+			if visited[v] {
+				return true
+			}
+			visited[v] = true
+		}
+		v1, v2 = jsonFormValue(v1), jsonFormValue(v2)
+	}
 	if !v1.IsValid() || !v2.IsValid() {
 		return v1.IsValid() == v2.IsValid()
 	}
@@ -163,10 +176,45 @@ func deepValueEqual(v1, v2 reflect.Value, visited map[visit]bool, depth int) boo
 	}
 }
 
+// accessedValue replaces an Accessor by the value it stands for, so that
+// it compares equal to the same data in any other form.
+func accessedValue(v reflect.Value) reflect.Value {
+	if v.IsValid() && v.Kind() != reflect.Interface && v.Type().Implements(accessorType) && v.CanInterface() {
+		return reflect.ValueOf(accessorValue(v.Interface().(Accessor)))
+	}
+	return v
+}
+
+// jsonFormValue replaces a struct, a marshaler or a typed slice or map by
+// the []interface{} or map[string]interface{} JMESPath sees it as, so
+// that values of different Go types holding the same JSON compare equal.
+func jsonFormValue(v reflect.Value) reflect.Value {
+	if !v.CanInterface() {
+		return v
+	}
+	value := v.Interface()
+	if converted, ok := jsonValue(value); ok {
+		return reflect.ValueOf(converted)
+	}
+	if items, ok := toInterfaceSlice(value); ok {
+		return reflect.ValueOf(items)
+	}
+	if object, ok := toInterfaceMap(value); ok {
+		return reflect.ValueOf(object)
+	}
+	return reflect.ValueOf(value)
+}
+
 func convert_compare(v1, v2 reflect.Value) bool {
 	var v1_c, v2_c interface{}
 	var err1, err2 error
 
+	// Unexported struct fields can't be turned back into interfaces,
+	// so they can only be compared as values of the same type.
+	if !v1.CanInterface() || !v2.CanInterface() {
+		return v1.Type() == v2.Type() && v1.Comparable() && v1.Equal(v2)
+	}
+
 	// If direct comparison works, use it
 	v1_i := v1.Interface()
 	v2_i := v2.Interface()
@@ -174,6 +222,13 @@ func convert_compare(v1, v2 reflect.Value) bool {
 		return true
 	}
 
+	// Numbers of any Go type, json.Number included, are equal by value.
+	if n1, ok := asNumber(v1_i); ok {
+		if n2, ok := asNumber(v2_i); ok {
+			return n1.cmp(n2) == 0
+		}
+	}
+
 	switch v1.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
 	reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
@@ -220,7 +275,9 @@ func convert_compare(v1, v2 reflect.Value) bool {
 
 // DeepEqual reports whether x and y are ``deeply equal,'' defined as follows.
 // Two values of identical type are deeply equal if one of the following cases applies.
-// Values of distinct types are never deeply equal.
+// Values of distinct types are never deeply equal, except that numbers
+// are compared by value and that structs, marshalers, Accessors and typed
+// slices and maps are compared in the JSON form JMESPath sees them as.
 //
 // Array values are deeply equal when their corresponding elements are deeply equal.
 //
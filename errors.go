@@ -0,0 +1,141 @@
+package jmespath
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RuntimeErrorKind classifies why evaluating an expression failed.
+type RuntimeErrorKind int
+
+const (
+	// InvalidValue is used for errors that fit no other kind, such as a
+	// slice step of zero.
+	InvalidValue RuntimeErrorKind = iota
+	// TypeMismatch means a value had the wrong type for the operation
+	// or function argument it was used with.
+	TypeMismatch
+	// ArityMismatch means a function was called with the wrong number
+	// of arguments.
+	ArityMismatch
+	// UnknownFunction means a function name could not be resolved.
+	UnknownFunction
+	// NotFound means a key looked up by a function does not exist.
+	NotFound
+	// UndefinedVariable means a variable reference is not in the scope of
+	// any binding of its name.
+	UndefinedVariable
+)
+
+func (k RuntimeErrorKind) String() string {
+	switch k {
+	case TypeMismatch:
+		return "TypeMismatch"
+	case ArityMismatch:
+		return "ArityMismatch"
+	case UnknownFunction:
+		return "UnknownFunction"
+	case NotFound:
+		return "NotFound"
+	case UndefinedVariable:
+		return "UndefinedVariable"
+	}
+	return "InvalidValue"
+}
+
+// RuntimeError is returned when evaluating an expression fails, for
+// example when a function is called with an argument of the wrong type.
+type RuntimeError struct {
+	msg          string
+	Kind         RuntimeErrorKind // What went wrong
+	Expression   string           // Expression that generated the RuntimeError, if known
+	Offset       int              // The location in Expression of the part that failed
+	FunctionName string           // Function being called when the error occurred, if any
+	ArgIndex     int              // Index of the offending function argument, or -1
+	located      bool             // Whether Offset has been set from an AST node
+	cause        error            // Error returned by a custom function, if any
+}
+
+func (e RuntimeError) Error() string {
+	return "RuntimeError: " + e.msg
+}
+
+// Unwrap returns the error a custom function failed with, so that it can
+// be inspected with errors.Is and errors.As.
+func (e RuntimeError) Unwrap() error {
+	return e.cause
+}
+
+// HighlightLocation will show where the runtime error occurred.
+// It will place a "^" character on a line below the expression
+// at the start of the part of the expression that failed.
+func (e RuntimeError) HighlightLocation() string {
+	return e.Expression + "\n" + strings.Repeat(" ", e.Offset) + "^"
+}
+
+// runtimeError creates a RuntimeError that is not tied to a function.
+func runtimeError(kind RuntimeErrorKind, format string, a ...interface{}) RuntimeError {
+	return RuntimeError{
+		msg:      fmt.Sprintf(format, a...),
+		Kind:     kind,
+		ArgIndex: -1,
+	}
+}
+
+// functionError creates a RuntimeError for a call to the function name
+// that is not specific to one of its arguments.
+func functionError(name string, kind RuntimeErrorKind, format string, a ...interface{}) RuntimeError {
+	return RuntimeError{
+		msg:          name + "(): " + fmt.Sprintf(format, a...),
+		Kind:         kind,
+		FunctionName: name,
+		ArgIndex:     -1,
+	}
+}
+
+// argError creates a RuntimeError for the argument at index of a call to
+// the function name.
+func argError(name string, index int, kind RuntimeErrorKind, format string, a ...interface{}) RuntimeError {
+	return RuntimeError{
+		msg:          fmt.Sprintf("%s(): argument %d: ", name, index) + fmt.Sprintf(format, a...),
+		Kind:         kind,
+		FunctionName: name,
+		ArgIndex:     index,
+	}
+}
+
+func (e RuntimeError) wrap(cause error) RuntimeError {
+	e.cause = cause
+	return e
+}
+
+// locateError ties err to the AST node whose evaluation produced it.  The
+// innermost node wins: an error that already carries a location is
+// returned unchanged.  Errors that are not RuntimeErrors are wrapped so
+// that every evaluation failure has a location.
+func locateError(err error, node ASTNode) error {
+	runtimeErr, ok := err.(RuntimeError)
+	if !ok {
+		runtimeErr = runtimeError(InvalidValue, "%s", err).wrap(err)
+	}
+	if !runtimeErr.located {
+		runtimeErr.Offset = node.position
+		runtimeErr.located = true
+	}
+	return runtimeErr
+}
+
+// withExpression records the source expression on a RuntimeError or
+// LimitExceededError so that its Offset can be related back to the text
+// the user wrote.
+func withExpression(err error, expression string) error {
+	switch e := err.(type) {
+	case RuntimeError:
+		e.Expression = expression
+		return e
+	case LimitExceededError:
+		e.Expression = expression
+		return e
+	}
+	return err
+}
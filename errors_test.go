@@ -0,0 +1,113 @@
+package jmespath
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func searchRuntimeError(t *testing.T, expression string, data interface{}) RuntimeError {
+	_, err := Search(expression, data)
+	if !assert.Error(t, err) {
+		return RuntimeError{}
+	}
+	runtimeErr, ok := err.(RuntimeError)
+	if !assert.True(t, ok, "expected a RuntimeError, got %T", err) {
+		return RuntimeError{}
+	}
+	return runtimeErr
+}
+
+func TestRuntimeErrorKinds(t *testing.T) {
+	data := map[string]interface{}{"foo": "bar", "obj": map[string]interface{}{}}
+	var tests = []struct {
+		expression   string
+		kind         RuntimeErrorKind
+		functionName string
+		argIndex     int
+	}{
+		{"abs(foo)", TypeMismatch, "abs", 0},
+		{"starts_with(`1`, foo)", TypeMismatch, "starts_with", 0},
+		{"length(foo, foo)", ArityMismatch, "length", -1},
+		{"slice(`[1]`, `0`, `1`, `0`)", InvalidValue, "slice", 3},
+		{"get(obj, 'missing')", NotFound, "get", -1},
+		{"`[1]`[::0]", InvalidValue, "", -1},
+		{"let $a = foo in $b", UndefinedVariable, "", -1},
+		{"foo * `2`", TypeMismatch, "", -1},
+		{"`1` % `0`", InvalidValue, "", -1},
+		{"sum(`[1e308, 1e308]`)", InvalidValue, "sum", -1},
+		{"avg(`[-1e308, -1e308]`)", InvalidValue, "avg", -1},
+	}
+	for _, tt := range tests {
+		runtimeErr := searchRuntimeError(t, tt.expression, data)
+		assert.Equal(t, tt.kind, runtimeErr.Kind, tt.expression)
+		assert.Equal(t, tt.functionName, runtimeErr.FunctionName, tt.expression)
+		assert.Equal(t, tt.argIndex, runtimeErr.ArgIndex, tt.expression)
+	}
+}
+
+func TestRuntimeErrorUnknownFunction(t *testing.T) {
+	assert := assert.New(t)
+	runtimeErr := searchRuntimeError(t, "foo | nope(@)", nil)
+	assert.Equal(UnknownFunction, runtimeErr.Kind)
+	assert.Equal("nope", runtimeErr.FunctionName)
+	assert.Equal(6, runtimeErr.Offset)
+}
+
+func TestRuntimeErrorLocation(t *testing.T) {
+	data := map[string]interface{}{
+		"foo": []interface{}{
+			map[string]interface{}{"n": -1.0},
+			map[string]interface{}{"n": "x"},
+		},
+	}
+	var tests = []struct {
+		expression string
+		offset     int
+	}{
+		{"abs(foo[1].n)", 0},
+		{"foo[*].abs(n)", 7},
+		{"foo[?abs(n) > `0`]", 5},
+		{"{a: foo[0].n, b: sum(foo[*].n)}", 17},
+		{"foo | [0].n | [abs(@), ceil('x')]", 23},
+		{"max_by(foo, &abs(n))", 13},
+		// Errors on the left of a projection or flatten are not
+		// swallowed.
+		{"abs(foo[1].n)[?n]", 0},
+		{"abs(foo[1].n)[]", 0},
+		{"abs(foo[1].n).*", 0},
+		{"let $n = foo[0].n in [abs($n), $m]", 31},
+		{"[foo[0].n, foo[0].n + foo[1].n]", 20},
+	}
+	for _, tt := range tests {
+		runtimeErr := searchRuntimeError(t, tt.expression, data)
+		assert.Equal(t, tt.offset, runtimeErr.Offset, tt.expression)
+		assert.Equal(t, tt.expression, runtimeErr.Expression, tt.expression)
+	}
+}
+
+func TestRuntimeErrorHighlightLocation(t *testing.T) {
+	assert := assert.New(t)
+	compiled := MustCompile("foo.bar | abs(@)")
+	_, err := compiled.Search(map[string]interface{}{"foo": map[string]interface{}{"bar": "baz"}})
+	runtimeErr, ok := err.(RuntimeError)
+	assert.True(ok)
+	assert.Equal("foo.bar | abs(@)\n          ^", runtimeErr.HighlightLocation())
+	assert.Contains(runtimeErr.Error(), "abs()")
+}
+
+func TestRuntimeErrorWrapsCustomFunctionErrors(t *testing.T) {
+	assert := assert.New(t)
+	failure := errors.New("lookup failed")
+	registry := NewFunctionRegistry()
+	registry.Register("lookup", func(input []Value, executor *Executor) (interface{}, error) {
+		return nil, failure
+	})
+	_, err := Search("[@, lookup(@)]", "input", WithFunctionRegistry(registry))
+	runtimeErr, ok := err.(RuntimeError)
+	assert.True(ok)
+	assert.Equal("lookup", runtimeErr.FunctionName)
+	assert.Equal(4, runtimeErr.Offset)
+	assert.True(errors.Is(err, failure))
+}
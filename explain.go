@@ -0,0 +1,222 @@
+package jmespath
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// TraceStep records the evaluation of one AST node.
+type TraceStep struct {
+	Node   ASTNode // The node evaluated
+	Depth  int     // Number of nodes whose evaluation this one is part of
+	Input  string  // The value the node was evaluated against, as JSON
+	Output string  // The result, as JSON, if Err is nil
+	Err    error   // Why the evaluation failed
+}
+
+// A Trace lists the nodes Explain evaluated, in the order their evaluation
+// started, so each node comes before the nodes it evaluated in turn.
+// Values longer than MaxTraceValueLength bytes are truncated and end with
+// "...".
+type Trace []TraceStep
+
+// MaxTraceValueLength is the length the values in a Trace are truncated
+// to.
+const MaxTraceValueLength = 120
+
+// String returns the trace as an indented outline, one step per line,
+// with each node written as an expression.
+func (t Trace) String() string {
+	var b strings.Builder
+	for _, step := range t {
+		b.WriteString(strings.Repeat("  ", step.Depth))
+		b.WriteString(Format(step.Node))
+		b.WriteString(": ")
+		b.WriteString(step.Input)
+		if step.Err != nil {
+			b.WriteString(" -> error: ")
+			b.WriteString(step.Err.Error())
+		} else {
+			b.WriteString(" -> ")
+			b.WriteString(step.Output)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// Explain is like Search, but also returns a trace of every node the
+// evaluation went through, with the value each was evaluated against and
+// what it produced.  The trace is recorded by the tree interpreter, even
+// for an expression compiled WithBytecode, and is returned along with the
+// error if the search fails.
+func (jp *JMESPath) Explain(data interface{}) (interface{}, Trace, error) {
+	intr := jp.intr.search(nil)
+	intr.tracer = &tracer{}
+	result, err := intr.Execute(jp.ast, accessed(data))
+	return result, intr.tracer.steps, withExpression(err, jp.expression)
+}
+
+// tracer records the steps of a search for Explain.
+type tracer struct {
+	steps Trace
+	depth int
+}
+
+// enter records the start of the evaluation of node and returns its step.
+func (t *tracer) enter(node ASTNode, value interface{}) int {
+	t.steps = append(t.steps, TraceStep{Node: node, Depth: t.depth, Input: traceValue(value)})
+	t.depth++
+	return len(t.steps) - 1
+}
+
+// exit records the result of the evaluation started by enter.
+func (t *tracer) exit(step int, result interface{}, err error) {
+	t.depth--
+	if err != nil {
+		t.steps[step].Err = err
+	} else {
+		t.steps[step].Output = traceValue(result)
+	}
+}
+
+// traceValue returns v as JSON, truncated to MaxTraceValueLength.  Only
+// the part of v that is kept is encoded.
+func traceValue(v interface{}) string {
+	var text string
+	if ref, ok := v.(expRef); ok {
+		text = "&" + Format(ref.ref)
+	} else {
+		w := &traceWriter{limit: MaxTraceValueLength + 1}
+		w.writeValue(v)
+		text = w.String()
+	}
+	if len(text) <= MaxTraceValueLength {
+		return text
+	}
+	cut := MaxTraceValueLength - len("...")
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut] + "..."
+}
+
+// traceWriter writes the JSON form of a value, as json.Marshal would, but
+// stops once limit bytes have been written.
+type traceWriter struct {
+	strings.Builder
+	limit int
+}
+
+func (w *traceWriter) full() bool {
+	return w.Len() >= w.limit
+}
+
+func (w *traceWriter) write(s string) {
+	if room := w.limit - w.Len(); len(s) > room {
+		s = s[:room]
+	}
+	w.WriteString(s)
+}
+
+func (w *traceWriter) writeValue(v interface{}) {
+	if w.full() {
+		return
+	}
+	switch v := accessed(v).(type) {
+	case []interface{}:
+		w.writeArray(len(v), func(i int) interface{} { return v[i] })
+		return
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		w.writeObject(keys, func(key string) interface{} { return v[key] })
+		return
+	case string:
+		// Escaping only makes the string longer, so the rest of it
+		// would be cut off.
+		if len(v) > w.limit {
+			v = v[:w.limit]
+		}
+		w.writeJSON(v)
+		return
+	case Accessor:
+		switch v.Kind() {
+		case ArrayKind:
+			w.writeArray(v.Len(), v.Index)
+		case ObjectKind:
+			w.writeObject(v.Keys(), func(key string) interface{} {
+				value, _ := v.Field(key)
+				return value
+			})
+		}
+		return
+	}
+	if converted, ok := jsonValue(v); ok {
+		w.writeValue(converted)
+		return
+	}
+	rv := reflect.ValueOf(v)
+	switch {
+	case rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array:
+		w.writeArray(rv.Len(), func(i int) interface{} { return elementValue(rv.Index(i)) })
+	case rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String:
+		keys := make([]string, 0, rv.Len())
+		for _, key := range rv.MapKeys() {
+			keys = append(keys, key.String())
+		}
+		keyType := rv.Type().Key()
+		w.writeObject(keys, func(key string) interface{} {
+			return elementValue(rv.MapIndex(reflect.ValueOf(key).Convert(keyType)))
+		})
+	default:
+		w.writeJSON(v)
+	}
+}
+
+// writeArray writes the array of n items returned by item.
+func (w *traceWriter) writeArray(n int, item func(int) interface{}) {
+	w.write("[")
+	for i := 0; i < n && !w.full(); i++ {
+		if i > 0 {
+			w.write(",")
+		}
+		w.writeValue(item(i))
+	}
+	w.write("]")
+}
+
+// writeObject writes the object with the given keys, in sorted order, and
+// the values returned by member.
+func (w *traceWriter) writeObject(keys []string, member func(string) interface{}) {
+	sort.Strings(keys)
+	w.write("{")
+	for i, key := range keys {
+		if w.full() {
+			break
+		}
+		if i > 0 {
+			w.write(",")
+		}
+		w.writeJSON(key)
+		w.write(":")
+		w.writeValue(member(key))
+	}
+	w.write("}")
+}
+
+// writeJSON writes v with json.Marshal, or with fmt if it has no JSON
+// form.
+func (w *traceWriter) writeJSON(v interface{}) {
+	if encoded, err := json.Marshal(v); err == nil {
+		w.write(string(encoded))
+	} else {
+		w.write(fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,124 @@
+package jmespath
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplain(t *testing.T) {
+	assert := assert.New(t)
+	data := map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"name": "a", "price": 5.0},
+			map[string]interface{}{"name": "b", "price": 20.0},
+		},
+	}
+	for _, opts := range [][]Option{nil, {WithBytecode()}} {
+		jp := MustCompile("items[?price > `10`].name | [0]", opts...)
+		result, trace, err := jp.Explain(data)
+		assert.Nil(err)
+		assert.Equal("b", result)
+		assert.Equal(`items[?price > `+"`10`"+`].name | [0]: {"items":[{"name":"a","price":5},{"name":"b","price":20}]} -> "b"
+  items[?price > `+"`10`"+`].name: {"items":[{"name":"a","price":5},{"name":"b","price":20}]} -> ["b"]
+    items: {"items":[{"name":"a","price":5},{"name":"b","price":20}]} -> [{"name":"a","price":5},{"name":"b","price":20}]
+    price > `+"`10`"+`: {"name":"a","price":5} -> false
+      price: {"name":"a","price":5} -> 5
+      `+"`10`"+`: {"name":"a","price":5} -> 10
+    price > `+"`10`"+`: {"name":"b","price":20} -> true
+      price: {"name":"b","price":20} -> 20
+      `+"`10`"+`: {"name":"b","price":20} -> 10
+    name: {"name":"b","price":20} -> "b"
+  [0]: ["b"] -> "b"
+    @: ["b"] -> ["b"]
+    [0]: ["b"] -> "b"
+`, trace.String())
+		assert.Equal(ASTPipe, trace[0].Node.Type())
+		assert.Equal(0, trace[0].Depth)
+		assert.Equal(ASTField, trace[2].Node.Type())
+		assert.Equal(2, trace[2].Depth)
+	}
+}
+
+func TestExplainError(t *testing.T) {
+	assert := assert.New(t)
+	jp := MustCompile("a.length(b)")
+	_, trace, err := jp.Explain(map[string]interface{}{"a": map[string]interface{}{"b": 1.0}})
+	assert.NotNil(err)
+	if assert.Len(trace, 4) {
+		assert.Equal("a.length(b): {\"a\":{\"b\":1}} -> error: "+err.Error()+"\n", strings.SplitAfter(trace.String(), "\n")[0])
+		assert.Equal(ASTFunctionExpression, trace[2].Node.Type())
+		assert.NotNil(trace[2].Err)
+		assert.Equal("1", trace[3].Output)
+	}
+}
+
+func TestExplainTruncatesValues(t *testing.T) {
+	assert := assert.New(t)
+	long := strings.Repeat("é", 100)
+	_, trace, err := MustCompile("sort_by(@, &a)").Explain([]interface{}{map[string]interface{}{"a": long}})
+	assert.Nil(err)
+	assert.Equal(`[{"a":"`+strings.Repeat("é", 55)+`...`, trace[0].Input)
+	assert.Len(trace[0].Input, MaxTraceValueLength)
+	assert.Equal("&a", trace[2].Output)
+}
+
+// countingArray is a large Accessor array that counts the elements read.
+type countingArray struct {
+	n     int
+	reads *int
+}
+
+func (a countingArray) Kind() Kind                       { return ArrayKind }
+func (a countingArray) Len() int                         { return a.n }
+func (a countingArray) Keys() []string                   { return nil }
+func (a countingArray) Field(string) (interface{}, bool) { return nil, false }
+func (a countingArray) Scalar() interface{}              { return nil }
+
+func (a countingArray) Index(i int) interface{} {
+	*a.reads++
+	return float64(i)
+}
+
+func TestTraceValueMatchesJSON(t *testing.T) {
+	numbers := make([]int, 1000)
+	for i := range numbers {
+		numbers[i] = i
+	}
+	for _, value := range []interface{}{
+		nil,
+		"<a & b>",
+		[]interface{}{1.0, "two", nil, true},
+		map[string]interface{}{"b": []interface{}{}, "a": map[string]interface{}{}},
+		numbers,
+		map[string]int{"z": 1, "a": 2},
+		&taggedFields{Name: "n", Quoted: 3},
+		marshaledPoint{1, 2},
+		map[string]interface{}{"point": marshaledPoint{1, 2}, "node": scalarNode("s")},
+	} {
+		// Structs are written as the objects JMESPath sees, with sorted
+		// keys.
+		object := value
+		if converted, ok := jsonValue(value); ok {
+			object = converted
+		}
+		encoded, err := json.Marshal(marshalable(object))
+		if !assert.Nil(t, err) {
+			continue
+		}
+		text := string(encoded)
+		if len(text) > MaxTraceValueLength {
+			text = text[:MaxTraceValueLength-len("...")] + "..."
+		}
+		assert.Equal(t, text, traceValue(value))
+	}
+}
+
+func TestTraceValueStopsAtLimit(t *testing.T) {
+	reads := 0
+	text := traceValue(countingArray{n: 1000000, reads: &reads})
+	assert.Len(t, text, MaxTraceValueLength)
+	assert.True(t, reads < MaxTraceValueLength, "read %d elements", reads)
+}
@@ -0,0 +1,396 @@
+package jmespath
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Format returns the canonical JMESPath expression for an AST: parsing it
+// gives back the same tree.  Operators are spaced, string literals are
+// written as raw strings, identifiers are quoted only when they must be,
+// and only the parentheses the tree needs are kept, so two expressions
+// that parse to the same AST format the same.
+//
+// A tree built with NewASTNode that no expression parses to is formatted
+// as an expression that evaluates the same.  The exceptions are slices and
+// flattens that are not the left side of an ASTProjection, which are
+// formatted as projections and so drop null elements, and projections
+// whose right side cannot follow a projection, which skip null elements
+// rather than evaluate them.
+func Format(node ASTNode) string {
+	return formatNode(node).text
+}
+
+// closed is the binding power of text that no following token can extend,
+// such as a literal or a bracketed list.
+const closed = 100
+
+// formatted is an expression's text along with how it binds to the tokens
+// around it.  strength is the lowest binding power of the operators
+// joining its outermost parts: as the right side of an operator, the text
+// is parsed back whole only if strength is higher than the operator's.
+// tail is the binding power the text was last parsed with: an operator
+// following it with a higher binding power would be taken into the text
+// rather than apply to all of it.
+type formatted struct {
+	text     string
+	strength int
+	tail     int
+}
+
+func parenthesized(text string) formatted {
+	return formatted{"(" + text + ")", closed, closed}
+}
+
+// formatLeft formats node as the left side of an operator with binding
+// power bp.
+func formatLeft(node ASTNode, bp int) formatted {
+	f := formatNode(node)
+	if f.tail < bp {
+		return parenthesized(f.text)
+	}
+	return f
+}
+
+// formatRight formats node as the right side of an operator with binding
+// power bp, which the parser reads with parseExpression(bp).
+func formatRight(node ASTNode, bp int) formatted {
+	f := formatNode(node)
+	if f.strength <= bp {
+		return parenthesized(f.text)
+	}
+	return f
+}
+
+// formatBinary formats the children of a left-associative operator.
+func formatBinary(children []ASTNode, bp int, operator string) formatted {
+	parts := []string{formatLeft(children[0], bp).text}
+	tail := closed
+	for i, child := range children[1:] {
+		f := formatRight(child, bp)
+		if i < len(children)-2 && f.tail < bp {
+			f = parenthesized(f.text)
+		}
+		parts = append(parts, f.text)
+		tail = f.tail
+	}
+	return formatted{strings.Join(parts, operator), bp, minInt(bp, tail)}
+}
+
+// formatOperand formats the left side of a bracket or dot.  The
+// ASTIdentity the parser puts on the left of an expression that starts
+// with a bracket or "*" is left out.
+func formatOperand(node ASTNode, bp int) (formatted, bool) {
+	if node.nodeType == ASTIdentity {
+		return formatted{"", closed, closed}, false
+	}
+	return formatLeft(node, bp), true
+}
+
+func formatNode(node ASTNode) formatted {
+	switch node.nodeType {
+	case ASTField:
+		return formatted{formatIdentifier(node.value.(string)), closed, closed}
+	case ASTLiteral:
+		return formatted{formatLiteral(node.value), closed, closed}
+	case ASTCurrentNode, ASTIdentity:
+		return formatted{"@", closed, closed}
+	case ASTRootNode:
+		return formatted{"$", closed, closed}
+	case ASTIndex, ASTSlice:
+		return formatNode(NewASTNode(ASTIndexExpression, nil, NewASTNode(ASTIdentity, nil), node))
+	case ASTIndexExpression:
+		index := node.children[1]
+		if index.nodeType != ASTIndex && index.nodeType != ASTSlice {
+			// It evaluates the same as a subexpression.
+			return formatNode(NewASTNode(ASTSubexpression, nil, node.children...))
+		}
+		left, ok := formatOperand(node.children[0], bindingPowers[tLbracket])
+		f := formatted{left.text + formatIndex(index), closed, closed}
+		if ok {
+			f.strength = minInt(bindingPowers[tLbracket], left.strength)
+		}
+		if index.nodeType == ASTSlice {
+			// The parser projects over every slice.
+			f.tail = projectionTail
+		}
+		return f
+	case ASTFlatten:
+		return formatNode(NewASTNode(ASTProjection, nil, node, NewASTNode(ASTIdentity, nil)))
+	case ASTProjection:
+		left := node.children[0]
+		var f formatted
+		var bp int
+		switch {
+		case left.nodeType == ASTFlatten:
+			bp = bindingPowers[tFlatten]
+			operand, ok := formatOperand(left.children[0], bp)
+			f = formatted{operand.text + "[]", closed, closed}
+			if ok {
+				f.strength = minInt(bp, operand.strength)
+			}
+		case left.nodeType == ASTIndexExpression && len(left.children) == 2 && left.children[1].nodeType == ASTSlice:
+			bp = bindingPowers[tStar]
+			f = formatNode(left)
+		default:
+			bp = bindingPowers[tStar]
+			operand, ok := formatOperand(left, bindingPowers[tLbracket])
+			f = formatted{operand.text + "[*]", closed, closed}
+			if ok {
+				f.strength = minInt(bindingPowers[tLbracket], operand.strength)
+			}
+		}
+		return formatProjected(f, node.children[1], bp)
+	case ASTFilterProjection:
+		bp := bindingPowers[tFilter]
+		operand, ok := formatOperand(node.children[0], bp)
+		f := formatted{operand.text + "[?" + formatRight(node.children[2], 0).text + "]", closed, closed}
+		if ok {
+			f.strength = minInt(bp, operand.strength)
+		}
+		return formatProjected(f, node.children[1], bp)
+	case ASTValueProjection:
+		if node.children[0].nodeType == ASTIdentity {
+			return formatProjected(formatted{"*", closed, closed}, node.children[1], bindingPowers[tStar])
+		}
+		bp := bindingPowers[tDot]
+		left := formatLeft(node.children[0], bp)
+		f := formatted{left.text + ".*", minInt(bp, left.strength), closed}
+		return formatProjected(f, node.children[1], bp)
+	case ASTSubexpression:
+		if n := len(node.children); n > 2 {
+			// Dots associate to the left.
+			left := NewASTNode(ASTSubexpression, nil, node.children[:n-1]...)
+			return formatNode(NewASTNode(ASTSubexpression, nil, left, node.children[n-1]))
+		}
+		bp := bindingPowers[tDot]
+		right := formatNode(node.children[1])
+		switch {
+		case node.children[1].nodeType == ASTMultiSelectList, node.children[1].nodeType == ASTMultiSelectHash:
+		case startsWithIdentifier(right.text) && right.strength > bp:
+			right.tail = minInt(bp, right.tail)
+		default:
+			// A subexpression evaluates the same as a pipe.
+			return formatNode(NewASTNode(ASTPipe, nil, node.children...))
+		}
+		left := formatLeft(node.children[0], bp)
+		return formatted{left.text + "." + right.text, minInt(bp, left.strength), right.tail}
+	case ASTPipe:
+		return formatBinary(node.children, bindingPowers[tPipe], " | ")
+	case ASTOrExpression:
+		return formatBinary(node.children, bindingPowers[tOr], " || ")
+	case ASTAndExpression:
+		return formatBinary(node.children, bindingPowers[tAnd], " && ")
+	case ASTComparator:
+		tok := node.value.(tokType)
+		return formatBinary(node.children, bindingPowers[tok], " "+comparators[tok]+" ")
+	case ASTArithmetic:
+		tok := node.value.(tokType)
+		bp := bindingPowers[tok]
+		left := formatNode(node.children[0])
+		if left.tail < bp && left.tail != projectionTail {
+			// Arithmetic operators end projections rather than
+			// being taken into them.
+			left = parenthesized(left.text)
+		}
+		right := formatRight(node.children[1], bp)
+		text := left.text + " " + arithmeticOperators[tok] + " " + right.text
+		return formatted{text, minInt(bp, left.strength), minInt(bp, right.tail)}
+	case ASTNegate:
+		bp := bindingPowers[tStar]
+		child := formatRight(node.children[0], bp)
+		return formatted{"-" + child.text, closed, minInt(bp, child.tail)}
+	case ASTConditional:
+		bp := bindingPowers[tQuestion]
+		condition := formatLeft(node.children[0], bp)
+		then := formatRight(node.children[1], 0)
+		otherwise := formatRight(node.children[2], bp-1)
+		text := condition.text + " ? " + then.text + " : " + otherwise.text
+		return formatted{text, bp, minInt(bp-1, otherwise.tail)}
+	case ASTNotExpression:
+		bp := bindingPowers[tNot]
+		child := formatRight(node.children[0], bp)
+		return formatted{"!" + child.text, closed, minInt(bp, child.tail)}
+	case ASTExpRef:
+		child := formatRight(node.children[0], bindingPowers[tExpref])
+		if strings.HasPrefix(child.text, "&") {
+			// "&&" would be read as an and.
+			child = parenthesized(child.text)
+		}
+		return formatted{"&" + child.text, closed, bindingPowers[tExpref]}
+	case ASTFunctionExpression:
+		args := make([]string, len(node.children))
+		for i, child := range node.children {
+			args[i] = formatRight(child, 0).text
+		}
+		name := node.value.(string)
+		return formatted{name + "(" + strings.Join(args, ", ") + ")", bindingPowers[tLparen], closed}
+	case ASTMultiSelectList:
+		return formatted{formatList(node.children), closed, closed}
+	case ASTMultiSelectHash:
+		pairs := make([]string, len(node.children))
+		for i, child := range node.children {
+			var key string
+			if child.nodeType == ASTKeyValExprPair {
+				key = formatNode(child.value.(ASTNode)).text
+			} else {
+				key = formatIdentifier(child.value.(string))
+			}
+			pairs[i] = key + ": " + formatRight(child.children[0], 0).text
+		}
+		return formatted{"{" + strings.Join(pairs, ", ") + "}", closed, closed}
+	case ASTLetExpression:
+		last := len(node.children) - 1
+		bindings := make([]string, last)
+		for i, binding := range node.children[:last] {
+			bindings[i] = "$" + binding.value.(string) + " = " + formatRight(binding.children[0], 0).text
+		}
+		body := formatRight(node.children[last], 0)
+		return formatted{"let " + strings.Join(bindings, ", ") + " in " + body.text, closed, 0}
+	case ASTVariable:
+		return formatted{"$" + node.value.(string), closed, closed}
+	case ASTKeyValPair, ASTKeyValExprPair, ASTVariableBinding:
+		return formatNode(node.children[0])
+	}
+	return formatted{"", closed, closed}
+}
+
+// projectionTail is the tail of a projection with nothing after it, which
+// takes in any bracket or dot that follows.
+const projectionTail = 9
+
+// formatProjected appends the right side of a projection, which the
+// parser reads with parseProjectionRHS(bp), to the text of its left side.
+func formatProjected(left formatted, right ASTNode, bp int) formatted {
+	if right.nodeType == ASTIdentity {
+		left.tail = projectionTail
+		return left
+	}
+	f := formatProjectedRHS(right, bp)
+	left.text += f.text
+	left.tail = f.tail
+	return left
+}
+
+func formatProjectedRHS(node ASTNode, bp int) formatted {
+	f := formatNode(node)
+	switch node.nodeType {
+	case ASTMultiSelectHash:
+		// The parser reads ".{" on its own, with nothing after it.
+		f.text = "." + f.text
+		return f
+	case ASTMultiSelectList:
+		// Keep the dot, so that the list is not read as a bracket step
+		// that could take in the operators after it.
+		f.text = "." + f.text
+		return f
+	}
+	if f.strength > bp {
+		switch {
+		case strings.HasPrefix(f.text, "[") && !strings.HasPrefix(f.text, "[]"):
+			f.tail = minInt(bp, f.tail)
+			return f
+		case startsWithIdentifier(f.text), strings.HasPrefix(f.text, "*"):
+			f.text = "." + f.text
+			f.tail = minInt(bp, f.tail)
+			return f
+		}
+	}
+	// Only a bracket or dot can follow a projection, so evaluate the node
+	// on the element with [node][0].
+	return formatted{formatList([]ASTNode{node}) + "[0]", bindingPowers[tLbracket], bp}
+}
+
+// formatList formats the elements of a multi-select list.
+func formatList(children []ASTNode) string {
+	elements := make([]string, len(children))
+	for i, child := range children {
+		elements[i] = formatRight(child, 0).text
+	}
+	if len(elements) == 1 && elements[0] == "*" {
+		// "[*]" would be read as a projection.
+		elements[0] = "(*)"
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+func formatIndex(node ASTNode) string {
+	if node.nodeType == ASTIndex {
+		return "[" + strconv.Itoa(node.value.(int)) + "]"
+	}
+	parts := node.value.([]*int)
+	bounds := make([]string, 0, 3)
+	for i, part := range parts {
+		if i == 2 && part == nil {
+			break
+		}
+		if part == nil {
+			bounds = append(bounds, "")
+		} else {
+			bounds = append(bounds, strconv.Itoa(*part))
+		}
+	}
+	return "[" + strings.Join(bounds, ":") + "]"
+}
+
+// startsWithIdentifier reports whether text starts with an identifier,
+// quoted or not.
+func startsWithIdentifier(text string) bool {
+	if text == "" {
+		return false
+	}
+	c := text[0]
+	return c == '"' || c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// formatIdentifier returns name as an unquoted identifier if it can be
+// one, and as a quoted identifier otherwise.
+func formatIdentifier(name string) string {
+	if isUnquotedIdentifier(name) {
+		return name
+	}
+	return encodeJSON(name)
+}
+
+// isUnquotedIdentifier reports whether name can be written without quotes,
+// as the lexer reads unquoted identifiers and variable names.
+func isUnquotedIdentifier(name string) bool {
+	for i, c := range name {
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// formatLiteral returns a string as a raw string literal, and other values
+// as JSON literals.
+func formatLiteral(value interface{}) string {
+	if s, ok := value.(string); ok && !strings.HasSuffix(s, `\`) {
+		// Only a quote can be escaped in a raw string, and a trailing
+		// backslash would escape the closing quote.
+		return "'" + strings.Replace(s, "'", `\'`, -1) + "'"
+	}
+	return "`" + strings.Replace(encodeJSON(value), "`", "\\`", -1) + "`"
+}
+
+// encodeJSON encodes v without escaping HTML characters.
+func encodeJSON(v interface{}) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
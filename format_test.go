@@ -0,0 +1,217 @@
+package jmespath
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withoutPositions returns ast with the position of every node cleared, so
+// that trees parsed from differently spaced expressions compare equal.
+func withoutPositions(ast ASTNode) ASTNode {
+	return Rewrite(ast, func(node ASTNode) ASTNode {
+		node.position = 0
+		return node
+	})
+}
+
+func TestFormat(t *testing.T) {
+	var tests = []struct {
+		expression string
+		expected   string
+	}{
+		{"foo.bar", "foo.bar"},
+		{"foo . \"bar\" . \"baz qux\"", "foo.bar.\"baz qux\""},
+		{"\"a\\u00e9\"", "\"aé\""},
+		{"foo[0][-1]", "foo[0][-1]"},
+		{"foo[ 1 : 2 ]", "foo[1:2]"},
+		{"foo[::-1]", "foo[::-1]"},
+		{"foo[:2:]", "foo[:2]"},
+		{"foo[*].bar[*].baz", "foo[*].bar[*].baz"},
+		{"foo[*] . bar", "foo[*].bar"},
+		{"(foo[*]).bar", "(foo[*]).bar"},
+		{"(foo[*])[0]", "(foo[*])[0]"},
+		{"foo[*][0]", "foo[*][0]"},
+		{"(foo.bar)[0]", "(foo.bar)[0]"},
+		{"foo.bar[0]", "foo.bar[0]"},
+		{"foo[][]", "foo[][]"},
+		{"(foo[])[0]", "(foo[])[0]"},
+		{"foo[][0]", "foo[][0]"},
+		{"[]", "[]"},
+		{"*.foo", "*.foo"},
+		{"foo.*.bar", "foo.*.bar"},
+		{"(foo.*)[?a]", "(foo.*)[?a]"},
+		{"foo.*[?a]", "foo.*[?a]"},
+		{"foo[?a == `1`].b", "foo[?a == `1`].b"},
+		{"foo[?a]|[0]", "foo[?a] | [0]"},
+		{"[?a]", "[?a]"},
+		{"[0]", "[0]"},
+		{"[*]", "[*]"},
+		{"[(*)]", "[(*)]"},
+		{"[*, a]", "[*, a]"},
+		{"a || (b || c)", "a || (b || c)"},
+		{"(a || b) || c", "a || b || c"},
+		{"a && (b || c)", "a && (b || c)"},
+		{"(a && b) || c", "a && b || c"},
+		{"!(a.b)", "!(a.b)"},
+		{"(!a).b", "!a.b"},
+		{"!!a", "!!a"},
+		{"a == (b == c)", "a == (b == c)"},
+		{"(a | b).c", "(a | b).c"},
+		{"a | (b | c)", "a | (b | c)"},
+		{"sort_by(foo, &bar.baz)[0]", "sort_by(foo, &bar.baz)[0]"},
+		{"map(&(a | b), c)", "map(&a | b, c)"},
+		{"(&a).b", "(&a).b"},
+		{"&(&a)", "&(&a)"},
+		{"foo.bar(@)", "foo.bar(@)"},
+		{"{a: b, \"c d\": e}", "{a: b, \"c d\": e}"},
+		{"{&upper(name): value, a: b}", "{&upper(name): value, a: b}"},
+		{"foo.{a: b}", "foo.{a: b}"},
+		{"foo[*].{a: b}[0]", "foo[*].{a: b}[0]"},
+		{"foo.[a, b]", "foo.[a, b]"},
+		{"foo[*].[a, b]", "foo[*].[a, b]"},
+		{"`\"raw\"`", "'raw'"},
+		{"'it\\'s'", "'it\\'s'"},
+		{"`\"back\\\\\"`", "`\"back\\\\\"`"},
+		{"`{\"b\": [1, 2.5, null], \"a\": \"<\\`\"}`", "`{\"a\":\"<\\`\",\"b\":[1,2.5,null]}`"},
+		{"$.a", "$.a"},
+		{"a[?b == $.c]", "a[?b == $.c]"},
+		{"@", "@"},
+		{"let  $a=b,$c = d in [$a, $c]", "let $a = b, $c = d in [$a, $c]"},
+		{"let $a = b in c | d", "let $a = b in c | d"},
+		{"(let $a = b in c) | d", "(let $a = b in c) | d"},
+		{"(let $a = b in c).d", "(let $a = b in c).d"},
+		{"let $a = (let $b = c in $b) in $a", "let $a = let $b = c in $b in $a"},
+		{"let $a = b | c in $a", "let $a = b | c in $a"},
+		{"a || let $b = c in $b", "a || let $b = c in $b"},
+		{"sort_by(a, &let $b = c in $b)", "sort_by(a, &let $b = c in $b)"},
+		{"let.in", "let.in"},
+		{"a+b*c", "a + b * c"},
+		{"(a + b) * c", "(a + b) * c"},
+		{"a - (b - c)", "a - (b - c)"},
+		{"(a - b) - c", "a - b - c"},
+		{"a // b % c", "a // b % c"},
+		{"-(a * b)", "-(a * b)"},
+		{"-a.b", "-a.b"},
+		{"- -a", "--a"},
+		{"(-a)[0]", "(-a)[0]"},
+		{"-a[*].b", "-a[*].b"},
+		{"foo[*].a * `2`", "foo[*].a * `2`"},
+		{"(a * b)[*].c", "(a * b)[*].c"},
+		{"[].[A] % B", "[].[A] % B"},
+		{"a[*].[b] + c", "a[*].[b] + c"},
+		{"a[?x].[b] * c", "a[?x].[b] * c"},
+		{"a.*.[b] // c", "a.*.[b] // c"},
+		{"a[].b - c", "a[].b - c"},
+		{"a[][0] + b", "a[][0] + b"},
+		{"a[*][0] + b", "a[*][0] + b"},
+		{"a[?x][0] + b", "a[?x][0] + b"},
+		{"a[*].{b: c} % d", "a[*].{b: c} % d"},
+		{"a[].[b] * c[].[d]", "a[].[b] * c[].[d]"},
+		{"a + b[].[c]", "a + b[].[c]"},
+		{"-a[].[b]", "-a[].[b]"},
+		{"a * b > c - d", "a * b > c - d"},
+		{"a * (b > c)", "a * (b > c)"},
+		{"!a * b", "!a * b"},
+		{"!(a * b)", "!(a * b)"},
+		{"map(&a * b, c)", "map(&a * b, c)"},
+		{"(a | b) + c", "(a | b) + c"},
+		{"a?b:c", "a ? b : c"},
+		{"a ? b : (c ? d : e)", "a ? b : c ? d : e"},
+		{"(a ? b : c) ? d : e", "(a ? b : c) ? d : e"},
+		{"a ? (b ? c : d) : e", "a ? b ? c : d : e"},
+		{"a ? b | c : d", "a ? b | c : d"},
+		{"a ? b : (c | d)", "a ? b : (c | d)"},
+		{"a ? b : c | d", "a ? b : c | d"},
+		{"(a | b) ? c : d", "(a | b) ? c : d"},
+		{"a || b ? c && d : e || f", "a || b ? c && d : e || f"},
+		{"(a ? b : c) || d", "(a ? b : c) || d"},
+		{"a || (b ? c : d)", "a || (b ? c : d)"},
+		{"(a ? b : c).d", "(a ? b : c).d"},
+		{"a[*].b ? c : d", "a[*].b ? c : d"},
+		{"(let $a = b in $a) ? c : d", "(let $a = b in $a) ? c : d"},
+		{"let $a = b in $a ? c : d", "let $a = b in $a ? c : d"},
+		{"{a: b ? c : d}", "{a: b ? c : d}"},
+		{"map(&(a ? b : c), d)", "map(&a ? b : c, d)"},
+	}
+	for _, tt := range tests {
+		ast := MustCompile(tt.expression).AST()
+		formatted := Format(ast)
+		assert.Equal(t, tt.expected, formatted, tt.expression)
+		reparsed, err := NewParser().Parse(formatted)
+		if assert.Nil(t, err, tt.expression) {
+			assert.Equal(t, withoutPositions(ast), withoutPositions(reparsed), tt.expression)
+		}
+	}
+}
+
+// TestFormatBuiltTrees formats trees that no expression parses to, and
+// checks that the text evaluates the same.
+func TestFormatBuiltTrees(t *testing.T) {
+	field := func(name string) ASTNode { return NewASTNode(ASTField, name) }
+	identity := NewASTNode(ASTIdentity, nil)
+	var tests = []struct {
+		ast      ASTNode
+		expected string
+	}{
+		{NewASTNode(ASTSubexpression, nil, field("a"), NewASTNode(ASTSubexpression, nil, field("b"), field("c"))), "a | b.c"},
+		{NewASTNode(ASTSubexpression, nil, field("a"), NewASTNode(ASTIndexExpression, nil, identity, NewASTNode(ASTIndex, 0))), "a | [0]"},
+		{NewASTNode(ASTPipe, nil, field("a"), field("b"), field("c")), "a | b | c"},
+		{NewASTNode(ASTProjection, nil, field("a"), NewASTNode(ASTNotExpression, nil, field("b"))), "a[*][!b][0]"},
+		{NewASTNode(ASTProjection, nil, field("a"), NewASTNode(ASTOrExpression, nil, field("b"), field("c"))), "a[*][b || c][0]"},
+		{NewASTNode(ASTIndexExpression, nil, field("a"), field("b")), "a.b"},
+		{NewASTNode(ASTIndex, 2), "[2]"},
+		{NewASTNode(ASTLiteral, "a\\"), "`\"a\\\\\"`"},
+	}
+	data := map[string]interface{}{
+		"a": []interface{}{
+			map[string]interface{}{"b": false, "c": "x"},
+			map[string]interface{}{"b": true},
+		},
+		"b": map[string]interface{}{"c": 1.0},
+	}
+	for _, tt := range tests {
+		formatted := Format(tt.ast)
+		if assert.Equal(t, tt.expected, formatted) {
+			jp, err := CompileAST(tt.ast)
+			assert.Nil(t, err, formatted)
+			expected, err := jp.Search(data)
+			assert.Nil(t, err, formatted)
+			actual, err := Search(formatted, data)
+			assert.Nil(t, err, formatted)
+			assert.Equal(t, expected, actual, formatted)
+		}
+	}
+}
+
+// TestFormatCompliance checks that every expression in the compliance
+// tests formats to one that parses back to the same tree, and that
+// formatting is idempotent.
+func TestFormatCompliance(t *testing.T) {
+	for _, filename := range whiteListed {
+		var testSuites []TestSuite
+		data, err := ioutil.ReadFile(filename)
+		if !assert.Nil(t, err) {
+			continue
+		}
+		assert.Nil(t, json.Unmarshal(data, &testSuites))
+		for _, testsuite := range testSuites {
+			for _, testcase := range testsuite.TestCases {
+				ast, err := NewParser().Parse(testcase.Expression)
+				if err != nil {
+					continue
+				}
+				formatted := Format(ast)
+				reparsed, err := NewParser().Parse(formatted)
+				if !assert.Nil(t, err, "%s formatted as %s", testcase.Expression, formatted) {
+					continue
+				}
+				assert.Equal(t, withoutPositions(ast), withoutPositions(reparsed),
+					"%s formatted as %s", testcase.Expression, formatted)
+				assert.Equal(t, formatted, Format(reparsed), testcase.Expression)
+			}
+		}
+	}
+}
@@ -2,12 +2,10 @@ package jmespath
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	conv "github.com/cstockton/go-conv"
 	"math"
 	"math/rand"
-	"reflect"
 	"sort"
 	"strings"
 	"time"
@@ -35,146 +33,30 @@ type functionEntry struct {
 	arguments []argSpec
 	handler   jpFunction
 	hasExpRef bool
+	builtin   bool // Whether the numbers it returns are subject to WithExactIntegers
+	unchecked bool // Whether any arguments are passed as they are, for Register
 }
 
 type argSpec struct {
 	types    []jpType
 	variadic bool
-}
-
-type byExprString struct {
-	intr     *treeInterpreter
-	root     interface{}
-	node     ASTNode
-	items    []interface{}
-	hasError bool
+	optional bool
 }
 
 func init() {
 	rand.Seed(time.Now().UTC().UnixNano())
 }
 
-func (a *byExprString) Len() int {
-	return len(a.items)
-}
-func (a *byExprString) Swap(i, j int) {
-	a.items[i], a.items[j] = a.items[j], a.items[i]
-}
-func (a *byExprString) Less(i, j int) bool {
-	first, err := a.intr.execute(a.node, a.items[i], a.root)
-	if err != nil {
-		a.hasError = true
-		// Return a dummy value.
-		return true
-	}
-	ith, ok := first.(string)
-	if !ok {
-		a.hasError = true
-		return true
-	}
-	second, err := a.intr.execute(a.node, a.items[j], a.root)
-	if err != nil {
-		a.hasError = true
-		// Return a dummy value.
-		return true
-	}
-	jth, ok := second.(string)
-	if !ok {
-		a.hasError = true
-		return true
-	}
-	return ith < jth
-}
-
-type byExprFloat struct {
-	intr     *treeInterpreter
-	root     interface{}
-	node     ASTNode
-	items    []interface{}
-	hasError bool
-}
-
-func (a *byExprFloat) Len() int {
-	return len(a.items)
-}
-func (a *byExprFloat) Swap(i, j int) {
-	a.items[i], a.items[j] = a.items[j], a.items[i]
-}
-func (a *byExprFloat) Less(i, j int) bool {
-	first, err := a.intr.execute(a.node, a.items[i], a.root)
-	if err != nil {
-		a.hasError = true
-		// Return a dummy value.
-		return true
-	}
-	ith, ok := first.(float64)
-	if !ok {
-		a.hasError = true
-		return true
-	}
-	second, err := a.intr.execute(a.node, a.items[j], a.root)
-	if err != nil {
-		a.hasError = true
-		// Return a dummy value.
-		return true
-	}
-	jth, ok := second.(float64)
-	if !ok {
-		a.hasError = true
-		return true
-	}
-	return ith < jth
-}
-
-type byExprJsonNum struct {
-	intr     *treeInterpreter
-	root     interface{}
-	node     ASTNode
-	items    []interface{}
-	hasError bool
-}
-
-func (a *byExprJsonNum) Len() int {
-	return len(a.items)
-}
-func (a *byExprJsonNum) Swap(i, j int) {
-	a.items[i], a.items[j] = a.items[j], a.items[i]
-}
-func (a *byExprJsonNum) Less(i, j int) bool {
-	first, err := a.intr.execute(a.node, a.items[i], a.root)
-	if err != nil {
-		a.hasError = true
-		// Return a dummy value.
-		return true
-	}
-	ith, err := conv.Float64(first)
-	if err != nil {
-		a.hasError = true
-		return true
-	}
-	second, err := a.intr.execute(a.node, a.items[j], a.root)
-	if err != nil {
-		a.hasError = true
-		// Return a dummy value.
-		return true
-	}
-	jth, err := conv.Float64(second)
-	if err != nil {
-		a.hasError = true
-		return true
-	}
-	return ith < jth
+type functionCaller struct {
+	registry *FunctionRegistry
 }
 
-type functionCaller struct {
-	functionTable  map[string]functionEntry
-	customFnCaller *customFunctionCaller
+func newFunctionCaller(registry *FunctionRegistry) *functionCaller {
+	return &functionCaller{registry: registry}
 }
 
-func newFunctionCaller() *functionCaller {
-	caller := &functionCaller{}
-	caller.customFnCaller = newCustomFunctionCaller()
-	caller.functionTable = map[string]functionEntry{
+func builtinFunctions() map[string]functionEntry {
+	functions := map[string]functionEntry{
 		"length": {
 			name: "length",
 			arguments: []argSpec{
@@ -243,7 +125,7 @@ func newFunctionCaller() *functionCaller {
 			handler: jpfFloor,
 		},
 		"map": {
-			name: "amp",
+			name: "map",
 			arguments: []argSpec{
 				{types: []jpType{jpExpref}},
 				{types: []jpType{jpArray}},
@@ -318,6 +200,13 @@ func newFunctionCaller() *functionCaller {
 			},
 			handler: jpfValues,
 		},
+		"descendants": {
+			name: "descendants",
+			arguments: []argSpec{
+				{types: []jpType{jpAny}},
+			},
+			handler: jpfDescendants,
+		},
 		"get": {
 			name: "get",
 			arguments: []argSpec{
@@ -438,40 +327,77 @@ func newFunctionCaller() *functionCaller {
 			handler: jpfNotNull,
 		},
 	}
-	return caller
+	for name, entry := range functions {
+		entry.builtin = true
+		functions[name] = entry
+	}
+	return functions
 }
 
 func (e *functionEntry) resolveArgs(arguments []interface{}) ([]interface{}, error) {
-	if len(e.arguments) == 0 {
+	if e.unchecked {
 		return arguments, nil
 	}
-	if !e.arguments[len(e.arguments)-1].variadic {
-		if len(e.arguments) != len(arguments) {
-			return nil, errors.New("incorrect number of args")
+	if err := e.checkArity(len(arguments)); err != nil {
+		return nil, err
+	}
+	converted := false
+	for i, userArg := range arguments {
+		spec := e.arguments[len(e.arguments)-1]
+		if i < len(e.arguments) {
+			spec = e.arguments[i]
 		}
-		for i, spec := range e.arguments {
-			userArg := arguments[i]
-			err := spec.typeCheck(userArg)
-			if err != nil {
-				return nil, err
+		if err := spec.typeCheck(userArg); err != nil {
+			// Structs and marshalers are accepted in their JSON form.
+			jsonArg, ok := jsonValue(userArg)
+			if !ok || spec.typeCheck(jsonArg) != nil {
+				return nil, argError(e.name, i, TypeMismatch, "%s", err)
 			}
+			if !converted {
+				arguments = append([]interface{}(nil), arguments...)
+				converted = true
+			}
+			arguments[i] = jsonArg
 		}
-		return arguments, nil
-	}
-	if len(arguments) < len(e.arguments) {
-		return nil, errors.New("Invalid arity.")
 	}
 	return arguments, nil
 }
 
+// checkArity reports whether the function can be called with count
+// arguments.  Functions registered without a signature accept any number.
+func (e *functionEntry) checkArity(count int) error {
+	if e.unchecked {
+		return nil
+	}
+	variadic := len(e.arguments) > 0 && e.arguments[len(e.arguments)-1].variadic
+	required := 0
+	for _, spec := range e.arguments {
+		if !spec.optional {
+			required++
+		}
+	}
+	if count < required || (!variadic && count > len(e.arguments)) {
+		return functionError(e.name, ArityMismatch, "incorrect number of args: expected %s, got %d",
+			e.arityString(required, variadic), count)
+	}
+	return nil
+}
+
+func (e *functionEntry) arityString(required int, variadic bool) string {
+	switch {
+	case variadic:
+		return fmt.Sprintf("at least %d", required)
+	case required != len(e.arguments):
+		return fmt.Sprintf("%d to %d", required, len(e.arguments))
+	}
+	return fmt.Sprintf("%d", required)
+}
+
 func (a *argSpec) typeCheck(arg interface{}) error {
 	for _, t := range a.types {
 		switch t {
 		case jpNumber:
-			if _, ok := arg.(float64); ok {
-				return nil
-			}
-			if _, ok := arg.(json.Number); ok {
+			if isNumber(arg) {
 				return nil
 			}
 		case jpString:
@@ -483,11 +409,11 @@ func (a *argSpec) typeCheck(arg interface{}) error {
 				return nil
 			}
 		case jpObject:
-			if _, ok := arg.(map[string]interface{}); ok {
+			if isMapType(arg) {
 				return nil
 			}
 		case jpArrayNumber:
-			if _, ok := toArrayNum(arg); ok {
+			if _, _, ok := toArrayNum(arg); ok {
 				return nil
 			}
 		case jpArrayString:
@@ -502,16 +428,13 @@ func (a *argSpec) typeCheck(arg interface{}) error {
 			}
 		}
 	}
-	return fmt.Errorf("Invalid type for: %v, expected: %#v", arg, a.types)
+	return fmt.Errorf("invalid type for: %v, expected one of: %v", arg, a.types)
 }
 
 func (f *functionCaller) CallFunction(name string, arguments []interface{}, intr *treeInterpreter, rootValue interface{}) (interface{}, error) {
-	if f.customFnCaller.canCall(name) {
-		return f.customFnCaller.callFunction(name, arguments, intr, rootValue)
-	}
-	entry, ok := f.functionTable[name]
+	entry, ok := f.registry.lookup(name)
 	if !ok {
-		return nil, errors.New("unknown function: " + name)
+		return nil, functionError(name, UnknownFunction, "unknown function")
 	}
 	resolvedArgs, err := entry.resolveArgs(arguments)
 	if err != nil {
@@ -523,28 +446,35 @@ func (f *functionCaller) CallFunction(name string, arguments []interface{}, intr
 		extra = append(extra, rootValue)
 		resolvedArgs = append(extra, resolvedArgs...)
 	}
-	return entry.handler(resolvedArgs)
+	result, err := entry.handler(resolvedArgs)
+	if err != nil || !entry.builtin || intr.exactIntegers {
+		return result, err
+	}
+	return floatNumbers(result), nil
 }
 
 func jpfAbs(arguments []interface{}) (interface{}, error) {
-	num, err := conv.Float64(arguments[0])
-	if err != nil {
-		panic(err)
+	num, ok := asNumber(arguments[0])
+	if !ok {
+		return nil, argError("abs", 0, TypeMismatch, "expected a number")
+	}
+	switch {
+	case num.kind == floatNumber:
+		return math.Abs(num.f), nil
+	case num.kind == intNumber && num.i < 0:
+		return number{kind: uintNumber, u: uint64(-(num.i + 1)) + 1}.value(), nil
 	}
-	return math.Abs(num), nil
+	return num.value(), nil
 }
 
 func jpfLength(arguments []interface{}) (interface{}, error) {
 	arg := arguments[0]
 	if c, ok := arg.(string); ok {
-		return float64(utf8.RuneCountInString(c)), nil
-	} else if isSliceType(arg) {
-		v := reflect.ValueOf(arg)
-		return float64(v.Len()), nil
-	} else if c, ok := arg.(map[string]interface{}); ok {
-		return float64(len(c)), nil
-	}
-	return nil, errors.New("could not compute length()")
+		return int64(utf8.RuneCountInString(c)), nil
+	} else if isSliceType(arg) || isMapType(arg) {
+		return int64(valueLen(arg)), nil
+	}
+	return nil, functionError("length", TypeMismatch, "could not compute length")
 }
 
 func jpfStartsWith(arguments []interface{}) (interface{}, error) {
@@ -554,26 +484,31 @@ func jpfStartsWith(arguments []interface{}) (interface{}, error) {
 }
 
 func jpfAvg(arguments []interface{}) (interface{}, error) {
-	// We've already type checked the value so we can safely use
-	// type assertions.
-	args := arguments[0].([]interface{})
-	length := float64(len(args))
+	_, args, ok := toArrayNum(arguments[0])
+	if !ok {
+		return nil, argError("avg", 0, TypeMismatch, "expected an array of numbers")
+	}
+	if len(args) == 0 {
+		return nil, nil
+	}
 	numerator := 0.0
 	for _, n := range args {
-		val, err := conv.Float64(n)
-		if err != nil {
-			panic(err)
-		}
-		numerator += val
+		numerator += n.float()
+	}
+	if math.IsInf(numerator, 0) {
+		return nil, functionError("avg", InvalidValue, "result out of range")
 	}
-	return numerator / length, nil
+	return numerator / float64(len(args)), nil
 }
 func jpfCeil(arguments []interface{}) (interface{}, error) {
-	val, err := conv.Float64(arguments[0])
-	if err != nil {
-		panic(err)
+	val, ok := asNumber(arguments[0])
+	if !ok {
+		return nil, argError("ceil", 0, TypeMismatch, "expected a number")
+	}
+	if val.isInteger() {
+		return val.value(), nil
 	}
-	return math.Ceil(val), nil
+	return math.Ceil(val.f), nil
 }
 func jpfContains(arguments []interface{}) (interface{}, error) {
 	search := arguments[0]
@@ -584,8 +519,11 @@ func jpfContains(arguments []interface{}) (interface{}, error) {
 		}
 		return false, nil
 	}
-	// Otherwise this is a generic contains for []interface{}
-	general := search.([]interface{})
+	// Otherwise this is a generic contains for an array.
+	general, ok := toInterfaceSlice(search)
+	if !ok {
+		return nil, argError("contains", 0, TypeMismatch, "expected an array or string")
+	}
 	for _, item := range general {
 		if DeepEqual(item, el) {
 			return true, nil
@@ -594,8 +532,14 @@ func jpfContains(arguments []interface{}) (interface{}, error) {
 	return false, nil
 }
 func jpfContainsAny(arguments []interface{}) (interface{}, error) {
-	search := arguments[0].([]interface{})
-	els := arguments[1].([]interface{})
+	search, ok := toInterfaceSlice(arguments[0])
+	if !ok {
+		return nil, argError("contains_any", 0, TypeMismatch, "expected an array")
+	}
+	els, ok := toInterfaceSlice(arguments[1])
+	if !ok {
+		return nil, argError("contains_any", 1, TypeMismatch, "expected an array")
+	}
 	for _, item := range search {
 		for _, el := range els {
 			if DeepEqual(item, el) {
@@ -611,21 +555,26 @@ func jpfEndsWith(arguments []interface{}) (interface{}, error) {
 	return strings.HasSuffix(search, suffix), nil
 }
 func jpfFloor(arguments []interface{}) (interface{}, error) {
-	val, err := conv.Float64(arguments[0])
-	if err != nil {
-		panic(err)
+	val, ok := asNumber(arguments[0])
+	if !ok {
+		return nil, argError("floor", 0, TypeMismatch, "expected a number")
+	}
+	if val.isInteger() {
+		return val.value(), nil
 	}
-	return math.Floor(val), nil
+	return math.Floor(val.f), nil
 }
 func jpfMap(arguments []interface{}) (interface{}, error) {
 	intr := arguments[0].(*treeInterpreter)
-	root := arguments[1].(interface{})
+	root := arguments[1]
 	exp := arguments[2].(expRef)
-	node := exp.ref
-	arr := arguments[3].([]interface{})
+	arr, ok := toInterfaceSlice(arguments[3])
+	if !ok {
+		return nil, argError("map", 1, TypeMismatch, "expected an array")
+	}
 	mapped := make([]interface{}, 0, len(arr))
 	for _, value := range arr {
-		current, err := intr.execute(node, value, root)
+		current, err := intr.executeRef(exp, value, root)
 		if err != nil {
 			return nil, err
 		}
@@ -634,20 +583,17 @@ func jpfMap(arguments []interface{}) (interface{}, error) {
 	return mapped, nil
 }
 func jpfMax(arguments []interface{}) (interface{}, error) {
-	if items, ok := toArrayNum(arguments[0]); ok {
+	if items, nums, ok := toArrayNum(arguments[0]); ok {
 		if len(items) == 0 {
 			return nil, nil
 		}
-		if len(items) == 1 {
-			return items[0], nil
-		}
-		best := items[0]
-		for _, item := range items[1:] {
-			if item > best {
-				best = item
+		best := 0
+		for i := range nums[1:] {
+			if nums[i+1].cmp(nums[best]) > 0 {
+				best = i + 1
 			}
 		}
-		return best, nil
+		return items[best], nil
 	}
 	// Otherwise we're dealing with a max() of strings.
 	items, _ := toArrayStr(arguments[0])
@@ -668,7 +614,7 @@ func jpfMax(arguments []interface{}) (interface{}, error) {
 func jpfMerge(arguments []interface{}) (interface{}, error) {
 	final := make(map[string]interface{})
 	for _, m := range arguments {
-		mapped := m.(map[string]interface{})
+		mapped, _ := toInterfaceMap(m)
 		for key, value := range mapped {
 			final[key] = value
 		}
@@ -677,105 +623,62 @@ func jpfMerge(arguments []interface{}) (interface{}, error) {
 }
 func jpfMaxBy(arguments []interface{}) (interface{}, error) {
 	intr := arguments[0].(*treeInterpreter)
-	root := arguments[1].(interface{})
-	arr := arguments[2].([]interface{})
+	root := arguments[1]
+	arr, ok := toInterfaceSlice(arguments[2])
+	if !ok {
+		return nil, argError("max_by", 0, TypeMismatch, "expected an array")
+	}
 	exp := arguments[3].(expRef)
-	node := exp.ref
 	if len(arr) == 0 {
 		return nil, nil
 	} else if len(arr) == 1 {
 		return arr[0], nil
 	}
-	start, err := intr.execute(node, arr[0], root)
+	compare, err := exprKeys("max_by", intr, root, exp, arr)
 	if err != nil {
 		return nil, err
 	}
-	switch t := start.(type) {
-	case float64:
-		bestVal := t
-		bestItem := arr[0]
-		for _, item := range arr[1:] {
-			result, err := intr.execute(node, item, root)
-			if err != nil {
-				return nil, err
-			}
-			current, ok := result.(float64)
-			if !ok {
-				return nil, errors.New("invalid type, must be number")
-			}
-			if current > bestVal {
-				bestVal = current
-				bestItem = item
-			}
-		}
-		return bestItem, nil
-	case json.Number:
-		bestVal, err := t.Float64()
-		if err != nil {
-			return nil, err
-		}
-		bestItem := arr[0]
-		for _, item := range arr[1:] {
-			result, err := intr.execute(node, item, root)
-			if err != nil {
-				return nil, err
-			}
-			current, err := conv.Float64(result)
-			if err != nil {
-				return nil, err
-			}
-			if current > bestVal {
-				bestVal = current
-				bestItem = item
-			}
+	best := 0
+	for i := 1; i < len(arr); i++ {
+		if compare(i, best) > 0 {
+			best = i
 		}
-		return bestItem, nil
-	case string:
-		bestVal := t
-		bestItem := arr[0]
-		for _, item := range arr[1:] {
-			result, err := intr.execute(node, item, root)
-			if err != nil {
-				return nil, err
-			}
-			current, ok := result.(string)
-			if !ok {
-				return nil, errors.New("invalid type, must be string")
-			}
-			if current > bestVal {
-				bestVal = current
-				bestItem = item
-			}
-		}
-		return bestItem, nil
-	default:
-		return nil, errors.New("invalid type, must be number of string")
 	}
+	return arr[best], nil
 }
 func jpfSum(arguments []interface{}) (interface{}, error) {
-	items, _ := toArrayNum(arguments[0])
-	sum := 0.0
-	for _, item := range items {
-		sum += item
+	_, items, _ := toArrayNum(arguments[0])
+	// Integers are added exactly until a float or an overflow turns up.
+	var sum int64
+	for i, item := range items {
+		if item.kind != intNumber || (item.i > 0 && sum > math.MaxInt64-item.i) ||
+			(item.i < 0 && sum < math.MinInt64-item.i) {
+			total := float64(sum)
+			for _, item := range items[i:] {
+				total += item.float()
+			}
+			if math.IsInf(total, 0) {
+				return nil, functionError("sum", InvalidValue, "result out of range")
+			}
+			return total, nil
+		}
+		sum += item.i
 	}
 	return sum, nil
 }
 
 func jpfMin(arguments []interface{}) (interface{}, error) {
-	if items, ok := toArrayNum(arguments[0]); ok {
+	if items, nums, ok := toArrayNum(arguments[0]); ok {
 		if len(items) == 0 {
 			return nil, nil
 		}
-		if len(items) == 1 {
-			return items[0], nil
-		}
-		best := items[0]
-		for _, item := range items[1:] {
-			if item < best {
-				best = item
+		best := 0
+		for i := range nums[1:] {
+			if nums[i+1].cmp(nums[best]) < 0 {
+				best = i + 1
 			}
 		}
-		return best, nil
+		return items[best], nil
 	}
 	items, _ := toArrayStr(arguments[0])
 	if len(items) == 0 {
@@ -795,95 +698,77 @@ func jpfMin(arguments []interface{}) (interface{}, error) {
 
 func jpfMinBy(arguments []interface{}) (interface{}, error) {
 	intr := arguments[0].(*treeInterpreter)
-	root := arguments[1].(interface{})
-	arr := arguments[2].([]interface{})
+	root := arguments[1]
+	arr, ok := toInterfaceSlice(arguments[2])
+	if !ok {
+		return nil, argError("min_by", 0, TypeMismatch, "expected an array")
+	}
 	exp := arguments[3].(expRef)
-	node := exp.ref
 	if len(arr) == 0 {
 		return nil, nil
 	} else if len(arr) == 1 {
 		return arr[0], nil
 	}
-	start, err := intr.execute(node, arr[0], root)
+	compare, err := exprKeys("min_by", intr, root, exp, arr)
 	if err != nil {
 		return nil, err
 	}
-	if t, ok := start.(float64); ok {
-		bestVal := t
-		bestItem := arr[0]
-		for _, item := range arr[1:] {
-			result, err := intr.execute(node, item, root)
-			if err != nil {
-				return nil, err
-			}
-			current, ok := result.(float64)
-			if !ok {
-				return nil, errors.New("invalid type, must be number")
-			}
-			if current < bestVal {
-				bestVal = current
-				bestItem = item
-			}
+	best := 0
+	for i := 1; i < len(arr); i++ {
+		if compare(i, best) < 0 {
+			best = i
 		}
-		return bestItem, nil
-	} else if t, ok := start.(json.Number); ok {
-		bestVal, err := t.Float64()
+	}
+	return arr[best], nil
+}
+
+// exprKeys evaluates exp against each of items, for sort_by, max_by and
+// min_by, and returns a comparison of the items by their keys.  The keys
+// must all be numbers or all be strings.
+func exprKeys(name string, intr *treeInterpreter, root interface{}, exp expRef, items []interface{}) (func(i, j int) int, error) {
+	var nums []number
+	var strs []string
+	for i, item := range items {
+		key, err := intr.executeRef(exp, item, root)
 		if err != nil {
 			return nil, err
 		}
-		bestItem := arr[0]
-		for _, item := range arr[1:] {
-			result, err := intr.execute(node, item, root)
-			if err != nil {
-				return nil, err
-			}
-			current, err := conv.Float64(result)
-			if err != nil {
-				return nil, err
-			}
-			if current < bestVal {
-				bestVal = current
-				bestItem = item
-			}
+		if s, ok := key.(string); ok && nums == nil {
+			strs = append(strs, s)
+			continue
 		}
-		return bestItem, nil
-	} else if t, ok := start.(string); ok {
-		bestVal := t
-		bestItem := arr[0]
-		for _, item := range arr[1:] {
-			result, err := intr.execute(node, item, root)
-			if err != nil {
-				return nil, err
-			}
-			current, ok := result.(string)
-			if !ok {
-				return nil, errors.New("invalid type, must be string")
-			}
-			if current < bestVal {
-				bestVal = current
-				bestItem = item
-			}
+		if n, ok := asNumber(key); ok && strs == nil {
+			nums = append(nums, n)
+			continue
 		}
-		return bestItem, nil
-	} else {
-		return nil, errors.New("invalid type, must be number of string")
+		if i == 0 {
+			return nil, argError(name, 1, TypeMismatch, "invalid type, must be number or string")
+		}
+		if nums != nil {
+			return nil, argError(name, 1, TypeMismatch, "invalid type, must be number")
+		}
+		return nil, argError(name, 1, TypeMismatch, "invalid type, must be string")
+	}
+	if strs != nil {
+		return func(i, j int) int { return strings.Compare(strs[i], strs[j]) }, nil
 	}
+	return func(i, j int) int { return nums[i].cmp(nums[j]) }, nil
 }
 func jpfType(arguments []interface{}) (interface{}, error) {
-	arg := arguments[0]
-	if _, ok := arg.(float64); ok {
-		return "number", nil
+	if a, ok := arguments[0].(Accessor); ok {
+		return a.Kind().String(), nil
 	}
-	if _, ok := arg.(json.Number); ok {
+	arg, _ := jsonValue(arguments[0])
+	if isNumber(arg) {
 		return "number", nil
 	}
 	if _, ok := arg.(string); ok {
 		return "string", nil
 	}
-	if _, ok := arg.([]interface{}); ok {
+	if isSliceType(arg) {
 		return "array", nil
 	}
-	if _, ok := arg.(map[string]interface{}); ok {
+	if isMapType(arg) {
 		return "object", nil
 	}
 	if arg == nil {
@@ -892,10 +777,10 @@ func jpfType(arguments []interface{}) (interface{}, error) {
 	if arg == true || arg == false {
 		return "boolean", nil
 	}
-	return nil, errors.New("unknown type")
+	return nil, functionError("type", TypeMismatch, "unknown type")
 }
 func jpfKeys(arguments []interface{}) (interface{}, error) {
-	arg := arguments[0].(map[string]interface{})
+	arg, _ := toInterfaceMap(arguments[0])
 	collected := make([]interface{}, 0, len(arg))
 	for key := range arg {
 		collected = append(collected, key)
@@ -903,31 +788,40 @@ func jpfKeys(arguments []interface{}) (interface{}, error) {
 	return collected, nil
 }
 func jpfValues(arguments []interface{}) (interface{}, error) {
-	arg := arguments[0].(map[string]interface{})
+	arg, _ := toInterfaceMap(arguments[0])
 	collected := make([]interface{}, 0, len(arg))
 	for _, value := range arg {
 		collected = append(collected, value)
 	}
 	return collected, nil
 }
+func jpfDescendants(arguments []interface{}) (interface{}, error) {
+	return descendants(arguments[0]), nil
+}
 func jpfGet(arguments []interface{}) (interface{}, error) {
-	obj := arguments[0].(map[string]interface{})
+	obj, _ := toInterfaceMap(arguments[0])
 	key := arguments[1].(string)
 	val, ok := obj[key]
 	if !ok {
-		return nil, errors.New("Key not found")
+		return nil, functionError("get", NotFound, "key not found: %s", key)
 	}
 	return val, nil
 }
 func jpfZip(arguments []interface{}) (interface{}, error) {
+	arrays := make([][]interface{}, len(arguments))
+	for i, arg := range arguments {
+		arr, ok := toInterfaceSlice(arg)
+		if !ok {
+			return nil, argError("zip", i, TypeMismatch, "expected an array")
+		}
+		arrays[i] = arr
+	}
 	final := [][]interface{}{}
-	num_arrs := len(arguments)
 	for i := 0; true; i++ {
 		limit_hit := false
-		temp := make([]interface{}, num_arrs)
-		for j := 0; j < num_arrs; j++ {
-			tarr, ok := arguments[j].([]interface{})
-			if !ok || i >= len(tarr) {
+		temp := make([]interface{}, len(arrays))
+		for j, tarr := range arrays {
+			if i >= len(tarr) {
 				limit_hit = true
 				break
 			}
@@ -941,7 +835,7 @@ func jpfZip(arguments []interface{}) (interface{}, error) {
 	return final, nil
 }
 func jpfItems(arguments []interface{}) (interface{}, error) {
-	arg := arguments[0].(map[string]interface{})
+	arg, _ := toInterfaceMap(arguments[0])
 	collected := make([][]interface{}, 0, len(arg))
 	for key, value := range arg {
 		collected = append(collected, []interface{}{key, value})
@@ -949,7 +843,10 @@ func jpfItems(arguments []interface{}) (interface{}, error) {
 	return collected, nil
 }
 func jpfShuffle(arguments []interface{}) (interface{}, error) {
-	arr := arguments[0].([]interface{})
+	arr, ok := toInterfaceSlice(arguments[0])
+	if !ok {
+		return nil, argError("shuffle", 0, TypeMismatch, "expected an array")
+	}
 	final := make([]interface{}, len(arr))
 	copy(final, arr)
 	for i := len(final) - 1; i > 0; i-- {
@@ -959,14 +856,8 @@ func jpfShuffle(arguments []interface{}) (interface{}, error) {
 	return final, nil
 }
 func jpfSort(arguments []interface{}) (interface{}, error) {
-	if items, ok := toArrayNum(arguments[0]); ok {
-		d := sort.Float64Slice(items)
-		sort.Stable(d)
-		final := make([]interface{}, len(d))
-		for i, val := range d {
-			final[i] = val
-		}
-		return final, nil
+	if items, nums, ok := toArrayNum(arguments[0]); ok {
+		return sortedBy(items, func(i, j int) int { return nums[i].cmp(nums[j]) }), nil
 	}
 	// Otherwise we're dealing with sort()'ing strings.
 	items, _ := toArrayStr(arguments[0])
@@ -980,110 +871,100 @@ func jpfSort(arguments []interface{}) (interface{}, error) {
 }
 func jpfSortBy(arguments []interface{}) (interface{}, error) {
 	intr := arguments[0].(*treeInterpreter)
-	root := arguments[1].(interface{})
-	arr := arguments[2].([]interface{})
+	root := arguments[1]
+	arr, ok := toInterfaceSlice(arguments[2])
+	if !ok {
+		return nil, argError("sort_by", 0, TypeMismatch, "expected an array")
+	}
 	exp := arguments[3].(expRef)
-	node := exp.ref
 	if len(arr) == 0 {
-		return arr, nil
+		return []interface{}{}, nil
 	} else if len(arr) == 1 {
-		return arr, nil
+		return []interface{}{arr[0]}, nil
 	}
-	start, err := intr.execute(node, arr[0], root)
+	compare, err := exprKeys("sort_by", intr, root, exp, arr)
 	if err != nil {
 		return nil, err
 	}
-	if _, ok := start.(float64); ok {
-		sortable := &byExprFloat{intr, root, node, arr, false}
-		sort.Stable(sortable)
-		if sortable.hasError {
-			return nil, errors.New("error in sort_by comparison")
-		}
-		return arr, nil
-	} else if _, ok := start.(json.Number); ok {
-		sortable := &byExprJsonNum{intr, root, node, arr, false}
-		sort.Stable(sortable)
-		if sortable.hasError {
-			return nil, errors.New("error in sort_by comparison")
-		}
-		return arr, nil
-	} else if _, ok := start.(string); ok {
-		sortable := &byExprString{intr, root, node, arr, false}
-		sort.Stable(sortable)
-		if sortable.hasError {
-			return nil, errors.New("error in sort_by comparison")
-		}
-		return arr, nil
-	} else {
-		return nil, errors.New("invalid type, must be number of string")
-	}
+	return sortedBy(arr, compare), nil
+}
+
+// sortedBy returns a stably sorted copy of items, leaving the input
+// document untouched.
+func sortedBy(items []interface{}, compare func(i, j int) int) []interface{} {
+	order := make([]int, len(items))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return compare(order[a], order[b]) < 0
+	})
+	sorted := make([]interface{}, len(items))
+	for i, index := range order {
+		sorted[i] = items[index]
+	}
+	return sorted
 }
 func jpfDedup(arguments []interface{}) (interface{}, error) {
-	items := arguments[0]
-	items_d, ok := items.([]interface{})
+	items, ok := toInterfaceSlice(arguments[0])
 	if !ok {
-		return nil, errors.New("Invalid arguments to dedup. Expected an array.")
-	}
-	found := make(map[interface{}]bool)
-	final := make([]interface{}, len(items_d))
-	j := 0
-	for _, val := range items_d {
-		if !found[val] {
-			found[val] = true
-			final[j] = val
-			j++
+		return nil, argError("dedup", 0, TypeMismatch, "expected an array")
+	}
+	var found valueSet
+	final := make([]interface{}, 0, len(items))
+	for _, val := range items {
+		if found.add(val) {
+			final = append(final, val)
 		}
 	}
-	final = final[:j]
 	return final, nil
 }
 func jpfDedupBy(arguments []interface{}) (interface{}, error) {
 	intr := arguments[0].(*treeInterpreter)
-	root := arguments[1].(interface{})
-	arr := arguments[2].([]interface{})
+	root := arguments[1]
+	arr, ok := toInterfaceSlice(arguments[2])
+	if !ok {
+		return nil, argError("dedup_by", 0, TypeMismatch, "expected an array")
+	}
 	exp := arguments[3].(expRef)
-	node := exp.ref
 	if len(arr) == 0 {
 		return arr, nil
 	} else if len(arr) == 1 {
 		return arr, nil
 	}
-	found := make(map[interface{}]bool)
-	final := make([]interface{}, len(arr))
-	j := 0
+	var found valueSet
+	final := make([]interface{}, 0, len(arr))
 	for _, elem := range arr {
-		val, err := intr.execute(node, elem, root)
+		val, err := intr.executeRef(exp, elem, root)
 		if err != nil {
 			return nil, err
 		}
-		if !found[val] {
-			found[val] = true
-			final[j] = elem
-			j++
+		if found.add(val) {
+			final = append(final, elem)
 		}
 	}
-	final = final[:j]
 	return final, nil
 }
 func jpfSlice(arguments []interface{}) (interface{}, error) {
 	if len(arguments) < 3 || len(arguments) > 4 {
-		return nil, errors.New("incorrect number of args")
-	}
-	arr := arguments[0].([]interface{})
-	start, err := conv.Int64(arguments[1])
-	if err != nil {
-		panic(err)
+		return nil, functionError("slice", ArityMismatch, "incorrect number of args: expected 3 to 4, got %d", len(arguments))
 	}
-	end, err := conv.Int64(arguments[2])
-	if err != nil {
-		panic(err)
+	arr, ok := toInterfaceSlice(arguments[0])
+	if !ok {
+		return nil, argError("slice", 0, TypeMismatch, "expected an array")
 	}
-	step := int64(1)
-	if len(arguments) > 3 {
-		step, err = conv.Int64(arguments[3])
+	var bounds [3]int64
+	bounds[2] = 1
+	for i, arg := range arguments[1:] {
+		n, err := conv.Int64(arg)
 		if err != nil {
-			panic(err)
+			return nil, argError("slice", i+1, TypeMismatch, "%s", err)
 		}
+		bounds[i] = n
+	}
+	start, end, step := bounds[0], bounds[1], bounds[2]
+	if step < 1 {
+		return nil, argError("slice", 3, InvalidValue, "step must be a positive number")
 	}
 	length := int64(len(arr))
 	new_arr := []interface{}{}
@@ -1097,11 +978,9 @@ func jpfSlice(arguments []interface{}) (interface{}, error) {
 }
 func jpfJoin(arguments []interface{}) (interface{}, error) {
 	sep := arguments[0].(string)
-	// We can't just do arguments[1].([]string), we have to
-	// manually convert each item to a string.
-	arrayStr := []string{}
-	for _, item := range arguments[1].([]interface{}) {
-		arrayStr = append(arrayStr, item.(string))
+	arrayStr, ok := toArrayStr(arguments[1])
+	if !ok {
+		return nil, argError("join", 1, TypeMismatch, "expected an array of strings")
 	}
 	return strings.Join(arrayStr, sep), nil
 }
@@ -1113,7 +992,10 @@ func jpfReverse(arguments []interface{}) (interface{}, error) {
 		}
 		return string(r), nil
 	}
-	items := arguments[0].([]interface{})
+	items, ok := toInterfaceSlice(arguments[0])
+	if !ok {
+		return nil, argError("reverse", 0, TypeMismatch, "expected an array or string")
+	}
 	length := len(items)
 	reversed := make([]interface{}, length)
 	for i, item := range items {
@@ -1122,16 +1004,20 @@ func jpfReverse(arguments []interface{}) (interface{}, error) {
 	return reversed, nil
 }
 func jpfToArray(arguments []interface{}) (interface{}, error) {
-	if _, ok := arguments[0].([]interface{}); ok {
+	if isSliceType(arguments[0]) {
 		return arguments[0], nil
 	}
 	return arguments[:1:1], nil
 }
 func jpfFromItems(arguments []interface{}) (interface{}, error) {
-	mainArr := arguments[0].([][]interface{})
+	mainArr, ok := toInterfaceSlice(arguments[0])
+	if !ok {
+		return nil, argError("from_items", 0, TypeMismatch, "expected an array")
+	}
 	final := make(map[string]interface{})
-	for _, item := range mainArr {
-		if len(item) == 0 {
+	for _, el := range mainArr {
+		item, ok := toInterfaceSlice(el)
+		if !ok || len(item) < 2 {
 			continue
 		}
 		key, err := convToString(item[0])
@@ -1165,22 +1051,25 @@ func jpfToString(arguments []interface{}) (interface{}, error) {
 }
 func jpfToNumber(arguments []interface{}) (interface{}, error) {
 	arg := arguments[0]
-	if v, ok := arg.(float64); ok {
-		return v, nil
-	}
-	switch arg.(type) {
-	case string, json.Number:
-		conv, err := conv.Float64(arg)
-		if err != nil {
+	switch arg := arg.(type) {
+	case string:
+		n, ok := parseNumber(arg)
+		if !ok {
 			return nil, nil
 		}
-		return conv, nil
+		return n.value(), nil
+	case json.Number:
+		n, ok := parseNumber(string(arg))
+		if !ok {
+			return nil, nil
+		}
+		return n.value(), nil
 	}
-
-	if _, ok := arg.([]interface{}); ok {
-		return nil, nil
+	if isNumber(arg) {
+		return arg, nil
 	}
-	if _, ok := arg.(map[string]interface{}); ok {
+
+	if isSliceType(arg) || isMapType(arg) {
 		return nil, nil
 	}
 	if arg == nil {
@@ -1189,7 +1078,7 @@ func jpfToNumber(arguments []interface{}) (interface{}, error) {
 	if arg == true || arg == false {
 		return nil, nil
 	}
-	return nil, errors.New("unknown type")
+	return nil, functionError("to_number", TypeMismatch, "unknown type")
 }
 func jpfNotNull(arguments []interface{}) (interface{}, error) {
 	for _, arg := range arguments {
@@ -0,0 +1,107 @@
+package jmespath
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type typedItem struct {
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+}
+
+type labelKey string
+
+func typedTestData() map[string]interface{} {
+	return map[string]interface{}{
+		"words":  []string{"b", "a", "c", "a"},
+		"nums":   []float64{3, 1, 2, 1},
+		"array":  [3]string{"x", "y", "z"},
+		"labels": map[string]string{"a": "x", "b": "y"},
+		"counts": map[string]float64{"a": 1, "b": 2},
+		"named":  map[labelKey]string{"k": "v"},
+		"empty":  map[string]int{},
+		"items": map[string]*typedItem{
+			"one":  {Name: "one", Score: 1},
+			"two":  {Name: "two", Score: 2},
+			"none": nil,
+		},
+		"list":  []*typedItem{{Name: "b", Score: 2}, {Name: "a", Score: 1}, {Name: "c", Score: 2}},
+		"pairs": [][]string{{"a", "x"}, {"b", "y"}},
+	}
+}
+
+func TestBuiltinsOnTypedData(t *testing.T) {
+	var tests = []struct {
+		expression string
+		expected   interface{}
+	}{
+		{"abs(nums[1])", 1.0},
+		{"avg(nums)", 1.75},
+		{"ceil(avg(nums))", 2.0},
+		{"contains(words, 'c')", true},
+		{"contains(array, 'y')", true},
+		{"contains_any(words, array)", false},
+		{"contains_any(array, `[\"z\"]`)", true},
+		{"dedup(words)", []interface{}{"b", "a", "c"}},
+		{"dedup_by(list, &score)[*].name", []interface{}{"b", "a"}},
+		{"ends_with(join('', words), 'ca')", true},
+		{"floor(avg(nums))", 1.0},
+		{"from_items(pairs)", map[string]interface{}{"a": "x", "b": "y"}},
+		{"get(labels, 'a')", "x"},
+		{"descendants(list)[*].name", []interface{}{"b", "a", "c"}},
+		{"descendants(items)[*].name", []interface{}{"one", "two"}},
+		{"descendants(labels)", []interface{}{"x", "y"}},
+		{"length(descendants(pairs))", 6.0},
+		{"get(named, 'k')", "v"},
+		{"sort_by(items(labels), &[0])", []interface{}{[]interface{}{"a", "x"}, []interface{}{"b", "y"}}},
+		{"join('-', words)", "b-a-c-a"},
+		{"join('', array)", "xyz"},
+		{"sort(keys(labels))", []interface{}{"a", "b"}},
+		{"keys(named)", []interface{}{"k"}},
+		{"keys(empty)", []interface{}{}},
+		{"length(words)", 4.0},
+		{"length(array)", 3.0},
+		{"length(labels)", 2.0},
+		{"length(items)", 3.0},
+		{"map(&length(@), words)", []interface{}{1.0, 1.0, 1.0, 1.0}},
+		{"max(nums)", 3.0},
+		{"max(words)", "c"},
+		{"max_by(list, &score).name", "b"},
+		{"merge(labels, counts)", map[string]interface{}{"a": 1.0, "b": 2.0}},
+		{"merge(labels, `{\"c\": \"z\"}`).c", "z"},
+		{"min(nums)", 1.0},
+		{"min(words)", "a"},
+		{"min_by(list, &score).name", "a"},
+		{"not_null(items.none, words[0])", "b"},
+		{"reverse(array)", []interface{}{"z", "y", "x"}},
+		{"length(shuffle(words))", 4.0},
+		{"slice(words, `1`, `3`)", []interface{}{"a", "c"}},
+		{"sort(words)", []interface{}{"a", "a", "b", "c"}},
+		{"sort(nums)", []interface{}{1.0, 1.0, 2.0, 3.0}},
+		{"sort_by(list, &name)[*].name", []interface{}{"a", "b", "c"}},
+		{"starts_with(join('', words), 'ba')", true},
+		{"sum(nums)", 7.0},
+		{"to_array(words)", []string{"b", "a", "c", "a"}},
+		{"to_array(labels)", []interface{}{map[string]string{"a": "x", "b": "y"}}},
+		{"to_number(labels)", nil},
+		{"to_number(words)", nil},
+		{"to_string(labels)", `{"a":"x","b":"y"}`},
+		{"type(words)", "array"},
+		{"type(array)", "array"},
+		{"type(labels)", "object"},
+		{"type(named)", "object"},
+		{"type(items.one)", "object"},
+		{"sort(values(labels))", []interface{}{"x", "y"}},
+		{"zip(words, array)", [][]interface{}{{"b", "x"}, {"a", "y"}, {"c", "z"}}},
+	}
+	data := typedTestData()
+	for _, tt := range tests {
+		for _, opts := range [][]Option{nil, {WithBytecode()}} {
+			actual, err := Search(tt.expression, data, opts...)
+			assert.Nil(t, err, tt.expression)
+			assert.Equal(t, tt.expected, actual, tt.expression)
+		}
+	}
+}
@@ -1,6 +1,11 @@
 package jmespath
 
-import "github.com/jmespath/go-jmespath"
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/jmespath/go-jmespath"
+)
 
 // Fuzz will fuzz test the JMESPath parser.
 func Fuzz(data []byte) int {
@@ -11,3 +16,61 @@ func Fuzz(data []byte) int {
 	}
 	return 0
 }
+
+type fuzzItem struct {
+	Name  string   `json:"name"`
+	Count int      `json:"count"`
+	Tags  []string `json:"tags"`
+	Next  *fuzzItem
+	inner string
+}
+
+const fuzzDocument = `{
+  "foo": {"bar": [1, 2.5, "three", null, true, {"baz": [[1, 2], [3]]}]},
+  "people": [{"name": "a", "age": 30}, {"name": "b", "age": "x"}, {}],
+  "empty": {}, "list": [], "str": "hello", "num": -3.7, "nested": [[[["x"]]]],
+  "ints": [1, 2, 3, 4, 5]
+}`
+
+// fuzzInputs returns the documents each fuzzed expression is searched
+// against: JSON decoded with float64 and json.Number numbers, a decoded
+// array, plus typed Go values that exercise the reflection paths.
+func fuzzInputs() []interface{} {
+	var decoded, numbers, array interface{}
+	json.Unmarshal([]byte(fuzzDocument), &decoded)
+	json.Unmarshal([]byte(`[1, "two", null, [3, 4], {"five": 5}]`), &array)
+	d := json.NewDecoder(bytes.NewReader([]byte(fuzzDocument)))
+	d.UseNumber()
+	d.Decode(&numbers)
+	item := &fuzzItem{Name: "first", Count: 2, Tags: []string{"a", "b"}}
+	item.Next = &fuzzItem{Name: "second", Tags: []string{}}
+	return []interface{}{
+		decoded,
+		numbers,
+		array,
+		item,
+		[]*fuzzItem{item, nil, item.Next},
+		map[string]int{"one": 1, "two": 2},
+		map[int]string{1: "one"},
+		[]string{"b", "a", "c"},
+		[][]int{{1, 2}, {3}},
+		nil,
+	}
+}
+
+// FuzzSearch will fuzz test the parser and both evaluation backends by
+// evaluating each parsable expression against a fixed set of documents.
+// Evaluation may fail, but must never panic.  Its seed corpus is in
+// searchdata/corpus.
+func FuzzSearch(data []byte) int {
+	tree, err := jmespath.Compile(string(data))
+	if err != nil {
+		return 0
+	}
+	bytecode := jmespath.MustCompile(string(data), jmespath.WithBytecode())
+	for _, input := range fuzzInputs() {
+		tree.Search(input)
+		bytecode.Search(input)
+	}
+	return 1
+}
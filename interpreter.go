@@ -1,12 +1,9 @@
 package jmespath
 
 import (
-	"errors"
+	"context"
 	"reflect"
-	"unicode"
-	"unicode/utf8"
 	conv "github.com/cstockton/go-conv"
-	reflections "github.com/oleiade/reflections"
 )
 
 /* This is a tree based interpreter.  It walks the AST and directly
@@ -14,17 +11,76 @@ import (
 */
 
 type treeInterpreter struct {
-	fCall *functionCaller
+	fCall         *functionCaller
+	limits        Limits
+	exactIntegers bool // Whether builtins may return numbers other than float64
+
+	// Per-search state, reset by search().
+	ctx       context.Context // Checked periodically during evaluation, if set
+	steps     int             // Nodes evaluated since ctx was last checked
+	evaluated int             // Nodes evaluated, for Limits.MaxSteps
+	elements  int             // Elements produced, for Limits.MaxElements
+	bytes     int             // String bytes produced, for Limits.MaxBytes
+	err       error           // Set once the search has been aborted
+	vm        *vm             // Stacks for running bytecode, allocated on first use
+	tracer    *tracer         // Records each node evaluated, for Explain
+	scope     *scope          // Variables bound by the enclosing let expressions
 }
 
+// cancelCheckInterval is the number of nodes evaluated between checks of
+// the interpreter's context.
+const cancelCheckInterval = 64
+
 func newInterpreter() *treeInterpreter {
+	return newInterpreterWithRegistry(defaultRegistry)
+}
+
+func newInterpreterWithRegistry(registry *FunctionRegistry) *treeInterpreter {
 	interpreter := treeInterpreter{}
-	interpreter.fCall = newFunctionCaller()
+	interpreter.fCall = newFunctionCaller(registry)
 	return &interpreter
 }
 
+// search returns a copy of intr with fresh per-search state, so that a
+// compiled expression can be searched from several goroutines at once.
+// If ctx is not nil, evaluation stops once it is done.
+func (intr *treeInterpreter) search(ctx context.Context) *treeInterpreter {
+	return &treeInterpreter{fCall: intr.fCall, limits: intr.limits, exactIntegers: intr.exactIntegers, ctx: ctx}
+}
+
+// abort stops the search: every evaluation after this returns err, and err
+// takes precedence over any error it causes along the way.
+func (intr *treeInterpreter) abort(err error) error {
+	intr.err = err
+	return err
+}
+
+// checkContext returns ctx.Err() once the interpreter's context is done.
+// Polling the channel on every node would dominate cheap evaluations, so
+// it is only looked at every cancelCheckInterval nodes.
+func (intr *treeInterpreter) checkContext() error {
+	intr.steps++
+	if intr.steps >= cancelCheckInterval {
+		intr.steps = 0
+		select {
+		case <-intr.ctx.Done():
+			return intr.abort(intr.ctx.Err())
+		default:
+		}
+	}
+	return nil
+}
+
 type expRef struct {
-	ref ASTNode
+	ref   ASTNode
+	prog  *program // Compiled form of ref, when running on the VM
+	scope *scope   // Variables in scope where the reference was made
+}
+
+// String is used when an expression reference shows up in an error
+// message, in place of its internals.
+func (e expRef) String() string {
+	return "expref"
 }
 
 // Execute takes an ASTNode and input data and interprets the AST directly.
@@ -35,7 +91,52 @@ func (intr *treeInterpreter) Execute(node ASTNode, value interface{}) (interface
 	return intr.execute(node, value, rootValue)
 }
 
+// execute evaluates node, recording the step if the search is traced.
 func (intr *treeInterpreter) execute(node ASTNode, value interface{}, rootValue interface{}) (interface{}, error) {
+	if intr.tracer != nil {
+		step := intr.tracer.enter(node, value)
+		result, err := intr.executeNode(node, value, rootValue)
+		intr.tracer.exit(step, result, err)
+		return result, err
+	}
+	return intr.executeNode(node, value, rootValue)
+}
+
+// executeNode evaluates node and ties any error to the innermost node whose
+// evaluation failed.
+func (intr *treeInterpreter) executeNode(node ASTNode, value interface{}, rootValue interface{}) (interface{}, error) {
+	if intr.err != nil {
+		return nil, intr.err
+	}
+	if intr.ctx != nil {
+		if err := intr.checkContext(); err != nil {
+			return nil, err
+		}
+	}
+	if intr.limits.MaxSteps > 0 {
+		intr.evaluated++
+		if intr.evaluated > intr.limits.MaxSteps {
+			return nil, intr.limitExceeded("MaxSteps", intr.limits.MaxSteps, node)
+		}
+	}
+	result, err := intr.evaluate(node, value, rootValue)
+	if err != nil {
+		if intr.err != nil {
+			// Functions such as sort_by report failures of their own;
+			// an aborted search reports why it was aborted instead.
+			return nil, intr.err
+		}
+		return nil, locateError(err, node)
+	}
+	if intr.limits.MaxElements > 0 || intr.limits.MaxBytes > 0 {
+		if err := intr.countResult(node, result); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (intr *treeInterpreter) evaluate(node ASTNode, value interface{}, rootValue interface{}) (interface{}, error) {
 	switch node.nodeType {
 	case ASTComparator:
 		left, err := intr.execute(node.children[0], value, rootValue)
@@ -46,32 +147,34 @@ func (intr *treeInterpreter) execute(node ASTNode, value interface{}, rootValue
 		if err != nil {
 			return nil, err
 		}
-		switch node.value {
-		case tEQ:
-			return objsEqual(left, right), nil
-		case tNE:
-			return !objsEqual(left, right), nil
+		return compareValues(node.value.(tokType), left, right)
+	case ASTArithmetic:
+		left, err := intr.execute(node.children[0], value, rootValue)
+		if err != nil {
+			return nil, err
+		}
+		right, err := intr.execute(node.children[1], value, rootValue)
+		if err != nil {
+			return nil, err
 		}
-		leftNum, err := conv.Float64(left)
+		return intr.arithmetic(node.value.(tokType), left, right)
+	case ASTNegate:
+		operand, err := intr.execute(node.children[0], value, rootValue)
 		if err != nil {
 			return nil, err
 		}
-		rightNum, err := conv.Float64(right)
+		return intr.negate(operand)
+	case ASTConditional:
+		condition, err := intr.execute(node.children[0], value, rootValue)
 		if err != nil {
 			return nil, err
 		}
-		switch node.value {
-		case tGT:
-			return leftNum > rightNum, nil
-		case tGTE:
-			return leftNum >= rightNum, nil
-		case tLT:
-			return leftNum < rightNum, nil
-		case tLTE:
-			return leftNum <= rightNum, nil
+		if isFalse(condition) {
+			return intr.execute(node.children[2], value, rootValue)
 		}
+		return intr.execute(node.children[1], value, rootValue)
 	case ASTExpRef:
-		return expRef{ref: node.children[0]}, nil
+		return expRef{ref: node.children[0], scope: intr.scope}, nil
 	case ASTFunctionExpression:
 		resolvedArgs := []interface{}{}
 		for _, arg := range node.children {
@@ -81,19 +184,16 @@ func (intr *treeInterpreter) execute(node ASTNode, value interface{}, rootValue
 			}
 			resolvedArgs = append(resolvedArgs, current)
 		}
-		return intr.fCall.CallFunction(node.value.(string), resolvedArgs, intr, rootValue)
+		return intr.callFunction(node, resolvedArgs, rootValue)
 	case ASTField:
 		return intr.fieldFromStructOrMap(node.value.(string), value)
 	case ASTFilterProjection:
 		left, err := intr.execute(node.children[0], value, rootValue)
 		if err != nil {
-			return nil, nil
+			return nil, err
 		}
-		sliceType, ok := left.([]interface{})
+		sliceType, ok := toInterfaceSlice(left)
 		if !ok {
-			if isSliceType(left) {
-				return intr.filterProjectionWithReflection(node, left, rootValue)
-			}
 			return nil, nil
 		}
 		compareNode := node.children[2]
@@ -117,64 +217,21 @@ func (intr *treeInterpreter) execute(node ASTNode, value interface{}, rootValue
 	case ASTFlatten:
 		left, err := intr.execute(node.children[0], value, rootValue)
 		if err != nil {
-			return nil, nil
-		}
-		sliceType, ok := left.([]interface{})
-		if !ok {
-			// If we can't type convert to []interface{}, there's
-			// a chance this could still work via reflection if we're
-			// dealing with user provided types.
-			if isSliceType(left) {
-				return intr.flattenWithReflection(left)
-			}
-			return nil, nil
-		}
-		flattened := []interface{}{}
-		for _, element := range sliceType {
-			if elementSlice, ok := element.([]interface{}); ok {
-				flattened = append(flattened, elementSlice...)
-			} else if isSliceType(element) {
-				reflectFlat := []interface{}{}
-				v := reflect.ValueOf(element)
-				for i := 0; i < v.Len(); i++ {
-					reflectFlat = append(reflectFlat, v.Index(i).Interface())
-				}
-				flattened = append(flattened, reflectFlat...)
-			} else {
-				flattened = append(flattened, element)
-			}
+			return nil, err
 		}
-		return flattened, nil
+		return intr.flatten(left)
 	case ASTIdentity, ASTCurrentNode:
 		return value, nil
 	case ASTRootNode:
 		return rootValue, nil
 	case ASTIndex:
-		if sliceType, ok := value.([]interface{}); ok {
-			index := node.value.(int)
-			if index < 0 {
-				index += len(sliceType)
-			}
-			if index < len(sliceType) && index >= 0 {
-				return sliceType[index], nil
-			}
-			return nil, nil
-		}
-		// Otherwise try via reflection.
-		rv := reflect.ValueOf(value)
-		if rv.Kind() == reflect.Slice {
-			index := node.value.(int)
-			if index < 0 {
-				index += rv.Len()
-			}
-			if index < rv.Len() && index >= 0 {
-				v := rv.Index(index)
-				return v.Interface(), nil
-			}
-		}
-		return nil, nil
-	case ASTKeyValPair, ASTKeyValExprPair:
+		return indexValue(node.value.(int), value), nil
+	case ASTKeyValPair, ASTKeyValExprPair, ASTVariableBinding:
 		return intr.execute(node.children[0], value, rootValue)
+	case ASTLetExpression:
+		return intr.evaluateLet(node, value, rootValue)
+	case ASTVariable:
+		return intr.scope.lookup(node.value.(string))
 	case ASTLiteral:
 		return node.value, nil
 	case ASTMultiSelectHash:
@@ -193,8 +250,11 @@ func (intr *treeInterpreter) execute(node ASTNode, value interface{}, rootValue
 				if err != nil {
 					return nil, err
 				}
-				inner_node := key_expr.(expRef).ref
-				key_i, err := intr.execute(inner_node, value, rootValue)
+				key_ref, ok := key_expr.(expRef)
+				if !ok {
+					return nil, runtimeError(TypeMismatch, "multi-select hash key is not an expression reference")
+				}
+				key_i, err := intr.executeRef(key_ref, value, rootValue)
 				if err != nil {
 					return nil, err
 				}
@@ -251,7 +311,7 @@ func (intr *treeInterpreter) execute(node ASTNode, value interface{}, rootValue
 			return true, nil
 		}
 		return false, nil
-	case ASTPipe:
+	case ASTPipe, ASTSubexpression:
 		result := value
 		var err error
 		for _, child := range node.children {
@@ -266,11 +326,8 @@ func (intr *treeInterpreter) execute(node ASTNode, value interface{}, rootValue
 		if err != nil {
 			return nil, err
 		}
-		sliceType, ok := left.([]interface{})
+		sliceType, ok := toInterfaceSlice(left)
 		if !ok {
-			if isSliceType(left) {
-				return intr.projectWithReflection(node, left, rootValue)
-			}
 			return nil, nil
 		}
 		collected := []interface{}{}
@@ -285,42 +342,23 @@ func (intr *treeInterpreter) execute(node ASTNode, value interface{}, rootValue
 			}
 		}
 		return collected, nil
-	case ASTSubexpression, ASTIndexExpression:
+	case ASTIndexExpression:
 		left, err := intr.execute(node.children[0], value, rootValue)
 		if err != nil {
 			return nil, err
 		}
 		return intr.execute(node.children[1], left, rootValue)
 	case ASTSlice:
-		sliceType, ok := value.([]interface{})
-		if !ok {
-			if isSliceType(value) {
-				return intr.sliceWithReflection(node, value)
-			}
-			return nil, nil
-		}
-		parts := node.value.([]*int)
-		sliceParams := make([]sliceParam, 3)
-		for i, part := range parts {
-			if part != nil {
-				sliceParams[i].Specified = true
-				sliceParams[i].N = *part
-			}
-		}
-		return slice(sliceType, sliceParams)
+		return intr.sliceValue(node, value)
 	case ASTValueProjection:
 		left, err := intr.execute(node.children[0], value, rootValue)
 		if err != nil {
-			return nil, nil
+			return nil, err
 		}
-		mapType, ok := left.(map[string]interface{})
+		values, ok := objectValues(left)
 		if !ok {
 			return nil, nil
 		}
-		values := make([]interface{}, len(mapType))
-		for _, value := range mapType {
-			values = append(values, value)
-		}
 		collected := []interface{}{}
 		for _, element := range values {
 			current, err := intr.execute(node.children[1], element, rootValue)
@@ -333,84 +371,133 @@ func (intr *treeInterpreter) execute(node ASTNode, value interface{}, rootValue
 		}
 		return collected, nil
 	}
-	return nil, errors.New("Unknown AST node: " + node.nodeType.String())
+	return nil, runtimeError(InvalidValue, "Unknown AST node: %s", node.nodeType)
 }
 
-func fieldNameFromStructTag(key string, value interface{}) string {
-	// TODO: This is not efficient. tag_map must be put in a global cache
-	tag_map, err := reflections.TagMap(value, "json")
-	fieldName := ""
-	if err == nil {
-		ok := false
-		fieldName, ok = tag_map[key]
-		if !ok {
-			fieldName = ""
+// compareValues applies the comparator op to left and right.  Ordering
+// comparisons are numeric, and exact between integers.
+func compareValues(op tokType, left, right interface{}) (interface{}, error) {
+	switch op {
+	case tEQ:
+		return objsEqual(left, right), nil
+	case tNE:
+		return !objsEqual(left, right), nil
+	}
+	if leftNum, ok := asNumber(left); ok {
+		if rightNum, ok := asNumber(right); ok {
+			switch c := leftNum.cmp(rightNum); op {
+			case tGT:
+				return c > 0, nil
+			case tGTE:
+				return c >= 0, nil
+			case tLT:
+				return c < 0, nil
+			case tLTE:
+				return c <= 0, nil
+			}
+			return nil, nil
 		}
 	}
-	if fieldName == "" {
-		first, n := utf8.DecodeRuneInString(key)
-		fieldName = string(unicode.ToUpper(first)) + key[n:]
+	leftNum, err := conv.Float64(left)
+	if err != nil {
+		return nil, runtimeError(TypeMismatch, "%s", err)
+	}
+	rightNum, err := conv.Float64(right)
+	if err != nil {
+		return nil, runtimeError(TypeMismatch, "%s", err)
+	}
+	switch op {
+	case tGT:
+		return leftNum > rightNum, nil
+	case tGTE:
+		return leftNum >= rightNum, nil
+	case tLT:
+		return leftNum < rightNum, nil
+	case tLTE:
+		return leftNum <= rightNum, nil
 	}
-	return fieldName
+	return nil, nil
 }
 
-func (intr *treeInterpreter) fieldFromStructOrMap(key string, value interface{}) (interface{}, error) {
-	var err error
-	rv := reflect.ValueOf(value)
-	rv, err = stripPtrs(rv)
+// callFunction calls the function named by the function expression node
+// with arguments that have already been evaluated.
+func (intr *treeInterpreter) callFunction(node ASTNode, resolvedArgs []interface{}, rootValue interface{}) (interface{}, error) {
+	name, ok := node.value.(string)
+	if !ok {
+		return nil, runtimeError(InvalidValue, "invalid function name: %v", node.value)
+	}
+	result, err := intr.fCall.CallFunction(name, resolvedArgs, intr, rootValue)
 	if err != nil {
-		return nil, nil
+		if _, ok := err.(RuntimeError); !ok {
+			// Errors from custom functions keep the call they came from.
+			err = functionError(name, InvalidValue, "%s", err).wrap(err)
+		}
+		return nil, err
 	}
+	return result, nil
+}
 
-	if rv.Kind() == reflect.Struct {
-		v := rv.FieldByName(fieldNameFromStructTag(key, value))
-		if !v.IsValid() {
-			return nil, nil
+// executeRef evaluates the expression reference ref against value, on the
+// VM if the reference was compiled to bytecode, with the variables that
+// were in scope where the reference was made.
+func (intr *treeInterpreter) executeRef(ref expRef, value interface{}, rootValue interface{}) (interface{}, error) {
+	outer := intr.scope
+	intr.scope = ref.scope
+	var result interface{}
+	var err error
+	if ref.prog != nil {
+		result, err = intr.run(ref.prog, value, rootValue)
+	} else {
+		result, err = intr.execute(ref.ref, value, rootValue)
+	}
+	intr.scope = outer
+	return result, err
+}
+
+func indexValue(index int, value interface{}) interface{} {
+	if sliceType, ok := value.([]interface{}); ok {
+		if index < 0 {
+			index += len(sliceType)
 		}
-		v, err = stripPtrs(v)
-		if err != nil {
-			return nil, nil
+		if index < len(sliceType) && index >= 0 {
+			return sliceType[index]
 		}
-		return v.Interface(), nil
-	} else if rv.Kind() == reflect.Map {
-		field := rv.MapIndex(reflect.ValueOf(key))
-		field, err = stripPtrs(field)
-		if err != nil {
-			return nil, nil
+		return nil
+	}
+	if a, ok := value.(Accessor); ok {
+		if a.Kind() != ArrayKind {
+			return nil
 		}
-		if field.IsValid() {
-			return field.Interface(), nil
-		} else {
-			return nil, nil
+		if index < 0 {
+			index += a.Len()
 		}
+		if index < a.Len() && index >= 0 {
+			return accessed(a.Index(index))
+		}
+		return nil
 	}
-
-	return nil, nil
-}
-
-func (intr *treeInterpreter) flattenWithReflection(value interface{}) (interface{}, error) {
-	v := reflect.ValueOf(value)
-	flattened := []interface{}{}
-	for i := 0; i < v.Len(); i++ {
-		element := v.Index(i).Interface()
-		if reflect.TypeOf(element).Kind() == reflect.Slice {
-			// Then insert the contents of the element
-			// slice into the flattened slice,
-			// i.e flattened = append(flattened, mySlice...)
-			elementV := reflect.ValueOf(element)
-			for j := 0; j < elementV.Len(); j++ {
-				flattened = append(
-					flattened, elementV.Index(j).Interface())
-			}
-		} else {
-			flattened = append(flattened, element)
+	// Otherwise try via reflection.
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		if index < 0 {
+			index += rv.Len()
+		}
+		if index < rv.Len() && index >= 0 {
+			return elementValue(rv.Index(index))
 		}
+		return nil
 	}
-	return flattened, nil
+	if items, ok := toInterfaceSlice(value); ok {
+		return indexValue(index, items)
+	}
+	return nil
 }
 
-func (intr *treeInterpreter) sliceWithReflection(node ASTNode, value interface{}) (interface{}, error) {
-	v := reflect.ValueOf(value)
+func (intr *treeInterpreter) sliceValue(node ASTNode, value interface{}) (interface{}, error) {
+	sliceType, ok := toInterfaceSlice(value)
+	if !ok {
+		return nil, nil
+	}
 	parts := node.value.([]*int)
 	sliceParams := make([]sliceParam, 3)
 	for i, part := range parts {
@@ -419,49 +506,76 @@ func (intr *treeInterpreter) sliceWithReflection(node ASTNode, value interface{}
 			sliceParams[i].N = *part
 		}
 	}
-	final := []interface{}{}
-	for i := 0; i < v.Len(); i++ {
-		element := v.Index(i).Interface()
-		final = append(final, element)
-	}
-	return slice(final, sliceParams)
+	return slice(sliceType, sliceParams)
 }
 
-func (intr *treeInterpreter) filterProjectionWithReflection(node ASTNode, value interface{}, rootValue interface{}) (interface{}, error) {
-	compareNode := node.children[2]
-	collected := []interface{}{}
-	v := reflect.ValueOf(value)
-	for i := 0; i < v.Len(); i++ {
-		element := v.Index(i).Interface()
-		result, err := intr.execute(compareNode, element, rootValue)
-		if err != nil {
-			return nil, err
-		}
-		if !isFalse(result) {
-			current, err := intr.execute(node.children[1], element, rootValue)
-			if err != nil {
-				return nil, err
-			}
-			if current != nil {
-				collected = append(collected, current)
-			}
+func (intr *treeInterpreter) flatten(value interface{}) (interface{}, error) {
+	sliceType, ok := toInterfaceSlice(value)
+	if !ok {
+		return nil, nil
+	}
+	flattened := []interface{}{}
+	for _, element := range sliceType {
+		if elementSlice, ok := toInterfaceSlice(element); ok {
+			flattened = append(flattened, elementSlice...)
+		} else {
+			flattened = append(flattened, element)
 		}
 	}
-	return collected, nil
+	return flattened, nil
 }
 
-func (intr *treeInterpreter) projectWithReflection(node ASTNode, value interface{}, rootValue interface{}) (interface{}, error) {
-	collected := []interface{}{}
-	v := reflect.ValueOf(value)
-	for i := 0; i < v.Len(); i++ {
-		element := v.Index(i).Interface()
-		result, err := intr.execute(node.children[1], element, rootValue)
+func (intr *treeInterpreter) fieldFromStructOrMap(key string, value interface{}) (interface{}, error) {
+	if object, ok := value.(map[string]interface{}); ok {
+		return accessed(object[key]), nil
+	}
+	if a, ok := value.(Accessor); ok {
+		if a.Kind() != ObjectKind {
+			return nil, nil
+		}
+		field, _ := a.Field(key)
+		return accessed(field), nil
+	}
+	var err error
+	rv := reflect.ValueOf(value)
+	rv, err = stripPtrs(rv)
+	if err != nil {
+		return nil, nil
+	}
+	if isMarshaler(value) || (rv.Kind() == reflect.Struct && isMarshaler(rv.Interface())) {
+		object, _ := marshaledValue(value).(map[string]interface{})
+		return object[key], nil
+	}
+
+	if rv.Kind() == reflect.Struct {
+		field, ok := cachedStructFields(rv.Type()).lookup(key)
+		if !ok {
+			return nil, nil
+		}
+		v, _ := fieldValue(rv, field)
+		return accessed(v), nil
+	} else if rv.Kind() == reflect.Map {
+		keyType := rv.Type().Key()
+		if keyType.Kind() != reflect.String {
+			return nil, nil
+		}
+		field := rv.MapIndex(reflect.ValueOf(key).Convert(keyType))
+		if field.IsValid() {
+			// Accessors may have pointer receivers, so keep the pointer.
+			if a, ok := field.Interface().(Accessor); ok {
+				return accessed(a), nil
+			}
+		}
+		field, err = stripPtrs(field)
 		if err != nil {
-			return nil, err
+			return nil, nil
 		}
-		if result != nil {
-			collected = append(collected, result)
+		if field.IsValid() {
+			return accessed(field.Interface()), nil
+		} else {
+			return nil, nil
 		}
 	}
-	return collected, nil
+
+	return nil, nil
 }
@@ -2,6 +2,9 @@ package jmespath
 
 import (
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -51,6 +54,14 @@ func TestCanSupportEmptyInterface(t *testing.T) {
 	assert.Equal("bar", result)
 }
 
+func TestValueProjectionVisitsOnlyMembers(t *testing.T) {
+	assert := assert.New(t)
+	data := map[string]interface{}{"a": -1.0}
+	result, err := Search("*.abs(@)", data)
+	assert.Nil(err)
+	assert.Equal([]interface{}{1.0}, result)
+}
+
 func TestCanSupportUserDefinedStructsValue(t *testing.T) {
 	assert := assert.New(t)
 	s := scalars{Foo: "one", Bar: "bar"}
@@ -219,3 +230,177 @@ func BenchmarkInterpretNestedMaps(b *testing.B) {
 		intr.Execute(ast, data)
 	}
 }
+
+func TestBuiltinsReturnRuntimeErrors(t *testing.T) {
+	assert := assert.New(t)
+	data := map[string]interface{}{"bad": json.Number("not-a-number")}
+	for _, fn := range []string{"abs", "ceil", "floor"} {
+		_, err := Search(fn+"(bad)", data)
+		if assert.NotNil(err, fn) {
+			runtimeError, ok := err.(RuntimeError)
+			if assert.True(ok, fn) {
+				assert.Equal(fn, runtimeError.FunctionName)
+				assert.Equal(0, runtimeError.ArgIndex)
+			}
+		}
+	}
+
+	_, err := Search("starts_with(`1`, 'a')", nil)
+	if assert.NotNil(err) {
+		runtimeError, ok := err.(RuntimeError)
+		if assert.True(ok) {
+			assert.Equal("starts_with", runtimeError.FunctionName)
+			assert.Equal(0, runtimeError.ArgIndex)
+		}
+	}
+
+	_, err = Search("slice(@, `0`, `2`, `0`)", []interface{}{1.0, 2.0})
+	assert.NotNil(err)
+}
+
+func TestBuiltinsAcceptTypedSlices(t *testing.T) {
+	assert := assert.New(t)
+	data := []scalars{{"b", "1"}, {"a", "2"}}
+	result, err := Search("sort_by(@, &Foo)[].Foo", data)
+	assert.Nil(err)
+	assert.Equal([]interface{}{"a", "b"}, result)
+
+	result, err = Search("reverse(@)[0].Foo", data)
+	assert.Nil(err)
+	assert.Equal("a", result)
+
+	result, err = Search("contains(@, 'b')", []string{"a", "b"})
+	assert.Nil(err)
+	assert.Equal(true, result)
+
+	result, err = Search("map(&Bar, @)", data)
+	assert.Nil(err)
+	assert.Equal([]interface{}{"1", "2"}, result)
+}
+
+func TestSortByDoesNotModifyInput(t *testing.T) {
+	assert := assert.New(t)
+	data := []interface{}{"b", "a"}
+	result, err := Search("sort_by(@, &@)", data)
+	assert.Nil(err)
+	assert.Equal([]interface{}{"a", "b"}, result)
+	assert.Equal([]interface{}{"b", "a"}, data)
+}
+
+func TestDedupByUnhashableValues(t *testing.T) {
+	assert := assert.New(t)
+	var data interface{}
+	json.Unmarshal([]byte(`[{"a": [1]}, {"a": [1]}, {"a": [2]}]`), &data)
+	result, err := Search("dedup_by(@, &a)", data)
+	assert.Nil(err)
+	assert.Len(result, 2)
+}
+
+func TestFromItemsAcceptsJSONArrays(t *testing.T) {
+	assert := assert.New(t)
+	var data interface{}
+	json.Unmarshal([]byte(`[["a", 1], ["b", 2], ["a", 3], ["c"]]`), &data)
+	result, err := Search("from_items(@)", data)
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{"a": []interface{}{1.0, 3.0}, "b": 2.0}, result)
+}
+
+type unexportedFields struct {
+	Name  string `json:"name"`
+	inner string
+	Next  *unexportedFields
+}
+
+// TestSearchDoesNotPanicOnFuzzCorpus evaluates every expression in the
+// fuzz corpora against a mix of decoded JSON and typed Go values.
+func TestSearchDoesNotPanicOnFuzzCorpus(t *testing.T) {
+	files, err := filepath.Glob("fuzz/testdata/expr-*")
+	if err != nil || len(files) == 0 {
+		t.Skip("fuzz corpus not available")
+	}
+	seeds, _ := filepath.Glob("fuzz/searchdata/corpus/*")
+	files = append(files, seeds...)
+	var decoded, array interface{}
+	json.Unmarshal([]byte(`{"foo": {"bar": [1, "two", null, {"baz": [[1], 2]}]}, "str": "x", "people": [{"name": "a", "age": 1}], "ints": [1, 2, 3]}`), &decoded)
+	json.Unmarshal([]byte(`[1, "two", null]`), &array)
+	node := &unexportedFields{Name: "a", inner: "b"}
+	inputs := []interface{}{
+		decoded,
+		array,
+		node,
+		[]*unexportedFields{node, nil},
+		map[int]string{1: "one"},
+		map[string]int{"one": 1},
+		[]string{"b", "a"},
+		[][]int{{1}, {2, 3}},
+		nil,
+	}
+	for _, filename := range files {
+		expression, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, opts := range [][]Option{nil, {WithBytecode()}, {WithOptimization()}} {
+			compiled, err := compileWithoutPanic(t, string(expression), opts...)
+			if err != nil {
+				continue
+			}
+			for _, input := range inputs {
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							t.Errorf("Search(%q) panicked on %#v: %v", expression, input, r)
+						}
+					}()
+					compiled.Search(input)
+				}()
+			}
+		}
+	}
+}
+
+// compileWithoutPanic compiles expression, reporting a panic as a test
+// failure and an error.
+func compileWithoutPanic(t *testing.T, expression string, opts ...Option) (compiled *JMESPath, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("Compile(%q) panicked: %v", expression, r)
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return Compile(expression, opts...)
+}
+
+func TestExpressionsOnTypedData(t *testing.T) {
+	var tests = []struct {
+		expression string
+		expected   interface{}
+	}{
+		{"labels.a", "x"},
+		{"named.k", "v"},
+		{"sort(labels.*)", []interface{}{"x", "y"}},
+		{"sort(items.*.name)", []interface{}{"one", "two"}},
+		{"list[*].name", []interface{}{"b", "a", "c"}},
+		{"list[?score > `1`].name", []interface{}{"b", "c"}},
+		{"words[?@ == 'a']", []interface{}{"a", "a"}},
+		{"array[1]", "y"},
+		{"array[-1]", "z"},
+		{"array[1:]", []interface{}{"y", "z"}},
+		{"array[*]", []interface{}{"x", "y", "z"}},
+		{"pairs[]", []interface{}{"a", "x", "b", "y"}},
+		{"pairs[][0]", []interface{}{}},
+		{"pairs[*][0]", []interface{}{"a", "b"}},
+		{"!empty", true},
+		{"labels && 'yes'", "yes"},
+		{"empty || 'none'", "none"},
+		{"[words, array][]", []interface{}{"b", "a", "c", "a", "x", "y", "z"}},
+	}
+	data := typedTestData()
+	for _, tt := range tests {
+		for _, opts := range [][]Option{nil, {WithBytecode()}} {
+			actual, err := Search(tt.expression, data, opts...)
+			assert.Nil(t, err, tt.expression)
+			assert.Equal(t, tt.expected, actual, tt.expression)
+		}
+	}
+}
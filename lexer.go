@@ -82,6 +82,14 @@ const (
 	tNot
 	tEOF
 	tRoot
+	tVariable
+	tAssign
+	tPlus
+	tMinus
+	tDivide
+	tModulo
+	tIntDivide
+	tQuestion
 )
 
 var basicTokens = map[rune]tokType{
@@ -95,7 +103,9 @@ var basicTokens = map[rune]tokType{
 	'(': tLparen,
 	')': tRparen,
 	'@': tCurrent,
-	'$': tRoot,
+	'+': tPlus,
+	'%': tModulo,
+	'?': tQuestion,
 }
 
 // Bit mask for [a-zA-Z_] shifted down 64 bits to fit in a single uint64.
@@ -154,6 +164,9 @@ loop:
 		if identifierStartBits&(1<<(uint64(r)-64)) > 0 {
 			t := lexer.consumeUnquotedIdentifier()
 			tokens = append(tokens, t)
+		} else if r == '$' {
+			t := lexer.consumeVariable()
+			tokens = append(tokens, t)
 		} else if val, ok := basicTokens[r]; ok {
 			// Basic single char token.
 			t := token{
@@ -163,6 +176,15 @@ loop:
 				length:    1,
 			}
 			tokens = append(tokens, t)
+		} else if r == '-' && !(lexer.peek() >= '0' && lexer.peek() <= '9') {
+			// A "-" that does not start a number is a minus sign.
+			t := token{
+				tokenType: tMinus,
+				value:     "-",
+				position:  lexer.currentPos - 1,
+				length:    1,
+			}
+			tokens = append(tokens, t)
 		} else if r == '-' || (r >= '0' && r <= '9') {
 			t := lexer.consumeNumber()
 			tokens = append(tokens, t)
@@ -200,7 +222,10 @@ loop:
 			t := lexer.matchOrElse(r, '=', tNE, tNot)
 			tokens = append(tokens, t)
 		} else if r == '=' {
-			t := lexer.matchOrElse(r, '=', tEQ, tUnknown)
+			t := lexer.matchOrElse(r, '=', tEQ, tAssign)
+			tokens = append(tokens, t)
+		} else if r == '/' {
+			t := lexer.matchOrElse(r, '/', tIntDivide, tDivide)
 			tokens = append(tokens, t)
 		} else if r == '&' {
 			t := lexer.matchOrElse(r, '&', tAnd, tExpref)
@@ -388,7 +413,7 @@ func (lexer *Lexer) consumeUnquotedIdentifier() token {
 	start := lexer.currentPos - lexer.lastWidth
 	for {
 		r := lexer.next()
-		if r < 0 || r > 128 || identifierTrailingBits[uint64(r)/64]&(1<<(uint64(r)%64)) == 0 {
+		if r < 0 || r >= 128 || identifierTrailingBits[uint64(r)/64]&(1<<(uint64(r)%64)) == 0 {
 			lexer.back()
 			break
 		}
@@ -402,6 +427,24 @@ func (lexer *Lexer) consumeUnquotedIdentifier() token {
 	}
 }
 
+// consumeVariable consumes a variable reference such as "$foo", whose
+// token value is the name without the "$", or a "$" on its own, which is
+// the root node.  The "$" has been read already.
+func (lexer *Lexer) consumeVariable() token {
+	start := lexer.currentPos - lexer.lastWidth
+	if r := lexer.next(); identifierStartBits&(1<<(uint64(r)-64)) == 0 {
+		lexer.back()
+		return token{tokenType: tRoot, value: "$", position: start, length: 1}
+	}
+	name := lexer.consumeUnquotedIdentifier()
+	return token{
+		tokenType: tVariable,
+		value:     name.value,
+		position:  start,
+		length:    name.length + 1,
+	}
+}
+
 func (lexer *Lexer) consumeNumber() token {
 	// Consume runes until we reach something that's not a number.
 	start := lexer.currentPos - lexer.lastWidth
@@ -45,6 +45,15 @@ var lexingTests = []struct {
 	{`'foo\'bar'`, []token{{tStringLiteral, "foo'bar", 1, 7}}},
 	{"@", []token{{tCurrent, "@", 0, 1}}},
 	{"&", []token{{tExpref, "&", 0, 1}}},
+	{"$", []token{{tRoot, "$", 0, 1}}},
+	{"$foo", []token{{tVariable, "foo", 0, 4}}},
+	{"=", []token{{tAssign, "=", 0, 1}}},
+	{"+", []token{{tPlus, "+", 0, 1}}},
+	{"-", []token{{tMinus, "-", 0, 1}}},
+	{"/", []token{{tDivide, "/", 0, 1}}},
+	{"//", []token{{tIntDivide, "//", 0, 2}}},
+	{"%", []token{{tModulo, "%", 0, 1}}},
+	{"?", []token{{tQuestion, "?", 0, 1}}},
 	// Quoted identifier unicode escape sequences
 	{`"\u2713"`, []token{{tQuotedIdentifier, "✓", 0, 3}}},
 	{`"\\"`, []token{{tQuotedIdentifier, `\`, 0, 1}}},
@@ -61,6 +70,33 @@ var lexingTests = []struct {
 		{tNumber, "0", 4, 1},
 		{tRbracket, "]", 5, 1},
 	}},
+	{"let $a = b in $a", []token{
+		{tUnquotedIdentifier, "let", 0, 3},
+		{tVariable, "a", 4, 2},
+		{tAssign, "=", 7, 1},
+		{tUnquotedIdentifier, "b", 9, 1},
+		{tUnquotedIdentifier, "in", 11, 2},
+		{tVariable, "a", 14, 2},
+	}},
+	{"a-b*-`1`", []token{
+		{tUnquotedIdentifier, "a", 0, 1},
+		{tMinus, "-", 1, 1},
+		{tUnquotedIdentifier, "b", 2, 1},
+		{tStar, "*", 3, 1},
+		{tMinus, "-", 4, 1},
+		{tJSONLiteral, "1", 6, 1},
+	}},
+	{"a-1", []token{
+		{tUnquotedIdentifier, "a", 0, 1},
+		{tNumber, "-1", 1, 2},
+	}},
+	{"a?b:c", []token{
+		{tUnquotedIdentifier, "a", 0, 1},
+		{tQuestion, "?", 1, 1},
+		{tUnquotedIdentifier, "b", 2, 1},
+		{tColon, ":", 3, 1},
+		{tUnquotedIdentifier, "c", 4, 1},
+	}},
 	{"foo[?a<b]", []token{
 		{tUnquotedIdentifier, "foo", 0, 3},
 		{tFilter, "[?", 3, 2},
@@ -95,7 +131,7 @@ var lexingErrorTests = []struct {
 	msg        string
 }{
 	{"'foo", "Missing closing single quote"},
-	{"[?foo==bar?]", "Unknown char '?'"},
+	{"[?foo==bar#]", "Unknown char '#'"},
 }
 
 func TestLexingErrors(t *testing.T) {
@@ -159,3 +195,10 @@ func runLexBenchmark(b *testing.B, expression string) {
 		lexer.tokenize(expression)
 	}
 }
+
+func TestLexingNonASCIIAfterIdentifier(t *testing.T) {
+	assert := assert.New(t)
+	lexer := NewLexer()
+	_, err := lexer.tokenize("A\u0080")
+	assert.NotNil(err)
+}
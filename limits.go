@@ -0,0 +1,122 @@
+package jmespath
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Limits bounds the resources a single expression may use, for when
+// expressions come from untrusted users.  A zero field means no limit.
+type Limits struct {
+	// MaxDepth is the maximum nesting depth of the parsed expression.
+	MaxDepth int
+	// MaxSteps is the maximum number of AST nodes evaluated by a search,
+	// counting every node once per value it is applied to.
+	MaxSteps int
+	// MaxElements is the maximum total number of array elements and
+	// object members that a search may produce.  It is charged with the
+	// length of every array or object returned by a projection, flatten,
+	// multi-select, slice or function call, including intermediate ones.
+	MaxElements int
+	// MaxBytes is the maximum total length of the strings that function
+	// calls may produce during a search.
+	MaxBytes int
+}
+
+// WithLimits makes Compile and Search enforce limits.  The depth limit is
+// checked when the expression is parsed, the others while it is evaluated.
+func WithLimits(limits Limits) Option {
+	return func(c *config) {
+		c.limits = limits
+	}
+}
+
+// LimitExceededError is returned when parsing or evaluating an expression
+// goes over one of the bounds set with WithLimits.
+type LimitExceededError struct {
+	Limit      string // Name of the Limits field that was exceeded
+	Max        int    // The value the limit was set to
+	Expression string // Expression that exceeded the limit
+	Offset     int    // The location in Expression of the part that exceeded it
+}
+
+func (e LimitExceededError) Error() string {
+	return fmt.Sprintf("LimitExceeded: %s of %d exceeded", e.Limit, e.Max)
+}
+
+// HighlightLocation will show where the limit was exceeded.
+// It will place a "^" character on a line below the expression
+// at the start of the part of the expression that exceeded it.
+func (e LimitExceededError) HighlightLocation() string {
+	return e.Expression + "\n" + strings.Repeat(" ", e.Offset) + "^"
+}
+
+// checkDepth returns a LimitExceededError for the first node of ast found
+// deeper than maxDepth.  The walk never goes further than maxDepth+1 levels,
+// so it is safe to run on arbitrarily deep trees.
+func checkDepth(ast ASTNode, expression string, maxDepth int) error {
+	var walk func(node ASTNode, depth int) error
+	walk = func(node ASTNode, depth int) error {
+		if depth > maxDepth {
+			return LimitExceededError{
+				Limit:      "MaxDepth",
+				Max:        maxDepth,
+				Expression: expression,
+				Offset:     node.position,
+			}
+		}
+		if keyExpr, ok := node.value.(ASTNode); ok {
+			if err := walk(keyExpr, depth+1); err != nil {
+				return err
+			}
+		}
+		for _, child := range node.children {
+			if err := walk(child, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(ast, 1)
+}
+
+// countResult charges the collection or string that node produced against
+// the element and byte limits.  Only nodes that build new values are
+// charged; fields and indexes just select from values already counted or
+// from the input document.
+func (intr *treeInterpreter) countResult(node ASTNode, result interface{}) error {
+	switch node.nodeType {
+	case ASTProjection, ASTFilterProjection, ASTValueProjection, ASTFlatten,
+		ASTMultiSelectList, ASTMultiSelectHash, ASTSlice, ASTFunctionExpression:
+	default:
+		return nil
+	}
+	if s, ok := result.(string); ok {
+		if node.nodeType != ASTFunctionExpression || intr.limits.MaxBytes <= 0 {
+			return nil
+		}
+		intr.bytes += len(s)
+		if intr.bytes > intr.limits.MaxBytes {
+			return intr.limitExceeded("MaxBytes", intr.limits.MaxBytes, node)
+		}
+		return nil
+	}
+	if intr.limits.MaxElements <= 0 || result == nil {
+		return nil
+	}
+	switch rv := reflect.ValueOf(result); rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		intr.elements += rv.Len()
+		if intr.elements > intr.limits.MaxElements {
+			return intr.limitExceeded("MaxElements", intr.limits.MaxElements, node)
+		}
+	}
+	return nil
+}
+
+// limitExceeded aborts the search with a LimitExceededError located at
+// node.
+func (intr *treeInterpreter) limitExceeded(limit string, max int, node ASTNode) error {
+	return intr.abort(LimitExceededError{Limit: limit, Max: max, Offset: node.position})
+}
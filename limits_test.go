@@ -0,0 +1,136 @@
+package jmespath
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func limitError(t *testing.T, err error) LimitExceededError {
+	limitErr, ok := err.(LimitExceededError)
+	assert.True(t, ok, "expected a LimitExceededError, got %T: %v", err, err)
+	return limitErr
+}
+
+func TestLimitsMaxDepth(t *testing.T) {
+	var tests = []struct {
+		expression string
+		ok         bool
+		offset     int
+	}{
+		{"a.b", true, 0},
+		{"a.b.c", true, 0},
+		{"a.b.c.d", false, 0},
+		{"[[[a]]]", false, 3},
+		{"((((a))))", false, 3},
+		{"{a: {b: {c: d}}}", false, 12},
+		{"a[?b[?c[?d]]]", false, 9},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.expression, WithLimits(Limits{MaxDepth: 3}))
+		if tt.ok {
+			assert.Nil(t, err, tt.expression)
+			continue
+		}
+		limitErr := limitError(t, err)
+		assert.Equal(t, "MaxDepth", limitErr.Limit, tt.expression)
+		assert.Equal(t, 3, limitErr.Max, tt.expression)
+		assert.Equal(t, tt.expression, limitErr.Expression, tt.expression)
+		assert.Equal(t, tt.offset, limitErr.Offset, tt.expression)
+	}
+}
+
+func TestLimitsMaxDepthDeepNesting(t *testing.T) {
+	assert := assert.New(t)
+	expression := strings.Repeat("[", 100000) + "a" + strings.Repeat("]", 100000)
+	_, err := Compile(expression, WithLimits(Limits{MaxDepth: 50}))
+	assert.Equal("MaxDepth", limitError(t, err).Limit)
+	expression = "a" + strings.Repeat(".a", 100000)
+	_, err = Compile(expression, WithLimits(Limits{MaxDepth: 50}))
+	assert.Equal("MaxDepth", limitError(t, err).Limit)
+}
+
+func TestLimitsMaxSteps(t *testing.T) {
+	assert := assert.New(t)
+	data := make([]interface{}, 100)
+	for i := range data {
+		data[i] = map[string]interface{}{"a": float64(i)}
+	}
+	compiled := MustCompile("[*].a", WithLimits(Limits{MaxSteps: 50}))
+	_, err := compiled.Search(data)
+	limitErr := limitError(t, err)
+	assert.Equal("MaxSteps", limitErr.Limit)
+	assert.Equal(50, limitErr.Max)
+
+	// Steps are counted per search, not per compiled expression.
+	compiled = MustCompile("[*].a", WithLimits(Limits{MaxSteps: 250}))
+	for i := 0; i < 3; i++ {
+		result, err := compiled.Search(data)
+		assert.Nil(err)
+		assert.Len(result, 100)
+	}
+}
+
+func TestLimitsMaxStepsInsideSortBy(t *testing.T) {
+	assert := assert.New(t)
+	data := make([]interface{}, 1000)
+	for i := range data {
+		data[i] = float64(len(data) - i)
+	}
+	_, err := Search("sort_by(@, &@)", data, WithLimits(Limits{MaxSteps: 100}))
+	assert.Equal("MaxSteps", limitError(t, err).Limit)
+}
+
+func TestLimitsMaxElements(t *testing.T) {
+	assert := assert.New(t)
+	data := map[string]interface{}{
+		"foo": []interface{}{1.0, 2.0, 3.0, 4.0, 5.0},
+		"bar": []interface{}{"a", "b", "c", "d", "e"},
+	}
+	var tests = []struct {
+		expression string
+		ok         bool
+	}{
+		{"foo", true},
+		{"foo[*]", true},
+		{"foo[*].[@, @]", false},
+		{"zip(foo, bar)", true},
+		{"[zip(foo, bar), zip(bar, foo)]", false},
+		{"map(&[@], foo)", true},
+		{"map(&[@, @], foo)", false},
+		{"from_items(zip(bar, foo))", true},
+		{"[from_items(zip(bar, foo)), foo[*]]", false},
+		{"[foo, bar, foo]", true},
+	}
+	for _, tt := range tests {
+		_, err := Search(tt.expression, data, WithLimits(Limits{MaxElements: 10}))
+		if tt.ok {
+			assert.Nil(err, tt.expression)
+			continue
+		}
+		limitErr := limitError(t, err)
+		assert.Equal("MaxElements", limitErr.Limit, tt.expression)
+		assert.Equal(tt.expression, limitErr.Expression, tt.expression)
+	}
+}
+
+func TestLimitsMaxBytes(t *testing.T) {
+	assert := assert.New(t)
+	data := map[string]interface{}{"foo": []interface{}{"aaaa", "bbbb", "cccc"}}
+	result, err := Search("join(',', foo)", data, WithLimits(Limits{MaxBytes: 14}))
+	assert.Nil(err)
+	assert.Equal("aaaa,bbbb,cccc", result)
+	_, err = Search("foo[*].join('', [@, @, @, @])", data, WithLimits(Limits{MaxBytes: 40}))
+	limitErr := limitError(t, err)
+	assert.Equal("MaxBytes", limitErr.Limit)
+	assert.Equal(7, limitErr.Offset)
+}
+
+func TestLimitsErrorNotWrapped(t *testing.T) {
+	assert := assert.New(t)
+	data := []interface{}{[]interface{}{1.0}, []interface{}{2.0}}
+	_, err := Search("[*].abs([0])", data, WithLimits(Limits{MaxSteps: 3}))
+	assert.Equal("MaxSteps", limitError(t, err).Limit)
+	assert.Contains(err.Error(), "LimitExceeded")
+}
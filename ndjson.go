@@ -0,0 +1,140 @@
+package jmespath
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// RecordError is returned by SearchNDJSON when a record cannot be decoded
+// or searched, or its result cannot be encoded.
+type RecordError struct {
+	Line int   // Line of the input the record is on, counting from 1
+	Err  error // What went wrong
+}
+
+func (e RecordError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+// Unwrap returns the error the record failed with.
+func (e RecordError) Unwrap() error {
+	return e.Err
+}
+
+// ndjsonRecord is a line of input on its way through SearchNDJSON's
+// workers.
+type ndjsonRecord struct {
+	line   int
+	data   []byte
+	result chan ndjsonResult // Buffered, so that workers never wait
+}
+
+type ndjsonResult struct {
+	encoded []byte // The result as a line of JSON
+	err     error
+}
+
+// SearchNDJSON searches each record of the newline-delimited JSON read
+// from r, and writes each result to w as a line of JSON, in the order of
+// the records.  Blank lines are skipped.  With more than one worker,
+// records are searched concurrently by that many goroutines, while at most
+// a few records per worker are held in memory.
+//
+// Records are searched with SearchJSON, so only the parts the expression
+// visits are decoded.  SearchNDJSON stops at the first record that fails,
+// returning a RecordError, or at the first error reading r or writing w.
+func (jp *JMESPath) SearchNDJSON(r io.Reader, w io.Writer, workers int) error {
+	if workers <= 1 {
+		return readRecords(r, func(record *ndjsonRecord) error {
+			result := jp.searchRecord(record)
+			if result.err != nil {
+				return result.err
+			}
+			_, err := w.Write(result.encoded)
+			return err
+		})
+	}
+
+	// The reader queues every record both for the workers and, in order,
+	// for the writer below, which waits for each result in turn.
+	done := make(chan struct{})
+	defer close(done)
+	work := make(chan *ndjsonRecord)
+	ordered := make(chan *ndjsonRecord, workers)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(ordered)
+		defer close(work)
+		readErr <- readRecords(r, func(record *ndjsonRecord) error {
+			select {
+			case ordered <- record:
+			case <-done:
+				return errStopped
+			}
+			select {
+			case work <- record:
+			case <-done:
+				return errStopped
+			}
+			return nil
+		})
+	}()
+	for i := 0; i < workers; i++ {
+		go func() {
+			for record := range work {
+				record.result <- jp.searchRecord(record)
+			}
+		}()
+	}
+	for record := range ordered {
+		result := <-record.result
+		if result.err != nil {
+			return result.err
+		}
+		if _, err := w.Write(result.encoded); err != nil {
+			return err
+		}
+	}
+	return <-readErr
+}
+
+// errStopped stops the reader once SearchNDJSON has returned.
+var errStopped = errors.New("jmespath: stopped")
+
+// readRecords calls handle with each non-blank line of r, stopping at the
+// first error it returns.
+func readRecords(r io.Reader, handle func(*ndjsonRecord) error) error {
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(data)) > 0 {
+			record := &ndjsonRecord{line: line, data: data, result: make(chan ndjsonResult, 1)}
+			if err := handle(record); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// searchRecord searches a record and encodes the result.
+func (jp *JMESPath) searchRecord(record *ndjsonRecord) ndjsonResult {
+	result, err := jp.SearchJSON(record.data)
+	if err != nil {
+		return ndjsonResult{err: RecordError{Line: record.line, Err: err}}
+	}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return ndjsonResult{err: RecordError{Line: record.line, Err: err}}
+	}
+	return ndjsonResult{encoded: append(encoded, '\n')}
+}
@@ -0,0 +1,103 @@
+package jmespath
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ndjsonInput(records int) (string, string) {
+	var input, expected strings.Builder
+	for i := 0; i < records; i++ {
+		fmt.Fprintf(&input, `{"id": %d, "tags": ["t%d"], "skip": {"big": [1, 2, 3]}}`+"\n", i, i)
+		fmt.Fprintf(&expected, `{"id":%d,"tag":"t%d"}`+"\n", i, i)
+	}
+	return input.String(), expected.String()
+}
+
+func TestSearchNDJSON(t *testing.T) {
+	assert := assert.New(t)
+	input, expected := ndjsonInput(500)
+	jp := MustCompile("{id: id, tag: tags[0]}")
+	for _, workers := range []int{0, 1, 2, 8} {
+		var output bytes.Buffer
+		err := jp.SearchNDJSON(strings.NewReader(input), &output, workers)
+		assert.Nil(err, "workers: %d", workers)
+		assert.Equal(expected, output.String(), "workers: %d", workers)
+	}
+}
+
+func TestSearchNDJSONBlankLinesAndMissingNewline(t *testing.T) {
+	assert := assert.New(t)
+	input := "\n{\"a\": 1}\r\n   \n{\"b\": 2}\n{\"a\": [3]}"
+	for _, workers := range []int{1, 4} {
+		var output bytes.Buffer
+		err := MustCompile("a").SearchNDJSON(strings.NewReader(input), &output, workers)
+		assert.Nil(err)
+		assert.Equal("1\nnull\n[3]\n", output.String())
+	}
+}
+
+func TestSearchNDJSONRecordErrors(t *testing.T) {
+	assert := assert.New(t)
+	input := "{\"a\": \"x\"}\n\n{\"a\": 1}\n{\"a\": \"y\"}\n"
+	for _, workers := range []int{1, 4} {
+		var output bytes.Buffer
+		err := MustCompile("length(a)").SearchNDJSON(strings.NewReader(input), &output, workers)
+		var recordErr RecordError
+		if assert.True(errors.As(err, &recordErr), "%v", err) {
+			assert.Equal(3, recordErr.Line)
+			var runtimeErr RuntimeError
+			assert.True(errors.As(err, &runtimeErr))
+			assert.Equal("length", runtimeErr.FunctionName)
+		}
+		assert.Equal("1\n", output.String())
+
+		output.Reset()
+		err = MustCompile("a").SearchNDJSON(strings.NewReader("{\"a\": 1}\n{\"a\"\n"), &output, workers)
+		assert.EqualError(err, "line 2: unexpected end of JSON input")
+		assert.Equal("1\n", output.String())
+	}
+}
+
+type failingWriter struct {
+	writes int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return 0, errors.New("write failed")
+}
+
+func TestSearchNDJSONWriteAndReadErrors(t *testing.T) {
+	assert := assert.New(t)
+	input, _ := ndjsonInput(100)
+	jp := MustCompile("id")
+	for _, workers := range []int{1, 4} {
+		w := &failingWriter{}
+		err := jp.SearchNDJSON(strings.NewReader(input), w, workers)
+		assert.EqualError(err, "write failed")
+		assert.Equal(1, w.writes)
+
+		var output bytes.Buffer
+		err = jp.SearchNDJSON(failingReader{}, &output, workers)
+		assert.EqualError(err, "read failed")
+	}
+}
+
+func BenchmarkSearchNDJSON(b *testing.B) {
+	input, _ := ndjsonInput(1000)
+	jp := MustCompile("{id: id, tag: tags[0]}")
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				var output bytes.Buffer
+				jp.SearchNDJSON(strings.NewReader(input), &output, workers)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package jmespath
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// Every Go integer and floating point type, and json.Number, is a
+// JMESPath number.  Numbers are compared by value whatever their types, and
+// integers are compared exactly, so int64 IDs beyond 2^53 keep their
+// identity in comparisons, sorts and dedup.
+//
+// Functions that select numbers from their input, such as max or sort,
+// return the numbers they were given.  Functions that compute numbers
+// return an int64 for an integral result of integer inputs and a float64
+// otherwise, and so do the arithmetic operators.  Unless WithExactIntegers
+// is set, the numbers builtins and operators return are then converted to
+// float64, as encoding/json would decode them.
+
+// numberKind says which field of a number holds its value.
+type numberKind int
+
+const (
+	intNumber numberKind = iota
+	uintNumber
+	floatNumber
+)
+
+// number is the value of a JMESPath number.
+type number struct {
+	kind numberKind
+	i    int64
+	u    uint64
+	f    float64
+}
+
+// asNumber returns the value of v if it is a number.
+func asNumber(v interface{}) (number, bool) {
+	switch v := v.(type) {
+	case float64:
+		return number{kind: floatNumber, f: v}, true
+	case int:
+		return number{kind: intNumber, i: int64(v)}, true
+	case int64:
+		return number{kind: intNumber, i: v}, true
+	case json.Number:
+		return parseNumber(string(v))
+	case nil, bool, string, []interface{}, map[string]interface{}:
+		return number{}, false
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return number{kind: intNumber, i: rv.Int()}, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return number{kind: uintNumber, u: rv.Uint()}, true
+	case reflect.Float32, reflect.Float64:
+		return number{kind: floatNumber, f: rv.Float()}, true
+	}
+	return number{}, false
+}
+
+// isNumber reports whether v is a JMESPath number.
+func isNumber(v interface{}) bool {
+	_, ok := asNumber(v)
+	return ok
+}
+
+// parseNumber parses s as an integer if it is one, and as a float
+// otherwise.
+func parseNumber(s string) (number, bool) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return number{kind: intNumber, i: i}, true
+	}
+	if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return number{kind: uintNumber, u: u}, true
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return number{}, false
+	}
+	return number{kind: floatNumber, f: f}, true
+}
+
+func (n number) isInteger() bool {
+	return n.kind != floatNumber
+}
+
+func (n number) float() float64 {
+	switch n.kind {
+	case intNumber:
+		return float64(n.i)
+	case uintNumber:
+		return float64(n.u)
+	}
+	return n.f
+}
+
+// value returns n as an int64, a uint64 if it is too large for an int64,
+// or a float64.
+func (n number) value() interface{} {
+	switch n.kind {
+	case intNumber:
+		return n.i
+	case uintNumber:
+		if n.u <= math.MaxInt64 {
+			return int64(n.u)
+		}
+		return n.u
+	}
+	return n.f
+}
+
+// integer returns n as an integer if it is integral and in range.
+func (n number) integer() (number, bool) {
+	switch {
+	case n.kind != floatNumber:
+		return n, true
+	case n.f != math.Trunc(n.f):
+		return n, false
+	case n.f >= -(1<<63) && n.f < 1<<63:
+		return number{kind: intNumber, i: int64(n.f)}, true
+	case n.f >= 0 && n.f < 1<<64:
+		return number{kind: uintNumber, u: uint64(n.f)}, true
+	}
+	return n, false
+}
+
+// cmp returns -1, 0 or +1 as n is less than, equal to or greater than
+// other.  Integers are compared exactly, with each other and with integral
+// floats.
+func (n number) cmp(other number) int {
+	a, aok := n.integer()
+	b, bok := other.integer()
+	if !aok || !bok {
+		x, y := n.float(), other.float()
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	switch {
+	case a.kind == intNumber && b.kind == intNumber:
+		return cmpInt64(a.i, b.i)
+	case a.kind == uintNumber && b.kind == uintNumber:
+		return cmpUint64(a.u, b.u)
+	case a.kind == intNumber:
+		if a.i < 0 {
+			return -1
+		}
+		return cmpUint64(uint64(a.i), b.u)
+	}
+	if b.i < 0 {
+		return 1
+	}
+	return cmpUint64(a.u, uint64(b.i))
+}
+
+func cmpInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func cmpUint64(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// key returns a value usable as a map key that is the same for numbers
+// that are equal.
+func (n number) key() interface{} {
+	if i, ok := n.integer(); ok {
+		if i.kind == intNumber {
+			if i.i >= -(1<<53) && i.i <= 1<<53 {
+				return float64(i.i)
+			}
+			return i.i
+		}
+		if i.u <= math.MaxInt64 {
+			return number{kind: intNumber, i: int64(i.u)}.key()
+		}
+		return i.u
+	}
+	return n.f
+}
+
+// floatNumbers converts the numbers a builtin returned, on their own or as
+// the elements of an array, to float64.  float64 and json.Number values
+// are left as they are.
+func floatNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil, bool, string, float64, json.Number, map[string]interface{}:
+		return v
+	case []interface{}:
+		var converted []interface{}
+		for i, item := range v {
+			if f, ok := floatNumber64(item); ok {
+				if converted == nil {
+					converted = make([]interface{}, len(v))
+					copy(converted, v)
+				}
+				converted[i] = f
+			}
+		}
+		if converted == nil {
+			return v
+		}
+		return converted
+	}
+	if f, ok := floatNumber64(v); ok {
+		return f
+	}
+	return v
+}
+
+// floatNumber64 returns v as a float64 if it is a number of a type other
+// than float64 and json.Number.
+func floatNumber64(v interface{}) (float64, bool) {
+	switch v.(type) {
+	case float64, json.Number:
+		return 0, false
+	}
+	n, ok := asNumber(v)
+	if !ok {
+		return 0, false
+	}
+	return n.float(), true
+}
+
+// exactLiteral replaces the json.Numbers in a literal decoded with
+// UseNumber by int64s, or uint64s, for integers and float64s otherwise.
+func exactLiteral(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if n, ok := parseNumber(string(v)); ok {
+			return n.value()
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = exactLiteral(item)
+		}
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = exactLiteral(value)
+		}
+	}
+	return v
+}
+
+// WithExactIntegers stops builtin functions from converting the numbers
+// they return to float64: integers they compute are returned as int64,
+// and numbers they select from the input keep their Go types.  JSON
+// literals in the expression are decoded the same way, so integers beyond
+// 2^53 survive intact.
+func WithExactIntegers() Option {
+	return func(c *config) {
+		c.exactIntegers = true
+	}
+}
@@ -0,0 +1,179 @@
+package jmespath
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type score int32
+
+func numericTestData() map[string]interface{} {
+	return map[string]interface{}{
+		"ints":    []int{3, -1, 2},
+		"int32s":  []int32{5, 4},
+		"uints":   []uint64{math.MaxUint64, 1},
+		"scores":  []score{2, 9, 4},
+		"mixed":   []interface{}{int8(2), 1.5, json.Number("3"), uint16(1)},
+		"numbers": []interface{}{json.Number("2.5"), json.Number("10"), json.Number("-3")},
+		"ids":     []int64{1<<53 + 1, 1 << 53, 1<<53 + 2},
+		"big":     int64(1<<53 + 1),
+		"bigger":  int64(1<<53 + 2),
+		"same":    json.Number("9007199254740993"),
+		"one":     1,
+		"float":   1.0,
+		"neg":     int64(math.MinInt64),
+		"people": []map[string]interface{}{
+			{"name": "a", "age": 30},
+			{"name": "b", "age": int64(20)},
+			{"name": "c", "age": json.Number("25")},
+		},
+	}
+}
+
+func TestSearchNumbers(t *testing.T) {
+	var tests = []struct {
+		expression string
+		expected   interface{}
+	}{
+		{"type(ints[0])", "number"},
+		{"type(scores[0])", "number"},
+		{"type(uints[0])", "number"},
+		{"type(numbers[0])", "number"},
+		{"abs(ints[1])", 1.0},
+		{"abs(numbers[2])", 3.0},
+		{"ceil(numbers[0])", 3.0},
+		{"floor(scores[1])", 9.0},
+		{"sum(ints)", 4.0},
+		{"sum(mixed)", 7.5},
+		{"avg(int32s)", 4.5},
+		{"max(ints)", 3.0},
+		{"min(ints)", -1.0},
+		{"max(uints)", float64(math.MaxUint64)},
+		{"max(scores)", 9.0},
+		{"max(mixed)", json.Number("3")},
+		{"min(mixed)", 1.0},
+		{"max(numbers)", json.Number("10")},
+		{"sort(ints)", []interface{}{-1.0, 2.0, 3.0}},
+		{"sort(mixed)", []interface{}{1.0, 1.5, 2.0, json.Number("3")}},
+		{"sort(numbers)", []interface{}{json.Number("-3"), json.Number("2.5"), json.Number("10")}},
+		{"sort_by(people, &age)[*].name", []interface{}{"b", "c", "a"}},
+		{"max_by(people, &age).name", "a"},
+		{"min_by(people, &age).name", "b"},
+		{"ints[?@ > `1`]", []interface{}{3, 2}},
+		{"scores[?@ >= `4`]", []interface{}{score(9), score(4)}},
+		{"people[?age < `26`].name", []interface{}{"b", "c"}},
+		{"to_number(numbers[1])", 10.0},
+		{"to_number('12')", 12.0},
+		{"to_number(ints[0])", 3.0},
+		{"one == float", true},
+		{"same == big", true},
+		{"big == bigger", false},
+		{"big < bigger", true},
+		{"ids[?@ == `9007199254740993`]", []interface{}{int64(1 << 53)}},
+		{"dedup(`[1, 1.0, 2]`)", []interface{}{1.0, 2.0}},
+		{"dedup(ids)", []interface{}{float64(1<<53 + 1), float64(1 << 53), float64(1<<53 + 2)}},
+		{"length(dedup([one, float, same, big]))", 2.0},
+		{"abs(neg)", float64(1 << 63)},
+		{"contains(ints, `2`)", true},
+	}
+	data := numericTestData()
+	for _, tt := range tests {
+		for _, opts := range [][]Option{nil, {WithBytecode()}} {
+			actual, err := Search(tt.expression, data, opts...)
+			assert.Nil(t, err, tt.expression)
+			assert.Equal(t, tt.expected, actual, tt.expression)
+		}
+	}
+}
+
+func TestSearchExactIntegers(t *testing.T) {
+	var tests = []struct {
+		expression string
+		expected   interface{}
+	}{
+		{"length(ints)", int64(3)},
+		{"sum(ints)", int64(4)},
+		{"sum(mixed)", 7.5},
+		{"sum(`[9223372036854775807, 1]`)", float64(1 << 63)},
+		{"abs(ints[1])", int64(1)},
+		{"abs(neg)", uint64(1 << 63)},
+		{"ceil(scores[0])", int64(2)},
+		{"floor(`2.5`)", 2.0},
+		{"avg(ints)", 4.0 / 3},
+		{"to_number('12')", int64(12)},
+		{"to_number('18446744073709551615')", uint64(math.MaxUint64)},
+		{"to_number('1.5')", 1.5},
+		{"max(ids)", int64(1<<53 + 2)},
+		{"max(scores)", score(9)},
+		{"min(mixed)", uint16(1)},
+		{"sort(ids)", []interface{}{int64(1 << 53), int64(1<<53 + 1), int64(1<<53 + 2)}},
+		{"`9007199254740993`", int64(1<<53 + 1)},
+		{"`[1, 2.5]`", []interface{}{int64(1), 2.5}},
+		{"`{\"a\": 18446744073709551615}`.a", uint64(math.MaxUint64)},
+		{"ids[?@ == `9007199254740993`]", []interface{}{int64(1<<53 + 1)}},
+		{"ids[?@ > `9007199254740992`]", []interface{}{int64(1<<53 + 1), int64(1<<53 + 2)}},
+		{"bigger - big", int64(1)},
+		{"big + one", int64(1<<53 + 2)},
+		{"same * `2`", int64(1<<54 + 2)},
+		{"big / one", int64(1<<53 + 1)},
+		{"ints[0] / ints[2]", 1.5},
+		{"ints[0] // ints[1]", int64(-3)},
+		{"ints[0] % ints[2]", int64(1)},
+		{"-neg", float64(1 << 63)},
+		{"neg - one", -float64(1 << 63)},
+		{"uints[0] - one", float64(math.MaxUint64 - 1)},
+		{"float * one", 1.0},
+	}
+	data := numericTestData()
+	for _, tt := range tests {
+		for _, opts := range [][]Option{{WithExactIntegers()}, {WithExactIntegers(), WithBytecode()}} {
+			actual, err := Search(tt.expression, data, opts...)
+			assert.Nil(t, err, tt.expression)
+			assert.Equal(t, tt.expected, actual, tt.expression)
+		}
+	}
+}
+
+func TestSearchDecodedWithUseNumber(t *testing.T) {
+	assert := assert.New(t)
+	decoder := json.NewDecoder(strings.NewReader(`{"ids": [9007199254740993, 9007199254740992, 9007199254740993]}`))
+	decoder.UseNumber()
+	var data interface{}
+	assert.Nil(decoder.Decode(&data))
+
+	result, err := Search("sort(ids)", data)
+	assert.Nil(err)
+	assert.Equal([]interface{}{json.Number("9007199254740992"), json.Number("9007199254740993"), json.Number("9007199254740993")}, result)
+	result, err = Search("dedup(ids)", data)
+	assert.Nil(err)
+	assert.Equal([]interface{}{json.Number("9007199254740993"), json.Number("9007199254740992")}, result)
+	result, err = Search("length(ids[?@ == `9007199254740993`])", data, WithExactIntegers())
+	assert.Nil(err)
+	assert.Equal(int64(2), result)
+}
+
+func TestNumberCmp(t *testing.T) {
+	assert := assert.New(t)
+	n := func(v interface{}) number {
+		num, ok := asNumber(v)
+		assert.True(ok, "%v", v)
+		return num
+	}
+	assert.Equal(0, n(1).cmp(n(1.0)))
+	assert.Equal(0, n(json.Number("1e2")).cmp(n(uint8(100))))
+	assert.Equal(-1, n(int64(-1)).cmp(n(uint64(math.MaxUint64))))
+	assert.Equal(1, n(uint64(math.MaxUint64)).cmp(n(int64(math.MaxInt64))))
+	assert.Equal(-1, n(int64(1<<53)).cmp(n(int64(1<<53+1))))
+	assert.Equal(1, n(1.5).cmp(n(1)))
+	assert.Equal(n(1).key(), n(json.Number("1.0")).key())
+	assert.NotEqual(n(int64(1<<53+1)).key(), n(float64(1<<53)).key())
+
+	_, ok := asNumber("1")
+	assert.False(ok)
+	_, ok = asNumber(json.Number("x"))
+	assert.False(ok)
+}
@@ -0,0 +1,232 @@
+package jmespath
+
+// WithOptimization makes Compile simplify the expression before it is
+// evaluated.  Parts of it that do not depend on the input, such as
+// builtin function calls on literals, are evaluated once, at compile time,
+// and replaced by their result; chains of pipes and subexpressions are
+// flattened; and the "@" steps of those chains are dropped.  Parts that
+// call a custom function, or shuffle, are never evaluated at compile time.
+// Results are the same as without the option, except that the parts
+// evaluated at compile time are not counted against Limits, and that
+// builtin calls evaluated at compile time are not replaced by a custom
+// function registered under the same name after Compile.
+//
+// AST returns the simplified tree.
+func WithOptimization() Option {
+	return func(c *config) {
+		c.optimize = true
+	}
+}
+
+// optimizer simplifies ASTs, evaluating constant parts with intr.
+type optimizer struct {
+	intr     *treeInterpreter
+	registry *FunctionRegistry
+}
+
+// optimize returns a simplified copy of ast.
+func (o *optimizer) optimize(ast ASTNode) ASTNode {
+	return Rewrite(ast, o.simplify)
+}
+
+// simplify simplifies a node whose children have been simplified already.
+func (o *optimizer) simplify(node ASTNode) ASTNode {
+	switch node.nodeType {
+	case ASTPipe, ASTSubexpression:
+		return o.simplifyChain(node)
+	case ASTOrExpression, ASTAndExpression:
+		left := node.children[0]
+		if left.nodeType != ASTLiteral {
+			break
+		}
+		if isFalse(left.value) == (node.nodeType == ASTOrExpression) {
+			return node.children[1]
+		}
+		return left
+	case ASTConditional:
+		if condition := node.children[0]; condition.nodeType == ASTLiteral {
+			if isFalse(condition.value) {
+				return node.children[2]
+			}
+			return node.children[1]
+		}
+	case ASTFunctionExpression:
+		if node.value == "not_null" && o.isPure("not_null") && len(node.children) > 0 {
+			node = simplifyNotNull(node)
+			if node.nodeType != ASTFunctionExpression {
+				return node
+			}
+		}
+	}
+	if o.isConstant(node) {
+		return o.fold(node, node, nil)
+	}
+	return node
+}
+
+// simplifyChain flattens the children of a pipe that are pipes, or of a
+// subexpression that are subexpressions, into it, drops the steps that
+// evaluate to the current value, and folds the steps that start from a
+// literal.
+func (o *optimizer) simplifyChain(node ASTNode) ASTNode {
+	var steps []ASTNode
+	for _, child := range node.children {
+		switch child.nodeType {
+		case node.nodeType:
+			steps = append(steps, child.children...)
+		case ASTIdentity, ASTCurrentNode:
+		default:
+			steps = append(steps, child)
+		}
+	}
+	// A literal followed by a step that only looks at the current value
+	// can be replaced by the result of that step.
+	for len(steps) > 1 && steps[0].nodeType == ASTLiteral && o.foldable(steps[1]) {
+		folded := o.fold(steps[1], steps[0], steps[0].value)
+		if folded.nodeType != ASTLiteral {
+			break
+		}
+		steps = append([]ASTNode{folded}, steps[2:]...)
+	}
+	switch len(steps) {
+	case 0:
+		return ASTNode{nodeType: ASTCurrentNode, position: node.position}
+	case 1:
+		return steps[0]
+	}
+	node.children = steps
+	return node
+}
+
+// simplifyNotNull drops the null literal arguments of a call to not_null,
+// and the arguments after a literal that is not null that cannot fail.
+// The values of those arguments are never the result, but not_null
+// evaluates all its arguments, so any that may fail must be kept.
+func simplifyNotNull(node ASTNode) ASTNode {
+	var args []ASTNode
+	found := false
+	for _, arg := range node.children {
+		switch {
+		case found && cannotFail(arg):
+		case arg.nodeType != ASTLiteral:
+			args = append(args, arg)
+		case arg.value != nil:
+			args = append(args, arg)
+			found = true
+		}
+	}
+	switch {
+	case len(args) == 0:
+		return ASTNode{nodeType: ASTLiteral, position: node.position}
+	case len(args) == 1:
+		// not_null(x) is x, and a literal first argument is the result.
+		return args[0]
+	}
+	node.children = args
+	return node
+}
+
+// fold evaluates node against value and returns the result as a literal
+// in place of at, or node itself if the evaluation fails.  Errors are left
+// for the search to raise.
+func (o *optimizer) fold(node, at ASTNode, value interface{}) ASTNode {
+	result, err := o.intr.search(nil).Execute(node, value)
+	if err != nil || !isJSONValue(result) {
+		return node
+	}
+	return ASTNode{nodeType: ASTLiteral, value: result, position: at.position}
+}
+
+// isConstant reports whether node gives the same result whatever it is
+// evaluated against.  Its children are simplified already, so a constant
+// child would have been folded into a literal.
+func (o *optimizer) isConstant(node ASTNode) bool {
+	switch node.nodeType {
+	case ASTComparator, ASTNotExpression, ASTFlatten, ASTArithmetic, ASTNegate:
+		return allLiterals(node.children)
+	case ASTIndexExpression, ASTProjection, ASTValueProjection, ASTFilterProjection:
+		// The rest only looks at the elements of the literal.
+		if node.children[0].nodeType != ASTLiteral {
+			return false
+		}
+		for _, child := range node.children[1:] {
+			if !o.foldable(child) {
+				return false
+			}
+		}
+		return true
+	case ASTFunctionExpression:
+		if name, ok := node.value.(string); !ok || !o.isPure(name) {
+			return false
+		}
+		for _, arg := range node.children {
+			if arg.nodeType == ASTExpRef {
+				if !o.foldable(arg) {
+					return false
+				}
+			} else if arg.nodeType != ASTLiteral {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// pureBuiltins are the builtin functions whose results depend only on
+// their arguments.
+var pureBuiltins = map[string]bool{
+	"abs": true, "avg": true, "ceil": true, "contains": true,
+	"contains_any": true, "dedup": true, "dedup_by": true,
+	"descendants": true, "ends_with": true, "floor": true,
+	"from_items": true, "get": true, "items": true, "join": true,
+	"keys": true, "length": true, "map": true, "max": true, "max_by": true,
+	"merge": true, "min": true, "min_by": true, "not_null": true,
+	"reverse": true, "slice": true, "sort": true, "sort_by": true,
+	"starts_with": true, "sum": true, "to_array": true, "to_number": true,
+	"to_string": true, "type": true, "values": true, "zip": true,
+}
+
+// isPure reports whether name calls one of the pureBuiltins, rather than
+// a custom function, which could have side effects, or shuffle.
+func (o *optimizer) isPure(name string) bool {
+	entry, ok := o.registry.lookup(name)
+	return ok && entry.builtin && pureBuiltins[name]
+}
+
+// foldable reports whether node, evaluated against a literal, can be
+// evaluated at compile time: whether it refers to no other value, with
+// "$" or a variable, and only calls pure builtins.
+func (o *optimizer) foldable(node ASTNode) bool {
+	ok := true
+	Inspect(node, func(node ASTNode) bool {
+		switch node.nodeType {
+		case ASTRootNode, ASTVariable:
+			ok = false
+		case ASTFunctionExpression:
+			name, isName := node.value.(string)
+			ok = isName && o.isPure(name)
+		}
+		return ok
+	})
+	return ok
+}
+
+// cannotFail reports whether node evaluates without error for any
+// input.
+func cannotFail(node ASTNode) bool {
+	switch node.nodeType {
+	case ASTLiteral, ASTField, ASTCurrentNode, ASTIdentity:
+		return true
+	}
+	return false
+}
+
+func allLiterals(nodes []ASTNode) bool {
+	for _, node := range nodes {
+		if node.nodeType != ASTLiteral {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,188 @@
+package jmespath
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptimization(t *testing.T) {
+	var tests = []struct {
+		expression string
+		optimized  string
+	}{
+		{"`[1,2,3]` | length(@)", "`3`"},
+		{"`[1,2,3]` | length(@) | to_string(@)", "'3'"},
+		{"length(`[1,2,3]`) > `2`", "`true`"},
+		{"abs(`-2`)", "`2`"},
+		{"sort_by(`[{\"a\": 2}, {\"a\": 1}]`, &a)[*].a", "`[1,2]`"},
+		{"`{\"a\": [1, 2]}`.a[0]", "`1`"},
+		{"`[[1], [2]]`[]", "`[1,2]`"},
+		{"!`[]`", "`true`"},
+		{"a | @ | b", "a | b"},
+		{"@ | a", "a"},
+		{"a[0] | @", "a[0]"},
+		{"@ | @", "@"},
+		{"(a | b) | (c | d)", "a | b | c | d"},
+		{"a.b.c.d", "a.b.c.d"},
+		{"a.b[0].c.d | e", "a.b[0].c.d | e"},
+		{"(a | b).c", "(a | b).c"},
+		{"`{\"b\": 1}` | b | $.foo", "`1` | $.foo"},
+		{"not_null(`null`, `null`, a, `1`, b)", "not_null(a, `1`)"},
+		{"not_null(`null`, 'x', a)", "'x'"},
+		{"not_null(`null`, a)", "a"},
+		{"not_null(`null`)", "`null`"},
+		{"shuffle(`[1, 2]`)", "shuffle(`[1,2]`)"},
+		{"`[[1, 2]]` | map(&shuffle(@), @)", "`[[1,2]]` | map(&shuffle(@), @)"},
+		{"not_null(`1`, a, 'x', @)", "`1`"},
+		{"not_null(`1`, abs('x'))", "not_null(`1`, abs('x'))"},
+		{"not_null(a[5], `2`, b, abs(c))", "not_null(a[5], `2`, abs(c))"},
+		{"`true` || a", "`true`"},
+		{"`false` || a", "a"},
+		{"`[]` && a", "`[]`"},
+		{"`0` && a", "a"},
+		{"$.a || length('abc')", "$.a || `3`"},
+		{"`{\"a\": 1}` | $.a", "`{\"a\":1}` | $.a"},
+		{"items[?price > abs(`-10`)].name", "items[?price > `10`].name"},
+		{"sort_by(items, &(`[1]` | length(@)))", "sort_by(items, &`1`)"},
+		{"length(`1`)", "length(`1`)"},
+		{"map(&a, `[1, 2]`)", "`[null,null]`"},
+		{"[a, `1`]", "[a, `1`]"},
+		{"{a: `1`}", "{a: `1`}"},
+		{"let $a = abs(`-1`) in [$a, length('ab')]", "let $a = `1` in [$a, `2`]"},
+		{"let $a = a in map(&[$a], `[1]`)", "let $a = a in map(&[$a], `[1]`)"},
+		{"let $a = a in `{\"b\": 1}` | [b, $a]", "let $a = a in `{\"b\":1}` | [b, $a]"},
+		{"`2` * `3` + -`1`", "`5`"},
+		{"a * (`2` + `3`)", "a * `5`"},
+		{"`1` / `0`", "`1` / `0`"},
+		{"`\"\"` ? a : b", "b"},
+		{"length('ab') == `2` ? a : b", "a"},
+		{"a ? `1` + `1` : b", "a ? `2` : b"},
+	}
+	for _, tt := range tests {
+		jp, err := Compile(tt.expression, WithOptimization())
+		if assert.Nil(t, err, tt.expression) {
+			assert.Equal(t, tt.optimized, Format(jp.AST()), tt.expression)
+		}
+	}
+	jp := MustCompile("a.b.c.d", WithOptimization())
+	assert.Len(t, jp.AST().Children(), 4, "a.b.c.d is one subexpression")
+}
+
+func TestOptimizationSkipsCustomFunctions(t *testing.T) {
+	assert := assert.New(t)
+	registry := NewFunctionRegistry()
+	registry.Register("length", func([]Value, *Executor) (interface{}, error) {
+		return "custom", nil
+	})
+	jp, err := Compile("length(`[1]`)", WithOptimization(), WithFunctionRegistry(registry))
+	assert.Nil(err)
+	assert.Equal("length(`[1]`)", Format(jp.AST()))
+	result, err := jp.Search(nil)
+	assert.Nil(err)
+	assert.Equal("custom", result)
+}
+
+func TestOptimizationKeepsErrors(t *testing.T) {
+	data := map[string]interface{}{"a": []interface{}{1.0}, "c": "x"}
+	for _, expression := range []string{
+		"not_null(`1`, abs('x'))",
+		"not_null(a[5], `2`, abs(c))",
+	} {
+		_, expectedErr := Search(expression, data)
+		assert.NotNil(t, expectedErr, expression)
+		for _, opts := range [][]Option{{WithOptimization()}, {WithOptimization(), WithBytecode()}} {
+			_, err := Search(expression, data, opts...)
+			assert.Equal(t, expectedErr, err, expression)
+		}
+	}
+}
+
+func TestOptimizationNeverCallsCustomFunctions(t *testing.T) {
+	assert := assert.New(t)
+	registry := NewFunctionRegistry()
+	calls := 0
+	registry.Register("tick", func([]Value, *Executor) (interface{}, error) {
+		calls++
+		return float64(calls), nil
+	})
+	for _, tt := range []struct {
+		expression string
+		first      interface{}
+	}{
+		{"`1` | tick(@)", 1.0},
+		{"`[1,2]`[*].tick(@)", []interface{}{1.0, 2.0}},
+		{"`[1,2]` | map(&tick(@), @)", []interface{}{1.0, 2.0}},
+	} {
+		calls = 0
+		jp, err := Compile(tt.expression, WithOptimization(), WithFunctionRegistry(registry))
+		if !assert.Nil(err, tt.expression) {
+			continue
+		}
+		assert.Equal(0, calls, "%s: tick() called by Compile", tt.expression)
+		result, err := jp.Search(nil)
+		assert.Nil(err, tt.expression)
+		assert.Equal(tt.first, result, tt.expression)
+		result, err = jp.Search(nil)
+		assert.Nil(err, tt.expression)
+		assert.NotEqual(tt.first, result, tt.expression)
+	}
+}
+
+func TestPureBuiltins(t *testing.T) {
+	for name := range builtinFunctions() {
+		assert.Equal(t, name != "shuffle", pureBuiltins[name], name)
+	}
+	for name := range pureBuiltins {
+		_, ok := builtinFunctions()[name]
+		assert.True(t, ok, name)
+	}
+}
+
+func TestOptimizationIgnoresLaterOverrides(t *testing.T) {
+	assert := assert.New(t)
+	registry := NewFunctionRegistry()
+	jp := MustCompile("length('abc')", WithOptimization(), WithFunctionRegistry(registry))
+	registry.Register("length", constantFunction("custom"))
+	result, err := jp.Search(nil)
+	assert.Nil(err)
+	assert.Equal(3.0, result)
+}
+
+func TestOptimizationInvalidFunctionName(t *testing.T) {
+	_, expectedErr := Search("(`0`)()", nil)
+	assert.NotNil(t, expectedErr)
+	_, err := Search("(`0`)()", nil, WithOptimization())
+	assert.Equal(t, expectedErr, err)
+}
+
+// TestOptimizationCompliance checks that optimized expressions give the
+// same results and errors as the unoptimized tree interpreter across the
+// compliance suite.
+func TestOptimizationCompliance(t *testing.T) {
+	for _, filename := range whiteListed {
+		var testSuites []TestSuite
+		data, err := ioutil.ReadFile(filename)
+		if !assert.Nil(t, err) {
+			continue
+		}
+		assert.Nil(t, json.Unmarshal(data, &testSuites))
+		for _, testsuite := range testSuites {
+			for _, testcase := range testsuite.TestCases {
+				expected, expectedErr := Search(testcase.Expression, testsuite.Given)
+				for _, opts := range [][]Option{{WithOptimization()}, {WithOptimization(), WithBytecode()}} {
+					actual, err := Search(testcase.Expression, testsuite.Given, opts...)
+					if expectedErr != nil {
+						assert.NotNil(t, err, testcase.Expression)
+						continue
+					}
+					if assert.Nil(t, err, testcase.Expression) {
+						assert.Equal(t, expected, actual, testcase.Expression)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package jmespath
+
+// Option configures how Compile and Search parse and evaluate an
+// expression.
+type Option func(*config)
+
+type config struct {
+	registry          *FunctionRegistry
+	validateFunctions bool
+	limits            Limits
+	bytecode          bool
+	exactIntegers     bool
+	typeCheck         bool
+	schema            []byte // JSON Schema of the input, for typeCheck
+	optimize          bool
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{registry: defaultRegistry}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// parse parses expression and runs the static checks enabled in c.
+func (c *config) parse(expression string) (ASTNode, error) {
+	parser := NewParser()
+	parser.maxDepth = c.limits.MaxDepth
+	parser.exactIntegers = c.exactIntegers
+	ast, err := parser.Parse(expression)
+	if err != nil {
+		return ASTNode{}, err
+	}
+	if err := c.check(ast, expression); err != nil {
+		return ASTNode{}, err
+	}
+	return ast, nil
+}
+
+// check runs the static checks enabled in c on ast, which was parsed
+// from expression.
+func (c *config) check(ast ASTNode, expression string) error {
+	if c.limits.MaxDepth > 0 {
+		if err := checkDepth(ast, expression, c.limits.MaxDepth); err != nil {
+			return err
+		}
+	}
+	if c.validateFunctions {
+		if err := validateFunctions(ast, expression, c.registry); err != nil {
+			return err
+		}
+	}
+	if c.typeCheck {
+		input, err := schemaType(c.schema)
+		if err != nil {
+			return err
+		}
+		if _, err := inferType(ast, expression, input, c.registry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// build returns the JMESPath for ast, which was parsed from expression and
+// has passed the checks in c.
+func (c *config) build(expression string, ast ASTNode) *JMESPath {
+	intr := c.interpreter()
+	// Optimization can drop references that would never be evaluated, but
+	// the variables are still required.
+	variables := freeVariables(ast)
+	if c.optimize {
+		ast = (&optimizer{intr: intr, registry: c.registry}).optimize(ast)
+	}
+	jmespath := &JMESPath{expression: expression, ast: ast, intr: intr, variables: variables}
+	if c.bytecode {
+		jmespath.prog = compileProgram(ast)
+	}
+	return jmespath
+}
+
+// interpreter creates an interpreter that evaluates with the registry,
+// limits and number handling in c.
+func (c *config) interpreter() *treeInterpreter {
+	intr := newInterpreterWithRegistry(c.registry)
+	intr.limits = c.limits
+	intr.exactIntegers = c.exactIntegers
+	return intr
+}
+
+// WithFunctionRegistry makes the expression resolve function calls
+// against registry instead of the default registry that RegisterFunction
+// adds to.
+func WithFunctionRegistry(registry *FunctionRegistry) Option {
+	return func(c *config) {
+		if registry != nil {
+			c.registry = registry
+		}
+	}
+}
@@ -7,42 +7,107 @@ import (
 	"strings"
 )
 
-type astNodeType int
+// NodeType identifies the kind of an ASTNode.  The comment on each type
+// gives the node's Value and the meaning of its Children; nodes with no
+// value listed have a nil Value, and nodes with no children listed have
+// none.
+type NodeType int
 
-//go:generate stringer -type astNodeType
+//go:generate stringer -type NodeType
 const (
-	ASTEmpty astNodeType = iota
+	// ASTEmpty is the zero ASTNode.  No expression parses to it, and it
+	// cannot be evaluated.
+	ASTEmpty NodeType = iota
+	// ASTComparator compares its two children.  Its value is the
+	// operator: "==", "!=", "<", "<=", ">" or ">=".
 	ASTComparator
+	// ASTCurrentNode is "@", the current value.
 	ASTCurrentNode
+	// ASTExpRef is "&child", an expression reference.
 	ASTExpRef
+	// ASTFunctionExpression calls the function named by its string value
+	// with its children as arguments.
 	ASTFunctionExpression
+	// ASTField looks up the member named by its string value.
 	ASTField
+	// ASTFilterProjection evaluates its first child, then projects its
+	// second child over the elements of the result for which its third
+	// child, the condition, is true.
 	ASTFilterProjection
+	// ASTFlatten flattens the array its child evaluates to.
 	ASTFlatten
+	// ASTIdentity evaluates to the current value, as the implicit left
+	// side of projections.
 	ASTIdentity
+	// ASTIndex indexes the current value by its int value.
 	ASTIndex
+	// ASTIndexExpression evaluates its second child, an ASTIndex or
+	// ASTSlice, against the result of its first.
 	ASTIndexExpression
+	// ASTKeyValPair is a member of an ASTMultiSelectHash, named by its
+	// string value, whose value is its child.
 	ASTKeyValPair
+	// ASTLiteral is a constant: its value is the decoded JSON value, or the
+	// string of a raw string literal.
 	ASTLiteral
+	// ASTMultiSelectHash builds an object from its children, which are
+	// ASTKeyValPair and ASTKeyValExprPair nodes.
 	ASTMultiSelectHash
+	// ASTMultiSelectList builds an array of the results of its children.
 	ASTMultiSelectList
+	// ASTOrExpression is "left || right".
 	ASTOrExpression
+	// ASTAndExpression is "left && right".
 	ASTAndExpression
+	// ASTNotExpression is "!child".
 	ASTNotExpression
+	// ASTPipe evaluates each of its two or more children against the
+	// result of the one before.
 	ASTPipe
+	// ASTProjection evaluates its first child, then projects its second
+	// child over the elements of the resulting array.
 	ASTProjection
+	// ASTSubexpression evaluates each of its two or more children against
+	// the result of the one before, like ASTPipe, but is written with dots.
 	ASTSubexpression
+	// ASTSlice slices the current value.  Its value is a []*int of the
+	// start, stop and step, each nil if omitted.
 	ASTSlice
+	// ASTValueProjection evaluates its first child, then projects its
+	// second child over the values of the resulting object.
 	ASTValueProjection
+	// ASTRootNode is "$", the value the search started from.
 	ASTRootNode
+	// ASTKeyValExprPair is a member of an ASTMultiSelectHash whose name is
+	// computed by its value, an ASTExpRef node, and whose value is its
+	// child.
 	ASTKeyValExprPair
+	// ASTLetExpression is "let $a = x, $b = y in body".  Its children are
+	// one or more ASTVariableBinding nodes followed by the body, which is
+	// evaluated with the variables in scope.
+	ASTLetExpression
+	// ASTVariableBinding binds the variable named by its string value, in
+	// an ASTLetExpression, to the result of its child.
+	ASTVariableBinding
+	// ASTVariable is "$name", a reference to the variable named by its
+	// string value.
+	ASTVariable
+	// ASTArithmetic applies an arithmetic operator to its two children.
+	// Its value is the operator: "+", "-", "*", "/", "%" or "//".
+	ASTArithmetic
+	// ASTNegate is "-child", the negation of a number.
+	ASTNegate
+	// ASTConditional is "condition ? then : else".  Only the branch that
+	// the truthiness of the condition selects is evaluated.
+	ASTConditional
 )
 
 // ASTNode represents the abstract syntax tree of a JMESPath expression.
 type ASTNode struct {
-	nodeType astNodeType
+	nodeType NodeType
 	value    interface{}
 	children []ASTNode
+	position int // Offset of the node in the expression, where known.
 }
 
 func (node ASTNode) String() string {
@@ -50,10 +115,9 @@ func (node ASTNode) String() string {
 }
 
 // PrettyPrint will pretty print the parsed AST.
-// The AST is an implementation detail and this pretty print
-// function is provided as a convenience method to help with
-// debugging.  You should not rely on its output as the internal
-// structure of the AST may change at any time.
+// This pretty print function is provided as a convenience method to
+// help with debugging.  You should not rely on its output format; use
+// the accessors in ast.go, or Walk, to inspect an AST.
 func (node ASTNode) PrettyPrint(indent int) string {
 	spaces := strings.Repeat(" ", indent)
 	output := fmt.Sprintf("%s%s {\n", spaces, node.nodeType)
@@ -90,11 +154,14 @@ var bindingPowers = map[tokType]int{
 	tNumber:             0,
 	tCurrent:            0,
 	tRoot:               0,
+	tVariable:           0,
+	tAssign:             0,
 	tExpref:             0,
 	tColon:              0,
 	tPipe:               1,
-	tOr:                 2,
-	tAnd:                3,
+	tQuestion:           2,
+	tOr:                 3,
+	tAnd:                4,
 	tEQ:                 5,
 	tLT:                 5,
 	tLTE:                5,
@@ -102,7 +169,12 @@ var bindingPowers = map[tokType]int{
 	tGTE:                5,
 	tNE:                 5,
 	tFlatten:            9,
+	tPlus:               16,
+	tMinus:              16,
 	tStar:               20,
+	tDivide:             20,
+	tModulo:             20,
+	tIntDivide:          20,
 	tFilter:             21,
 	tDot:                40,
 	tNot:                45,
@@ -116,6 +188,10 @@ type Parser struct {
 	expression string
 	tokens     []token
 	index      int
+	maxDepth   int // Maximum nesting of parseExpression calls, if positive
+	depth      int
+
+	exactIntegers bool // Decode integers in JSON literals as int64
 }
 
 // NewParser creates a new JMESPath parser.
@@ -129,6 +205,7 @@ func (p *Parser) Parse(expression string) (ASTNode, error) {
 	lexer := NewLexer()
 	p.expression = expression
 	p.index = 0
+	p.depth = 0
 	tokens, err := lexer.tokenize(expression)
 	if err != nil {
 		return ASTNode{}, err
@@ -146,6 +223,20 @@ func (p *Parser) Parse(expression string) (ASTNode, error) {
 }
 
 func (p *Parser) parseExpression(bindingPower int) (ASTNode, error) {
+	if p.maxDepth > 0 {
+		// Guard the parser's own recursion; checkDepth covers the
+		// nesting that left-associative operators build up iteratively.
+		p.depth++
+		defer func() { p.depth-- }()
+		if p.depth > p.maxDepth {
+			return ASTNode{}, LimitExceededError{
+				Limit:      "MaxDepth",
+				Max:        p.maxDepth,
+				Expression: p.expression,
+				Offset:     p.lookaheadToken(0).position,
+			}
+		}
+	}
 	var err error
 	leftToken := p.lookaheadToken(0)
 	p.advance()
@@ -155,8 +246,9 @@ func (p *Parser) parseExpression(bindingPower int) (ASTNode, error) {
 	}
 	currentToken := p.current()
 	for bindingPower < bindingPowers[currentToken] {
+		opToken := p.lookaheadToken(0)
 		p.advance()
-		leftNode, err = p.led(currentToken, leftNode)
+		leftNode, err = p.led(opToken, leftNode)
 		if err != nil {
 			return ASTNode{}, err
 		}
@@ -165,16 +257,39 @@ func (p *Parser) parseExpression(bindingPower int) (ASTNode, error) {
 	return leftNode, nil
 }
 
+// parseConditional parses the branches of "condition ? then : else".
+// The else branch is parsed with a lower binding power than "?" so that
+// a chain of conditionals nests to the right.
+func (p *Parser) parseConditional(tok token, condition ASTNode) (ASTNode, error) {
+	then, err := p.parseExpression(0)
+	if err != nil {
+		return ASTNode{}, err
+	}
+	if err := p.match(tColon); err != nil {
+		return ASTNode{}, err
+	}
+	otherwise, err := p.parseExpression(bindingPowers[tQuestion] - 1)
+	if err != nil {
+		return ASTNode{}, err
+	}
+	return ASTNode{
+		nodeType: ASTConditional,
+		position: tok.position,
+		children: []ASTNode{condition, then, otherwise},
+	}, nil
+}
+
 func (p *Parser) parseIndexExpression() (ASTNode, error) {
 	if p.lookahead(0) == tColon || p.lookahead(1) == tColon {
 		return p.parseSliceExpression()
 	}
+	start := p.lookaheadToken(0).position
 	indexStr := p.lookaheadToken(0).value
 	parsedInt, err := strconv.Atoi(indexStr)
 	if err != nil {
 		return ASTNode{}, err
 	}
-	indexNode := ASTNode{nodeType: ASTIndex, value: parsedInt}
+	indexNode := ASTNode{nodeType: ASTIndex, position: start, value: parsedInt}
 	p.advance()
 	if err := p.match(tRbracket); err != nil {
 		return ASTNode{}, err
@@ -183,6 +298,7 @@ func (p *Parser) parseIndexExpression() (ASTNode, error) {
 }
 
 func (p *Parser) parseSliceExpression() (ASTNode, error) {
+	start := p.lookaheadToken(0).position
 	parts := []*int{nil, nil, nil}
 	index := 0
 	current := p.current()
@@ -208,6 +324,7 @@ func (p *Parser) parseSliceExpression() (ASTNode, error) {
 	}
 	return ASTNode{
 		nodeType: ASTSlice,
+		position: start,
 		value:    parts,
 	}, nil
 }
@@ -220,13 +337,15 @@ func (p *Parser) match(tokenType tokType) error {
 	return p.syntaxError("Expected " + tokenType.String() + ", received: " + p.current().String())
 }
 
-func (p *Parser) led(tokenType tokType, node ASTNode) (ASTNode, error) {
+func (p *Parser) led(tok token, node ASTNode) (ASTNode, error) {
+	tokenType := tok.tokenType
 	switch tokenType {
 	case tDot:
 		if p.current() != tStar {
 			right, err := p.parseDotRHS(bindingPowers[tDot])
 			return ASTNode{
 				nodeType: ASTSubexpression,
+				position: tok.position,
 				children: []ASTNode{node, right},
 			}, err
 		}
@@ -234,17 +353,20 @@ func (p *Parser) led(tokenType tokType, node ASTNode) (ASTNode, error) {
 		right, err := p.parseProjectionRHS(bindingPowers[tDot])
 		return ASTNode{
 			nodeType: ASTValueProjection,
+			position: tok.position,
 			children: []ASTNode{node, right},
 		}, err
 	case tPipe:
 		right, err := p.parseExpression(bindingPowers[tPipe])
-		return ASTNode{nodeType: ASTPipe, children: []ASTNode{node, right}}, err
+		return ASTNode{nodeType: ASTPipe, position: tok.position, children: []ASTNode{node, right}}, err
 	case tOr:
 		right, err := p.parseExpression(bindingPowers[tOr])
-		return ASTNode{nodeType: ASTOrExpression, children: []ASTNode{node, right}}, err
+		return ASTNode{nodeType: ASTOrExpression, position: tok.position, children: []ASTNode{node, right}}, err
 	case tAnd:
 		right, err := p.parseExpression(bindingPowers[tAnd])
-		return ASTNode{nodeType: ASTAndExpression, children: []ASTNode{node, right}}, err
+		return ASTNode{nodeType: ASTAndExpression, position: tok.position, children: []ASTNode{node, right}}, err
+	case tQuestion:
+		return p.parseConditional(tok, node)
 	case tLparen:
 		name := node.value
 		var args []ASTNode
@@ -267,16 +389,29 @@ func (p *Parser) led(tokenType tokType, node ASTNode) (ASTNode, error) {
 			nodeType: ASTFunctionExpression,
 			value:    name,
 			children: args,
+			position: node.position,
 		}, nil
 	case tFilter:
 		return p.parseFilter(node)
 	case tFlatten:
-		left := ASTNode{nodeType: ASTFlatten, children: []ASTNode{node}}
+		left := ASTNode{nodeType: ASTFlatten, position: tok.position, children: []ASTNode{node}}
 		right, err := p.parseProjectionRHS(bindingPowers[tFlatten])
 		return ASTNode{
 			nodeType: ASTProjection,
+			position: tok.position,
 			children: []ASTNode{left, right},
 		}, err
+	case tPlus, tMinus, tStar, tDivide, tModulo, tIntDivide:
+		right, err := p.parseExpression(bindingPowers[tokenType])
+		if err != nil {
+			return ASTNode{}, err
+		}
+		return ASTNode{
+			nodeType: ASTArithmetic,
+			position: tok.position,
+			value:    tokenType,
+			children: []ASTNode{node, right},
+		}, nil
 	case tEQ, tNE, tGT, tGTE, tLT, tLTE:
 		right, err := p.parseExpression(bindingPowers[tokenType])
 		if err != nil {
@@ -284,6 +419,7 @@ func (p *Parser) led(tokenType tokType, node ASTNode) (ASTNode, error) {
 		}
 		return ASTNode{
 			nodeType: ASTComparator,
+			position: tok.position,
 			value:    tokenType,
 			children: []ASTNode{node, right},
 		}, nil
@@ -311,6 +447,7 @@ func (p *Parser) led(tokenType tokType, node ASTNode) (ASTNode, error) {
 		}
 		return ASTNode{
 			nodeType: ASTProjection,
+			position: tok.position,
 			children: []ASTNode{node, right},
 		}, nil
 	}
@@ -325,44 +462,57 @@ func (p *Parser) nud(token token) (ASTNode, error) {
 		if err != nil {
 			return ASTNode{}, err
 		}
-		return ASTNode{nodeType: ASTLiteral, value: parsed}, nil
+		if p.exactIntegers {
+			decoder := json.NewDecoder(strings.NewReader(token.value))
+			decoder.UseNumber()
+			if err := decoder.Decode(&parsed); err != nil {
+				return ASTNode{}, err
+			}
+			parsed = exactLiteral(parsed)
+		}
+		return ASTNode{nodeType: ASTLiteral, position: token.position, value: parsed}, nil
 	case tStringLiteral:
-		return ASTNode{nodeType: ASTLiteral, value: token.value}, nil
+		return ASTNode{nodeType: ASTLiteral, position: token.position, value: token.value}, nil
 	case tUnquotedIdentifier:
+		if token.value == "let" && p.current() == tVariable {
+			return p.parseLetExpression(token)
+		}
 		return ASTNode{
 			nodeType: ASTField,
 			value:    token.value,
+			position: token.position,
 		}, nil
 	case tQuotedIdentifier:
-		node := ASTNode{nodeType: ASTField, value: token.value}
+		node := ASTNode{nodeType: ASTField, position: token.position, value: token.value}
 		if p.current() == tLparen {
 			return ASTNode{}, p.syntaxErrorToken("Can't have quoted identifier as function name.", token)
 		}
 		return node, nil
 	case tStar:
-		left := ASTNode{nodeType: ASTIdentity}
+		left := ASTNode{nodeType: ASTIdentity, position: token.position}
 		var right ASTNode
 		var err error
 		if p.current() == tRbracket {
-			right = ASTNode{nodeType: ASTIdentity}
+			right = ASTNode{nodeType: ASTIdentity, position: token.position}
 		} else {
 			right, err = p.parseProjectionRHS(bindingPowers[tStar])
 		}
-		return ASTNode{nodeType: ASTValueProjection, children: []ASTNode{left, right}}, err
+		return ASTNode{nodeType: ASTValueProjection, position: token.position, children: []ASTNode{left, right}}, err
 	case tFilter:
-		return p.parseFilter(ASTNode{nodeType: ASTIdentity})
+		return p.parseFilter(ASTNode{nodeType: ASTIdentity, position: token.position})
 	case tLbrace:
 		return p.parseMultiSelectHash()
 	case tFlatten:
 		left := ASTNode{
 			nodeType: ASTFlatten,
-			children: []ASTNode{{nodeType: ASTIdentity}},
+			position: token.position,
+			children: []ASTNode{{nodeType: ASTIdentity, position: token.position}},
 		}
 		right, err := p.parseProjectionRHS(bindingPowers[tFlatten])
 		if err != nil {
 			return ASTNode{}, err
 		}
-		return ASTNode{nodeType: ASTProjection, children: []ASTNode{left, right}}, nil
+		return ASTNode{nodeType: ASTProjection, position: token.position, children: []ASTNode{left, right}}, nil
 	case tLbracket:
 		tokenType := p.current()
 		//var right ASTNode
@@ -371,7 +521,7 @@ func (p *Parser) nud(token token) (ASTNode, error) {
 			if err != nil {
 				return ASTNode{}, nil
 			}
-			return p.projectIfSlice(ASTNode{nodeType: ASTIdentity}, right)
+			return p.projectIfSlice(ASTNode{nodeType: ASTIdentity, position: token.position}, right)
 		} else if tokenType == tStar && p.lookahead(1) == tRbracket {
 			p.advance()
 			p.advance()
@@ -381,27 +531,38 @@ func (p *Parser) nud(token token) (ASTNode, error) {
 			}
 			return ASTNode{
 				nodeType: ASTProjection,
-				children: []ASTNode{{nodeType: ASTIdentity}, right},
+				position: token.position,
+				children: []ASTNode{{nodeType: ASTIdentity, position: token.position}, right},
 			}, nil
 		} else {
 			return p.parseMultiSelectList()
 		}
 	case tCurrent:
-		return ASTNode{nodeType: ASTCurrentNode}, nil
+		return ASTNode{nodeType: ASTCurrentNode, position: token.position}, nil
 	case tRoot:
-		return ASTNode{nodeType: ASTRootNode}, nil
+		return ASTNode{nodeType: ASTRootNode, position: token.position}, nil
+	case tVariable:
+		return ASTNode{nodeType: ASTVariable, position: token.position, value: token.value}, nil
 	case tExpref:
 		expression, err := p.parseExpression(bindingPowers[tExpref])
 		if err != nil {
 			return ASTNode{}, err
 		}
-		return ASTNode{nodeType: ASTExpRef, children: []ASTNode{expression}}, nil
+		return ASTNode{nodeType: ASTExpRef, position: token.position, children: []ASTNode{expression}}, nil
 	case tNot:
 		expression, err := p.parseExpression(bindingPowers[tNot])
 		if err != nil {
 			return ASTNode{}, err
 		}
-		return ASTNode{nodeType: ASTNotExpression, children: []ASTNode{expression}}, nil
+		return ASTNode{nodeType: ASTNotExpression, position: token.position, children: []ASTNode{expression}}, nil
+	case tMinus:
+		// A minus sign applies to what follows it up to the next
+		// arithmetic operator, so "-a.b * c" is "(-(a.b)) * c".
+		expression, err := p.parseExpression(bindingPowers[tStar])
+		if err != nil {
+			return ASTNode{}, err
+		}
+		return ASTNode{nodeType: ASTNegate, position: token.position, children: []ASTNode{expression}}, nil
 	case tLparen:
 		expression, err := p.parseExpression(0)
 		if err != nil {
@@ -419,6 +580,8 @@ func (p *Parser) nud(token token) (ASTNode, error) {
 }
 
 func (p *Parser) parseMultiSelectList() (ASTNode, error) {
+	// The opening token has already been consumed.
+	start := p.lookaheadToken(-1).position
 	var expressions []ASTNode
 	for {
 		expression, err := p.parseExpression(0)
@@ -440,11 +603,14 @@ func (p *Parser) parseMultiSelectList() (ASTNode, error) {
 	}
 	return ASTNode{
 		nodeType: ASTMultiSelectList,
+		position: start,
 		children: expressions,
 	}, nil
 }
 
 func (p *Parser) parseMultiSelectHash() (ASTNode, error) {
+	// The opening token has already been consumed.
+	start := p.lookaheadToken(-1).position
 	var children []ASTNode
 	for {
 		keyToken := p.lookaheadToken(0)
@@ -477,7 +643,8 @@ func (p *Parser) parseMultiSelectHash() (ASTNode, error) {
 		}
 		node := ASTNode{
 			nodeType: ASTKeyValPair,
-			value: keyName,
+			position: keyToken.position,
+			value:    keyName,
 			children: []ASTNode{value},
 		}
 		if has_expressions {
@@ -500,25 +667,73 @@ func (p *Parser) parseMultiSelectHash() (ASTNode, error) {
 	}
 	return ASTNode{
 		nodeType: ASTMultiSelectHash,
+		position: start,
 		children: children,
 	}, nil
 }
 
+// parseLetExpression parses the bindings and body of a let expression.
+// "let" is an ordinary identifier unless a variable follows it, and so is
+// the "in" that ends the bindings.
+func (p *Parser) parseLetExpression(letToken token) (ASTNode, error) {
+	var children []ASTNode
+	for {
+		variable := p.lookaheadToken(0)
+		if err := p.match(tVariable); err != nil {
+			return ASTNode{}, err
+		}
+		if err := p.match(tAssign); err != nil {
+			return ASTNode{}, err
+		}
+		value, err := p.parseExpression(0)
+		if err != nil {
+			return ASTNode{}, err
+		}
+		children = append(children, ASTNode{
+			nodeType: ASTVariableBinding,
+			position: variable.position,
+			value:    variable.value,
+			children: []ASTNode{value},
+		})
+		if p.current() != tComma {
+			break
+		}
+		p.advance()
+	}
+	if in := p.lookaheadToken(0); in.tokenType != tUnquotedIdentifier || in.value != "in" {
+		return ASTNode{}, p.syntaxError("Expected \"in\", received: " + p.current().String())
+	}
+	p.advance()
+	body, err := p.parseExpression(0)
+	if err != nil {
+		return ASTNode{}, err
+	}
+	return ASTNode{
+		nodeType: ASTLetExpression,
+		position: letToken.position,
+		children: append(children, body),
+	}, nil
+}
+
 func (p *Parser) projectIfSlice(left ASTNode, right ASTNode) (ASTNode, error) {
 	indexExpr := ASTNode{
 		nodeType: ASTIndexExpression,
+		position: right.position,
 		children: []ASTNode{left, right},
 	}
 	if right.nodeType == ASTSlice {
 		right, err := p.parseProjectionRHS(bindingPowers[tStar])
 		return ASTNode{
 			nodeType: ASTProjection,
+			position: right.position,
 			children: []ASTNode{indexExpr, right},
 		}, err
 	}
 	return indexExpr, nil
 }
 func (p *Parser) parseFilter(node ASTNode) (ASTNode, error) {
+	// The opening token has already been consumed.
+	start := p.lookaheadToken(-1).position
 	var right, condition ASTNode
 	var err error
 	condition, err = p.parseExpression(0)
@@ -529,7 +744,7 @@ func (p *Parser) parseFilter(node ASTNode) (ASTNode, error) {
 		return ASTNode{}, err
 	}
 	if p.current() == tFlatten {
-		right = ASTNode{nodeType: ASTIdentity}
+		right = ASTNode{nodeType: ASTIdentity, position: start}
 	} else {
 		right, err = p.parseProjectionRHS(bindingPowers[tFilter])
 		if err != nil {
@@ -539,6 +754,7 @@ func (p *Parser) parseFilter(node ASTNode) (ASTNode, error) {
 
 	return ASTNode{
 		nodeType: ASTFilterProjection,
+		position: start,
 		children: []ASTNode{node, right, condition},
 	}, nil
 }
@@ -563,8 +779,15 @@ func (p *Parser) parseDotRHS(bindingPower int) (ASTNode, error) {
 
 func (p *Parser) parseProjectionRHS(bindingPower int) (ASTNode, error) {
 	current := p.current()
-	if bindingPowers[current] < 10 {
-		return ASTNode{nodeType: ASTIdentity}, nil
+	// An arithmetic operator ends the projection, like the tokens that
+	// bind less than 10, and applies to its whole result.  A flatten binds
+	// less tightly than arithmetic, so its right side is parsed like that
+	// of a [*] projection.
+	if bindingPower < bindingPowers[tStar] {
+		bindingPower = bindingPowers[tStar]
+	}
+	if _, ok := arithmeticOperators[current]; ok || bindingPowers[current] < 10 {
+		return ASTNode{nodeType: ASTIdentity, position: p.lookaheadToken(0).position}, nil
 	} else if current == tLbracket {
 		return p.parseExpression(bindingPower)
 	} else if current == tFilter {
@@ -24,6 +24,16 @@ var parsingErrorTests = []struct {
 	{`foo@`, "Invalid"},
 	{`&&&&&&&&&&&&t(`, "Invalid"},
 	{`[*][`, "Invalid"},
+	{`let $a = b`, "Incomplete expression"},
+	{`let $a in b`, "Invalid"},
+	{`let $a = b, in $a`, "Invalid"},
+	{`$ a`, "Invalid"},
+	{`a *`, "Incomplete expression"},
+	{`a + / b`, "Invalid"},
+	{`a - 1`, "Invalid"},
+	{`a ? b`, "Incomplete expression"},
+	{`a ? b, c`, "Invalid"},
+	{`a : b`, "Invalid"},
 }
 
 func TestParsingErrors(t *testing.T) {
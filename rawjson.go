@@ -0,0 +1,283 @@
+package jmespath
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strconv"
+)
+
+// SearchJSON evaluates the expression against the JSON document in data
+// and returns the same result as Search would for the value json.Unmarshal
+// decodes from data.  Only the parts of the document the expression visits
+// are decoded: the rest is skipped over, so picking a field out of a large
+// document costs little more than validating it.
+func (jp *JMESPath) SearchJSON(data []byte) (interface{}, error) {
+	if !json.Valid(data) {
+		// Report the error json.Unmarshal would.
+		var v interface{}
+		return nil, json.Unmarshal(data, &v)
+	}
+	result, err := jp.Search(newJSONNode(data))
+	if err != nil {
+		return nil, err
+	}
+	result, _ = decodedJSON(result)
+	return result, nil
+}
+
+// SearchReader is like SearchJSON but reads the document from r.
+func (jp *JMESPath) SearchReader(r io.Reader) (interface{}, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return jp.SearchJSON(data)
+}
+
+// jsonNode is an Accessor for a JSON value that has not been decoded.  The
+// first time an array or object is searched its members are located, with
+// nested values skipped rather than decoded, and each member becomes a
+// jsonNode in turn.  Scalars are decoded when the interpreter reaches them.
+// A jsonNode is used by one search at a time, so it is not safe for
+// concurrent use.
+type jsonNode struct {
+	data []byte // Valid JSON with no surrounding whitespace
+
+	scanned bool
+	members []*jsonNode    // Elements of an array or values of an object
+	keys    []string       // Distinct member names of an object, in order
+	fields  map[string]int // Index into members of each name; the last duplicate wins
+}
+
+func newJSONNode(data []byte) *jsonNode {
+	return &jsonNode{data: bytes.TrimSpace(data)}
+}
+
+func (n *jsonNode) Kind() Kind {
+	switch n.data[0] {
+	case '{':
+		return ObjectKind
+	case '[':
+		return ArrayKind
+	case '"':
+		return StringKind
+	case 't', 'f':
+		return BooleanKind
+	case 'n':
+		return NullKind
+	}
+	return NumberKind
+}
+
+func (n *jsonNode) Len() int {
+	n.scan()
+	if n.fields != nil {
+		return len(n.keys)
+	}
+	return len(n.members)
+}
+
+func (n *jsonNode) Field(key string) (interface{}, bool) {
+	n.scan()
+	i, ok := n.fields[key]
+	if !ok {
+		return nil, false
+	}
+	return n.members[i], true
+}
+
+func (n *jsonNode) Index(i int) interface{} {
+	n.scan()
+	return n.members[i]
+}
+
+func (n *jsonNode) Keys() []string {
+	n.scan()
+	return n.keys
+}
+
+func (n *jsonNode) Scalar() interface{} {
+	switch n.data[0] {
+	case 'n':
+		return nil
+	case 't':
+		return true
+	case 'f':
+		return false
+	case '"':
+		return jsonString(n.data)
+	}
+	f, _ := strconv.ParseFloat(string(n.data), 64)
+	return f
+}
+
+// scan locates the members of an array or object.
+func (n *jsonNode) scan() {
+	if n.scanned {
+		return
+	}
+	n.scanned = true
+	data := n.data
+	object := data[0] == '{'
+	if object {
+		n.fields = map[string]int{}
+	} else if data[0] != '[' {
+		return
+	}
+	i := skipSpace(data, 1)
+	if data[i] == '}' || data[i] == ']' {
+		return
+	}
+	for {
+		if object {
+			end := skipString(data, i)
+			key := jsonString(data[i:end])
+			if _, ok := n.fields[key]; !ok {
+				n.keys = append(n.keys, key)
+			}
+			n.fields[key] = len(n.members)
+			// Skip the colon.
+			i = skipSpace(data, skipSpace(data, end)+1)
+		}
+		end := skipValue(data, i)
+		n.members = append(n.members, &jsonNode{data: data[i:end]})
+		i = skipSpace(data, end)
+		if data[i] != ',' {
+			return
+		}
+		i = skipSpace(data, i+1)
+	}
+}
+
+// jsonString decodes the quoted string s.
+func jsonString(s []byte) string {
+	if bytes.IndexByte(s, '\\') < 0 {
+		return string(s[1 : len(s)-1])
+	}
+	var decoded string
+	json.Unmarshal(s, &decoded)
+	return decoded
+}
+
+// skipSpace returns the index of the first byte at or after i in data that
+// is not whitespace.
+func skipSpace(data []byte, i int) int {
+	for i < len(data) {
+		switch data[i] {
+		case ' ', '\t', '\n', '\r':
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// skipString returns the index just past the string starting at data[i].
+func skipString(data []byte, i int) int {
+	for i++; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return i
+}
+
+// skipValue returns the index just past the value starting at data[i].
+// data must be valid JSON.
+func skipValue(data []byte, i int) int {
+	switch data[i] {
+	case '"':
+		return skipString(data, i)
+	case '{', '[':
+		depth := 0
+		for i < len(data) {
+			switch data[i] {
+			case '"':
+				i = skipString(data, i)
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return i + 1
+				}
+			}
+			i++
+		}
+		return i
+	}
+	for i < len(data) {
+		switch data[i] {
+		case ',', '}', ']', ' ', '\t', '\n', '\r':
+			return i
+		}
+		i++
+	}
+	return i
+}
+
+// decodedJSON replaces the jsonNodes in a result by the values
+// json.Unmarshal decodes from them, and reports whether there were any.
+// Arrays and objects are copied only if they hold jsonNodes, as any others
+// may be literals from the expression.
+func decodedJSON(v interface{}) (interface{}, bool) {
+	switch v := v.(type) {
+	case *jsonNode:
+		var decoded interface{}
+		json.Unmarshal(v.data, &decoded)
+		return decoded, true
+	case []interface{}:
+		var items []interface{}
+		for i, item := range v {
+			if decoded, ok := decodedJSON(item); ok {
+				if items == nil {
+					items = make([]interface{}, len(v))
+					copy(items, v)
+				}
+				items[i] = decoded
+			}
+		}
+		if items != nil {
+			return items, true
+		}
+	case [][]interface{}:
+		// The pairs built by items() and zip().
+		var rows [][]interface{}
+		for i, row := range v {
+			if decoded, ok := decodedJSON(row); ok {
+				if rows == nil {
+					rows = make([][]interface{}, len(v))
+					copy(rows, v)
+				}
+				rows[i] = decoded.([]interface{})
+			}
+		}
+		if rows != nil {
+			return rows, true
+		}
+	case map[string]interface{}:
+		var object map[string]interface{}
+		for key, value := range v {
+			if decoded, ok := decodedJSON(value); ok {
+				if object == nil {
+					object = make(map[string]interface{}, len(v))
+					for key, value := range v {
+						object[key] = value
+					}
+				}
+				object[key] = decoded
+			}
+		}
+		if object != nil {
+			return object, true
+		}
+	}
+	return v, false
+}
@@ -0,0 +1,145 @@
+package jmespath
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const rawTestDocument = ` {
+	"store": {"name": "corner", "open": true, "owner": null},
+	"items": [
+		{"name": "pear", "price": 3, "tags": ["green"]},
+		{"name": "apple", "price": 1.5e0, "tags": []},
+		{"name": "plum", "price": 2, "tags": ["purple", "sour"]}
+	],
+	"esc\"aped": "qüote\n",
+	"dup": 1, "dup": 2,
+	"left": {"a": [1, {"b": "x"}]},
+	"right": { "a" : [ 1.0 , { "b" : "x" } ] },
+	"nested": [[1, 2], [3, [4, 5]], []]
+} `
+
+func TestSearchJSONMatchesDecodedSearch(t *testing.T) {
+	var decoded interface{}
+	assert.Nil(t, json.Unmarshal([]byte(rawTestDocument), &decoded))
+	expressions := []string{
+		"@",
+		"store",
+		"store.name",
+		"store.owner",
+		"store.missing",
+		"items[1]",
+		"items[-1].tags",
+		"items[*].name",
+		"items[?price > `1`].name",
+		"items[?tags].name",
+		"items[1:].price",
+		"items[*].tags[]",
+		"nested[]",
+		"nested[][]",
+		"sort_by(items, &price)[*].name",
+		"max_by(items, &price)",
+		"sum(items[*].price)",
+		"length(items)",
+		"length(store)",
+		"sort(keys(store))",
+		"sort(values(store)[?type(@) == 'string'])",
+		"sort_by(items(store), &[0])",
+		"items(store.owner || `{}`)",
+		"zip(items[*].name, items[*].price)",
+		"merge(store, items[0])",
+		"to_string(left)",
+		"\"esc\\\"aped\"",
+		"dup",
+		"left == right",
+		"left.a == right.a",
+		"contains(nested, `[1, 2]`)",
+		"{\"n\": items[0], \"t\": items[2].tags}",
+		"[store.name, items[0].tags]",
+		"store.open && items[1].tags",
+		"not_null(store.owner, items[2])",
+		"dedup(nested[][])",
+		"items[0] | reverse(tags)",
+	}
+	for _, expression := range expressions {
+		for _, opts := range [][]Option{nil, {WithBytecode()}} {
+			jp := MustCompile(expression, opts...)
+			expected, err := jp.Search(decoded)
+			assert.Nil(t, err, expression)
+			actual, err := jp.SearchJSON([]byte(rawTestDocument))
+			assert.Nil(t, err, expression)
+			assert.Equal(t, expected, actual, expression)
+		}
+	}
+}
+
+func TestSearchJSONDecodesOnlyWhatIsVisited(t *testing.T) {
+	assert := assert.New(t)
+	root := newJSONNode([]byte(`{"a": {"b": [1, 2]}, "c": {"d": [3]}}`))
+	result, err := MustCompile("a.b[0]").Search(root)
+	assert.Nil(err)
+	assert.Equal(1.0, result)
+	a, _ := root.Field("a")
+	c, _ := root.Field("c")
+	assert.True(a.(*jsonNode).scanned)
+	assert.False(c.(*jsonNode).scanned)
+}
+
+func TestSearchJSONInvalidDocument(t *testing.T) {
+	assert := assert.New(t)
+	jp := MustCompile("a")
+	for _, document := range []string{"", "  ", `{"a": 1`, `{"a": 1} {}`, `[1,]`} {
+		var v interface{}
+		expected := json.Unmarshal([]byte(document), &v)
+		_, err := jp.SearchJSON([]byte(document))
+		assert.Equal(expected, err, document)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("read failed")
+}
+
+func TestSearchReader(t *testing.T) {
+	assert := assert.New(t)
+	jp := MustCompile("items[?price < `2`].name")
+	result, err := jp.SearchReader(strings.NewReader(rawTestDocument))
+	assert.Nil(err)
+	assert.Equal([]interface{}{"apple"}, result)
+
+	_, err = jp.SearchReader(failingReader{})
+	assert.EqualError(err, "read failed")
+}
+
+func benchmarkDocument() []byte {
+	var items []string
+	for i := 0; i < 5000; i++ {
+		items = append(items, fmt.Sprintf(`{"id": %d, "name": "item %d", "tags": ["a", "b"], "meta": {"x": [1, 2, 3]}}`, i, i))
+	}
+	return []byte(`{"header": {"version": 3}, "items": [` + strings.Join(items, ",") + `]}`)
+}
+
+func BenchmarkSearchDecodedJSON(b *testing.B) {
+	data := benchmarkDocument()
+	jp := MustCompile("header.version")
+	for i := 0; i < b.N; i++ {
+		var decoded interface{}
+		json.Unmarshal(data, &decoded)
+		jp.Search(decoded)
+	}
+}
+
+func BenchmarkSearchJSON(b *testing.B) {
+	data := benchmarkDocument()
+	jp := MustCompile("header.version")
+	for i := 0; i < b.N; i++ {
+		jp.SearchJSON(data)
+	}
+}
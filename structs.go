@@ -0,0 +1,413 @@
+package jmespath
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// structField is a field of a struct type as encoding/json sees it.
+type structField struct {
+	name      string // Key the field is marshaled under
+	index     []int  // Index sequence for reaching the field, see fieldByIndex
+	tagged    bool   // Whether name comes from a json tag
+	omitEmpty bool   // Whether the tag has the omitempty option
+	quoted    bool   // Whether the tag has the string option
+}
+
+// structFields lists the fields encoding/json would marshal for a struct
+// type, in order, with indexes for looking them up by key.
+type structFields struct {
+	list         []structField
+	byName       map[string]int
+	byFoldedName map[string]int
+}
+
+// fieldCache maps a reflect.Type to its *structFields.
+var fieldCache sync.Map
+
+// cachedStructFields returns the fields of the struct type t, resolving
+// them on first use.
+func cachedStructFields(t reflect.Type) *structFields {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.(*structFields)
+	}
+	fields, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return fields.(*structFields)
+}
+
+// lookup finds the field for key.  Like encoding/json when it decodes, an
+// exact match is preferred over a case-insensitive one.
+func (s *structFields) lookup(key string) (structField, bool) {
+	if i, ok := s.byName[key]; ok {
+		return s.list[i], true
+	}
+	if i, ok := s.byFoldedName[foldName(key)]; ok {
+		return s.list[i], true
+	}
+	return structField{}, false
+}
+
+// foldName maps names that are equal under simple case folding, such as
+// "name", "Name" and "NAME", to the same key.
+func foldName(name string) string {
+	return strings.ToLower(strings.ToUpper(name))
+}
+
+// typeFields resolves the fields of the struct type t with the rules of
+// encoding/json: exported fields are included under their json tag name
+// or their Go name, fields tagged "-" are skipped, and the fields of
+// embedded structs are promoted unless the embedded field is tagged.  When
+// promotion produces several fields with one name, the shallowest wins,
+// then a tagged one; if that still leaves more than one, all are dropped.
+func typeFields(t reflect.Type) *structFields {
+	type queued struct {
+		typ   reflect.Type
+		index []int
+	}
+	var fields []structField
+	current := []queued{}
+	next := []queued{{typ: t}}
+	visited := map[reflect.Type]bool{}
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		// Count of each type at this depth, so that a struct embedded
+		// twice at the same depth annihilates its own fields.
+		count := map[reflect.Type]int{}
+		for _, q := range current {
+			count[q.typ]++
+		}
+		for _, q := range current {
+			if visited[q.typ] {
+				continue
+			}
+			visited[q.typ] = true
+			for i := 0; i < q.typ.NumField(); i++ {
+				sf := q.typ.Field(i)
+				if sf.Anonymous {
+					ft := sf.Type
+					if ft.Kind() == reflect.Ptr {
+						ft = ft.Elem()
+					}
+					if sf.PkgPath != "" && ft.Kind() != reflect.Struct {
+						// Embedded unexported non-struct types have no
+						// fields that could be promoted.
+						continue
+					}
+				} else if sf.PkgPath != "" {
+					continue
+				}
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, options := parseTag(tag)
+				if !isValidTag(name) {
+					name = ""
+				}
+				index := make([]int, len(q.index)+1)
+				copy(index, q.index)
+				index[len(q.index)] = i
+
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if name != "" || !sf.Anonymous || ft.Kind() != reflect.Struct {
+					field := structField{
+						name:      name,
+						index:     index,
+						tagged:    name != "",
+						omitEmpty: options.contains("omitempty"),
+						quoted:    options.contains("string"),
+					}
+					if field.name == "" {
+						field.name = sf.Name
+					}
+					fields = append(fields, field)
+					if count[q.typ] > 1 {
+						// Two copies at the same depth conflict; add a
+						// second entry so that dominance drops both.
+						fields = append(fields, fields[len(fields)-1])
+					}
+					continue
+				}
+				next = append(next, queued{typ: ft, index: index})
+			}
+		}
+	}
+
+	// Sort by name, then depth, then tagged first, to find dominant
+	// fields, and then restore the field order.
+	sort.SliceStable(fields, func(i, j int) bool {
+		x, y := fields[i], fields[j]
+		if x.name != y.name {
+			return x.name < y.name
+		}
+		if len(x.index) != len(y.index) {
+			return len(x.index) < len(y.index)
+		}
+		return x.tagged && !y.tagged
+	})
+	out := fields[:0]
+	for advance, i := 0, 0; i < len(fields); i += advance {
+		name := fields[i].name
+		for advance = 1; i+advance < len(fields); advance++ {
+			if fields[i+advance].name != name {
+				break
+			}
+		}
+		if advance == 1 {
+			out = append(out, fields[i])
+			continue
+		}
+		if dominant, ok := dominantField(fields[i : i+advance]); ok {
+			out = append(out, dominant)
+		}
+	}
+	fields = out
+	sort.Slice(fields, func(i, j int) bool {
+		return indexLess(fields[i].index, fields[j].index)
+	})
+
+	s := &structFields{
+		list:         fields,
+		byName:       make(map[string]int, len(fields)),
+		byFoldedName: make(map[string]int, len(fields)),
+	}
+	for i, field := range fields {
+		s.byName[field.name] = i
+		folded := foldName(field.name)
+		if _, ok := s.byFoldedName[folded]; !ok {
+			s.byFoldedName[folded] = i
+		}
+	}
+	return s
+}
+
+// dominantField picks the field that wins among fields sharing a name,
+// which are sorted by depth and then tagged first.
+func dominantField(fields []structField) (structField, bool) {
+	if len(fields) > 1 && len(fields[0].index) == len(fields[1].index) && fields[0].tagged == fields[1].tagged {
+		return structField{}, false
+	}
+	return fields[0], true
+}
+
+func indexLess(a, b []int) bool {
+	for i := range a {
+		if i >= len(b) {
+			return false
+		}
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// tagOptions is the part of a json tag after the first comma.
+type tagOptions string
+
+func parseTag(tag string) (string, tagOptions) {
+	if i := strings.Index(tag, ","); i != -1 {
+		return tag[:i], tagOptions(tag[i+1:])
+	}
+	return tag, ""
+}
+
+// isValidTag reports whether encoding/json would accept s as a key name.
+func isValidTag(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case strings.ContainsRune("!#$%&()*+-./:;<=>?@[]^_{|}~ ", c):
+			// Backslash and quote chars are reserved, but
+			// otherwise any punctuation chars are allowed
+			// in a tag name.
+		case !unicode.IsLetter(c) && !unicode.IsDigit(c):
+			return false
+		}
+	}
+	return true
+}
+
+func (o tagOptions) contains(option string) bool {
+	for o != "" {
+		var current string
+		if i := strings.Index(string(o), ","); i >= 0 {
+			current, o = string(o[:i]), o[i+1:]
+		} else {
+			current, o = string(o), ""
+		}
+		if current == option {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex, but returns false
+// instead of panicking when it runs into a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+var (
+	marshalerType     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// jsonValue returns what value becomes when it goes through json.Marshal,
+// for the values that JMESPath would otherwise not recognise: a struct
+// becomes a map of its fields, and a json.Marshaler or
+// encoding.TextMarshaler becomes the value its JSON decodes to.  An
+// Accessor becomes the plain value it stands for.  The conversion is
+// shallow for structs and Accessors, their members are left as they are.
+// The second result is false if value was returned unchanged.
+func jsonValue(value interface{}) (interface{}, bool) {
+	switch value.(type) {
+	case nil, bool, float64, string, json.Number, []interface{}, map[string]interface{}, expRef:
+		return value, false
+	}
+	if a, ok := value.(Accessor); ok {
+		return accessorValue(a), true
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil, true
+	}
+	if isMarshaler(value) {
+		return marshaledValue(value), true
+	}
+	rv, err := stripPtrs(rv)
+	if err != nil {
+		return nil, true
+	}
+	if rv.Kind() != reflect.Struct {
+		return value, false
+	}
+	if isMarshaler(rv.Interface()) {
+		return marshaledValue(rv.Interface()), true
+	}
+	return structObject(rv), true
+}
+
+func isMarshaler(value interface{}) bool {
+	switch value.(type) {
+	case json.Marshaler, encoding.TextMarshaler:
+		return true
+	}
+	return false
+}
+
+// marshaledValue round trips value through its JSON encoding.  Values
+// that fail to encode are treated as null.
+func marshaledValue(value interface{}) interface{} {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil
+	}
+	return decoded
+}
+
+// structObject returns the members of the struct rv as a map.
+func structObject(rv reflect.Value) map[string]interface{} {
+	fields := cachedStructFields(rv.Type())
+	object := make(map[string]interface{}, len(fields.list))
+	for _, field := range fields.list {
+		if v, ok := fieldValue(rv, field); ok {
+			object[field.name] = v
+		}
+	}
+	return object
+}
+
+// fieldValue returns the value of field in the struct rv, or false if
+// encoding/json would leave the field out.
+func fieldValue(rv reflect.Value, field structField) (interface{}, bool) {
+	v, ok := fieldByIndex(rv, field.index)
+	if !ok || !v.CanInterface() {
+		return nil, false
+	}
+	if field.omitEmpty && isEmptyValue(v) {
+		return nil, false
+	}
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return nil, true
+	}
+	if field.quoted {
+		if s, ok := quotedValue(v); ok {
+			return s, true
+		}
+	}
+	if isMarshaler(v.Interface()) {
+		return v.Interface(), true
+	}
+	if v.Kind() != reflect.Ptr && v.CanAddr() {
+		// encoding/json also calls pointer receiver marshal methods
+		// on fields it can take the address of.
+		if pt := reflect.PtrTo(v.Type()); pt.Implements(marshalerType) || pt.Implements(textMarshalerType) {
+			return v.Addr().Interface(), true
+		}
+	}
+	v, err := stripPtrs(v)
+	if err != nil {
+		return nil, true
+	}
+	return v.Interface(), true
+}
+
+// quotedValue encodes a scalar field tagged with the string option as a
+// string, like encoding/json does.
+func quotedValue(v reflect.Value) (string, bool) {
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		data, err := json.Marshal(v.Interface())
+		if err != nil {
+			return "", false
+		}
+		return string(data), true
+	}
+	return "", false
+}
+
+// isEmptyValue reports whether the omitempty option leaves v out.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Ptr:
+		return v.IsZero()
+	}
+	return false
+}
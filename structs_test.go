@@ -0,0 +1,276 @@
+package jmespath
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type taggedFields struct {
+	Name     string `json:"name"`
+	Count    int    `json:"count,omitempty"`
+	Secret   string `json:"-"`
+	Dash     string `json:"-,"`
+	Untagged string
+	Quoted   int    `json:",string"`
+	Invalid  string `json:"\"bad\""`
+	hidden   string
+}
+
+type EmbeddedBase struct {
+	ID    string `json:"id"`
+	Label string
+	Depth string
+}
+
+type embeddedUnexported struct {
+	Promoted string
+}
+
+type EmbeddedTagged struct {
+	Inner string
+}
+
+type embeddingFields struct {
+	EmbeddedBase
+	*EmbeddedTagged `json:"tagged"`
+	embeddedUnexported
+	Depth string `json:"depth"`
+}
+
+type ConflictA struct{ Same, OnlyA string }
+type ConflictB struct{ Same string }
+
+type conflictingFields struct {
+	ConflictA
+	ConflictB
+}
+
+type NilEmbedded struct {
+	Deep string
+}
+
+type nilEmbeddingFields struct {
+	*NilEmbedded
+	Top string
+}
+
+func fieldNames(t reflect.Type) []string {
+	var names []string
+	for _, field := range cachedStructFields(t).list {
+		names = append(names, field.name)
+	}
+	return names
+}
+
+func TestStructFieldsFollowEncodingJSON(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal([]string{"name", "count", "-", "Untagged", "Quoted", "Invalid"},
+		fieldNames(reflect.TypeOf(taggedFields{})))
+	assert.Equal([]string{"id", "Label", "Depth", "tagged", "Promoted", "depth"},
+		fieldNames(reflect.TypeOf(embeddingFields{})))
+	assert.Equal([]string{"OnlyA"}, fieldNames(reflect.TypeOf(conflictingFields{})))
+
+	fields := cachedStructFields(reflect.TypeOf(taggedFields{}))
+	count, ok := fields.lookup("count")
+	assert.True(ok)
+	assert.True(count.omitEmpty)
+	quoted, ok := fields.lookup("Quoted")
+	assert.True(ok)
+	assert.True(quoted.quoted)
+}
+
+func TestStructFieldsAreCachedPerType(t *testing.T) {
+	assert := assert.New(t)
+	first := cachedStructFields(reflect.TypeOf(taggedFields{}))
+	second := cachedStructFields(reflect.TypeOf(taggedFields{}))
+	assert.True(first == second)
+	assert.False(first == cachedStructFields(reflect.TypeOf(embeddingFields{})))
+}
+
+func TestSearchStructFields(t *testing.T) {
+	data := embeddingFields{
+		EmbeddedBase:       EmbeddedBase{ID: "1", Label: "base", Depth: "shallow?"},
+		EmbeddedTagged:     &EmbeddedTagged{Inner: "inner"},
+		embeddedUnexported: embeddedUnexported{Promoted: "promoted"},
+		Depth:              "top",
+	}
+	tagged := taggedFields{Name: "n", Secret: "s", Dash: "d", Untagged: "u", hidden: "h"}
+	var tests = []struct {
+		expression string
+		data       interface{}
+		expected   interface{}
+	}{
+		{"name", tagged, "n"},
+		{"Name", tagged, "n"},
+		{"NAME", tagged, "n"},
+		{"Secret", tagged, nil},
+		{"secret", tagged, nil},
+		{"\"-\"", tagged, "d"},
+		{"Dash", tagged, nil},
+		{"untagged", tagged, "u"},
+		{"hidden", tagged, nil},
+		{"id", data, "1"},
+		{"ID", data, "1"},
+		{"Label", data, "base"},
+		{"depth", data, "top"},
+		{"tagged.Inner", data, "inner"},
+		{"Inner", data, nil},
+		{"Promoted", data, "promoted"},
+		{"EmbeddedBase", data, nil},
+		{"Same", conflictingFields{ConflictA{"a", "only"}, ConflictB{"b"}}, nil},
+		{"OnlyA", conflictingFields{ConflictA{"a", "only"}, ConflictB{"b"}}, "only"},
+		{"Deep", nilEmbeddingFields{Top: "top"}, nil},
+		{"Deep", nilEmbeddingFields{NilEmbedded: &NilEmbedded{Deep: "deep"}}, "deep"},
+		{"[*].name", []taggedFields{tagged, {Name: "m"}}, []interface{}{"n", "m"}},
+		{"[*].id", []*embeddingFields{&data, nil}, []interface{}{"1"}},
+	}
+	for _, tt := range tests {
+		for _, opts := range [][]Option{nil, {WithBytecode()}} {
+			actual, err := Search(tt.expression, tt.data, opts...)
+			assert.Nil(t, err, tt.expression)
+			assert.Equal(t, tt.expected, actual, tt.expression)
+		}
+	}
+}
+
+func BenchmarkSearchStructSlice(b *testing.B) {
+	items := make([]taggedFields, 100)
+	for i := range items {
+		items[i] = taggedFields{Name: "name", Count: i}
+	}
+	compiled := MustCompile("[?count > `50`].name")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		compiled.Search(items)
+	}
+}
+
+type marshaledPoint struct{ X, Y int }
+
+func (p marshaledPoint) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`{"x": %d, "y": %d}`, p.X, p.Y)), nil
+}
+
+type pointerMarshaler struct{ Value string }
+
+func (p *pointerMarshaler) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]string{p.Value, p.Value})
+}
+
+type textLevel int
+
+func (l textLevel) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("level-%d", int(l))), nil
+}
+
+type marshalingFields struct {
+	Name    string           `json:"name"`
+	Count   int              `json:"count,omitempty"`
+	Amount  int              `json:"amount,string"`
+	Point   marshaledPoint   `json:"point"`
+	Pointer pointerMarshaler `json:"pointer"`
+	Level   textLevel        `json:"level"`
+	When    time.Time        `json:"when"`
+	Missing *EmbeddedBase    `json:"missing"`
+}
+
+func TestSearchStructsAsJSON(t *testing.T) {
+	data := &marshalingFields{
+		Name:    "n",
+		Amount:  5,
+		Point:   marshaledPoint{1, 2},
+		Pointer: pointerMarshaler{"p"},
+		Level:   2,
+		When:    time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	empty := struct {
+		A string `json:"a,omitempty"`
+		B string `json:"-"`
+	}{B: "b"}
+	var tests = []struct {
+		expression string
+		data       interface{}
+		expected   interface{}
+	}{
+		{"type(@)", data, "object"},
+		{"length(@)", data, 7.0},
+		{"sort(keys(@))", data, []interface{}{"amount", "level", "missing", "name", "point", "pointer", "when"}},
+		{"count", data, nil},
+		{"amount", data, "5"},
+		{"point.x", data, 1.0},
+		{"type(point)", data, "object"},
+		{"pointer", data, &data.Pointer},
+		{"pointer[1]", data, "p"},
+		{"type(pointer)", data, "array"},
+		{"level", data, data.Level},
+		{"type(level)", data, "string"},
+		{"starts_with(level, 'level-')", data, true},
+		{"type(when)", data, "string"},
+		{"length(when)", data, 20.0},
+		{"type(missing)", data, "null"},
+		{"sort(values(point))", data, []interface{}{1.0, 2.0}},
+		{"merge(@, {name: 'm'}).name", data, "m"},
+		{"merge(@, {name: 'm'}).amount", data, "5"},
+		{"sort(values(embedded))", map[string]interface{}{"embedded": EmbeddedBase{ID: "1", Label: "l", Depth: "d"}},
+			[]interface{}{"1", "d", "l"}},
+		{"sort(embedded.*)", map[string]interface{}{"embedded": EmbeddedBase{ID: "1", Label: "l", Depth: "d"}},
+			[]interface{}{"1", "d", "l"}},
+		{"sort(point.*)", data, []interface{}{1.0, 2.0}},
+		{"@ && 'yes'", data, "yes"},
+		{"@ || 'empty'", empty, "empty"},
+		{"!@", empty, true},
+		{"type(@)", empty, "object"},
+		{"keys(@)", empty, []interface{}{}},
+		{"[?@]", []interface{}{empty, data}, []interface{}{data}},
+		{"to_string(@)", marshaledPoint{3, 4}, `{"x":3,"y":4}`},
+		{"x", marshaledPoint{3, 4}, 3.0},
+		{"X", marshaledPoint{3, 4}, nil},
+	}
+	for _, tt := range tests {
+		for _, opts := range [][]Option{nil, {WithBytecode()}} {
+			actual, err := Search(tt.expression, tt.data, opts...)
+			assert.Nil(t, err, tt.expression)
+			assert.Equal(t, tt.expected, actual, tt.expression)
+		}
+	}
+}
+
+func TestStructsEqualTheirJSON(t *testing.T) {
+	point := marshaledPoint{3, 4}
+	values := []interface{}{
+		taggedFields{Name: "n", Count: 2, Untagged: "u", Quoted: 7},
+		&marshalingFields{Name: "n", Amount: 5, Point: point, Pointer: pointerMarshaler{"p"}, Level: 2},
+		point,
+		[]marshaledPoint{point, {5, 6}},
+		map[string]interface{}{"point": point, "tags": []string{"a"}},
+		struct {
+			Name string
+			Tags []string `json:"tags"`
+		}{"x", []string{"a", "b"}},
+	}
+	for _, value := range values {
+		encoded, err := json.Marshal(value)
+		if !assert.Nil(t, err) {
+			continue
+		}
+		for _, expression := range []string{
+			"@ == `" + string(encoded) + "`",
+			"[@] == `[" + string(encoded) + "]`",
+			"contains(`[" + string(encoded) + "]`, @)",
+		} {
+			for _, opts := range [][]Option{nil, {WithBytecode()}} {
+				actual, err := Search(expression, value, opts...)
+				assert.Nil(t, err, expression)
+				assert.Equal(t, true, actual, expression)
+			}
+		}
+	}
+	actual, err := Search("@ == `{\"x\": 3, \"y\": 5}`", point)
+	assert.Nil(t, err)
+	assert.Equal(t, false, actual)
+}
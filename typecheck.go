@@ -0,0 +1,819 @@
+package jmespath
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// TypeSet is the set of values an expression may produce, as inferred by
+// ResultType: the Kinds of the values, and for arrays and objects the
+// TypeSets of their elements and members where they are known.
+type TypeSet struct {
+	kinds   kindSet
+	elem    *TypeSet           // Elements of arrays, or nil if unknown
+	members map[string]TypeSet // Known members of objects
+	others  *TypeSet           // Members of objects not in members, or nil if unknown
+}
+
+// kindSet has bit 1<<k set for each Kind k in it.
+type kindSet uint8
+
+const allKinds kindSet = 1<<NullKind | 1<<BooleanKind | 1<<NumberKind | 1<<StringKind | 1<<ArrayKind | 1<<ObjectKind
+
+var (
+	anyType     = TypeSet{kinds: allKinds}
+	nullType    = kindType(NullKind)
+	booleanType = kindType(BooleanKind)
+	numberType  = kindType(NumberKind)
+	stringType  = kindType(StringKind)
+	arrayType   = kindType(ArrayKind)
+	objectType  = kindType(ObjectKind)
+)
+
+func kindType(kinds ...Kind) TypeSet {
+	var t TypeSet
+	for _, k := range kinds {
+		t.kinds |= 1 << k
+	}
+	return t
+}
+
+func arrayOf(elem TypeSet) TypeSet {
+	return TypeSet{kinds: 1 << ArrayKind, elem: &elem}
+}
+
+// Has reports whether the values in t may be of kind k.
+func (t TypeSet) Has(k Kind) bool {
+	return t.kinds&(1<<k) != 0
+}
+
+// Kinds returns the kinds of the values in t.
+func (t TypeSet) Kinds() []Kind {
+	var kinds []Kind
+	for k := NullKind; k <= ObjectKind; k++ {
+		if t.Has(k) {
+			kinds = append(kinds, k)
+		}
+	}
+	return kinds
+}
+
+// Elem returns the TypeSet of the elements of the arrays in t.
+func (t TypeSet) Elem() TypeSet {
+	if !t.Has(ArrayKind) {
+		return TypeSet{}
+	}
+	if t.elem == nil {
+		return anyType
+	}
+	return *t.elem
+}
+
+// Member returns the TypeSet of the member called name of the objects in
+// t, including null if the member may be missing.
+func (t TypeSet) Member(name string) TypeSet {
+	if !t.Has(ObjectKind) {
+		return TypeSet{}
+	}
+	if member, ok := t.members[name]; ok {
+		return member
+	}
+	if t.others == nil {
+		return anyType
+	}
+	return t.others.union(nullType)
+}
+
+func (t TypeSet) isAny() bool {
+	return t.kinds == allKinds && t.elem == nil && t.others == nil && t.members == nil
+}
+
+// String describes t, for example "array[string] | null".
+func (t TypeSet) String() string {
+	if t.isAny() {
+		return "any"
+	}
+	if t.kinds == 0 {
+		return "none"
+	}
+	var kinds []string
+	for _, k := range t.Kinds() {
+		if k == ArrayKind && t.elem != nil && !t.elem.isAny() {
+			kinds = append(kinds, "array["+t.elem.String()+"]")
+		} else {
+			kinds = append(kinds, k.String())
+		}
+	}
+	return strings.Join(kinds, " | ")
+}
+
+// union returns the TypeSet of the values in t or in other.
+func (t TypeSet) union(other TypeSet) TypeSet {
+	u := TypeSet{kinds: t.kinds | other.kinds}
+	switch {
+	case !t.Has(ArrayKind):
+		u.elem = other.elem
+	case !other.Has(ArrayKind):
+		u.elem = t.elem
+	case t.elem != nil && other.elem != nil:
+		elem := t.elem.union(*other.elem)
+		u.elem = &elem
+	}
+	switch {
+	case !t.Has(ObjectKind):
+		u.members, u.others = other.members, other.others
+	case !other.Has(ObjectKind):
+		u.members, u.others = t.members, t.others
+	default:
+		if t.members != nil || other.members != nil {
+			u.members = map[string]TypeSet{}
+		}
+		for name := range t.members {
+			u.members[name] = t.Member(name).union(other.Member(name))
+		}
+		for name := range other.members {
+			u.members[name] = t.Member(name).union(other.Member(name))
+		}
+		if t.others != nil && other.others != nil {
+			others := t.others.union(*other.others)
+			u.others = &others
+		}
+	}
+	return u
+}
+
+// without returns t without the values of kind k.
+func (t TypeSet) without(k Kind) TypeSet {
+	t.kinds &^= 1 << k
+	return t
+}
+
+// onlyKinds returns the part of t with the kinds in other.
+func (t TypeSet) onlyKinds(other TypeSet) TypeSet {
+	t.kinds &= other.kinds
+	return t
+}
+
+// typeOfValue returns the TypeSet of a literal value.
+func typeOfValue(v interface{}) TypeSet {
+	switch v := v.(type) {
+	case nil:
+		return nullType
+	case bool:
+		return booleanType
+	case string:
+		return stringType
+	case []interface{}:
+		var elem TypeSet
+		for _, item := range v {
+			elem = elem.union(typeOfValue(item))
+		}
+		return arrayOf(elem)
+	case map[string]interface{}:
+		t := TypeSet{kinds: 1 << ObjectKind, members: map[string]TypeSet{}, others: &TypeSet{}}
+		for key, value := range v {
+			t.members[key] = typeOfValue(value)
+		}
+		return t
+	}
+	if isNumber(v) {
+		return numberType
+	}
+	return anyType
+}
+
+// ResultType infers the TypeSet of the results the expression can produce
+// for input described by schema, a JSON Schema, or for any JSON input if
+// schema is nil.  The "type", "properties", "required",
+// "additionalProperties", "items", "enum", "const", "anyOf", "oneOf" and
+// "$ref" keywords are understood; others are ignored, which only makes the
+// inferred TypeSet broader.
+//
+// An expression that fails on every input the schema allows, such as
+// abs('x'), is reported with a SyntaxError pointing at the offending part.
+func (jp *JMESPath) ResultType(schema []byte) (TypeSet, error) {
+	input, err := schemaType(schema)
+	if err != nil {
+		return TypeSet{}, err
+	}
+	return inferType(jp.ast, jp.expression, input, jp.intr.fCall.registry)
+}
+
+// WithTypeCheck makes Compile and Search infer the types of the
+// expression, as ResultType does, before it is evaluated.  Expressions
+// that would fail on every input the JSON Schema allows are rejected with
+// a SyntaxError, as is an invalid schema.  A nil schema allows any input.
+//
+// Custom functions must be registered before the expression is compiled
+// when this option is used.
+func WithTypeCheck(schema []byte) Option {
+	return func(c *config) {
+		c.typeCheck = true
+		c.schema = schema
+	}
+}
+
+// typeChecker infers the types of the nodes of an AST.
+type typeChecker struct {
+	expression string
+	registry   *FunctionRegistry
+	root       TypeSet
+	vars       map[string]TypeSet // Types of the variables in scope
+}
+
+func inferType(node ASTNode, expression string, input TypeSet, registry *FunctionRegistry) (TypeSet, error) {
+	checker := &typeChecker{expression: expression, registry: registry, root: input}
+	return checker.infer(node, input)
+}
+
+// infer returns the TypeSet of the results of node for input of type t.
+func (c *typeChecker) infer(node ASTNode, t TypeSet) (TypeSet, error) {
+	switch node.nodeType {
+	case ASTEmpty:
+		return nullType, nil
+	case ASTIdentity, ASTCurrentNode:
+		return t, nil
+	case ASTRootNode:
+		return c.root, nil
+	case ASTLiteral:
+		return typeOfValue(node.value), nil
+	case ASTField:
+		return c.member(t, node.value.(string)), nil
+	case ASTIndex:
+		// Indexes out of range give null too.
+		return t.Elem().union(nullType), nil
+	case ASTSlice:
+		return whenHas(t, ArrayKind, arrayOf(t.Elem())).union(nonKind(t, ArrayKind)), nil
+	case ASTIndexExpression:
+		left, err := c.infer(node.children[0], t)
+		if err != nil {
+			return TypeSet{}, err
+		}
+		return c.infer(node.children[1], left)
+	case ASTPipe, ASTSubexpression:
+		result := t
+		for _, child := range node.children {
+			var err error
+			if result, err = c.infer(child, result); err != nil {
+				return TypeSet{}, err
+			}
+		}
+		return result, nil
+	case ASTProjection, ASTFilterProjection:
+		left, err := c.infer(node.children[0], t)
+		if err != nil {
+			return TypeSet{}, err
+		}
+		elem := left.Elem()
+		if node.nodeType == ASTFilterProjection {
+			// A condition that always fails leaves only empty arrays.
+			if _, err := c.infer(node.children[2], elem); err != nil {
+				elem = TypeSet{}
+			}
+		}
+		return c.project(node.children[1], left, ArrayKind, elem), nil
+	case ASTValueProjection:
+		left, err := c.infer(node.children[0], t)
+		if err != nil {
+			return TypeSet{}, err
+		}
+		return c.project(node.children[1], left, ObjectKind, memberValues(left)), nil
+	case ASTFlatten:
+		left, err := c.infer(node.children[0], t)
+		if err != nil {
+			return TypeSet{}, err
+		}
+		elem := left.Elem()
+		flattened := elem.without(ArrayKind).union(elem.Elem())
+		return whenHas(left, ArrayKind, arrayOf(flattened)).union(nonKind(left, ArrayKind)), nil
+	case ASTComparator:
+		for _, child := range node.children {
+			if _, err := c.infer(child, t); err != nil {
+				return TypeSet{}, err
+			}
+		}
+		return booleanType, nil
+	case ASTNotExpression:
+		if _, err := c.infer(node.children[0], t); err != nil {
+			return TypeSet{}, err
+		}
+		return booleanType, nil
+	case ASTArithmetic, ASTNegate:
+		op := "-"
+		if node.nodeType == ASTArithmetic {
+			op = arithmeticOperators[node.value.(tokType)]
+		}
+		for _, child := range node.children {
+			operand, err := c.infer(child, t)
+			if err != nil {
+				return TypeSet{}, err
+			}
+			if operand.kinds != 0 && !operand.Has(NumberKind) {
+				return TypeSet{}, validationError(c.expression, child,
+					fmt.Sprintf("operator %s: expected a number, got %s", op, operand))
+			}
+		}
+		return numberType, nil
+	case ASTOrExpression, ASTAndExpression:
+		left, err := c.infer(node.children[0], t)
+		if err != nil {
+			return TypeSet{}, err
+		}
+		// The right side is only evaluated when the left side is false
+		// for ||, or true for &&, so if that may not happen a right side
+		// that always fails adds nothing to the result.
+		right, err := c.infer(node.children[1], t)
+		if err != nil && mayShortCircuit(node.nodeType, left) {
+			right, err = TypeSet{}, nil
+		}
+		if err != nil {
+			return TypeSet{}, err
+		}
+		if node.nodeType == ASTOrExpression {
+			// A null left side is always replaced by the right side.
+			left = left.without(NullKind)
+		}
+		return left.union(right), nil
+	case ASTConditional:
+		if _, err := c.infer(node.children[0], t); err != nil {
+			return TypeSet{}, err
+		}
+		// A branch that always fails only makes the expression fail when
+		// it is taken, so it adds nothing to the result.
+		then, thenErr := c.infer(node.children[1], t)
+		otherwise, err := c.infer(node.children[2], t)
+		if thenErr != nil && err != nil {
+			return TypeSet{}, thenErr
+		}
+		return then.union(otherwise), nil
+	case ASTMultiSelectList:
+		var elem TypeSet
+		for _, child := range node.children {
+			childType, err := c.infer(child, t)
+			if err != nil {
+				return onlyNull(t, err)
+			}
+			elem = elem.union(childType)
+		}
+		return arrayOf(elem).union(whenHas(t, NullKind, nullType)), nil
+	case ASTMultiSelectHash:
+		object := TypeSet{kinds: 1 << ObjectKind, members: map[string]TypeSet{}, others: &TypeSet{}}
+		for _, child := range node.children {
+			childType, err := c.infer(child, t)
+			if err != nil {
+				return onlyNull(t, err)
+			}
+			if child.nodeType == ASTKeyValExprPair {
+				if _, err := c.infer(child.value.(ASTNode), t); err != nil {
+					return onlyNull(t, err)
+				}
+				object.members, object.others = nil, nil
+				continue
+			}
+			if object.members != nil {
+				object.members[child.value.(string)] = childType
+			}
+		}
+		return object.union(whenHas(t, NullKind, nullType)), nil
+	case ASTKeyValPair, ASTKeyValExprPair, ASTVariableBinding:
+		return c.infer(node.children[0], t)
+	case ASTLetExpression:
+		last := len(node.children) - 1
+		outer, err := c.bind(node.children[:last], t)
+		if err != nil {
+			return TypeSet{}, err
+		}
+		result, err := c.infer(node.children[last], t)
+		c.vars = outer
+		return result, err
+	case ASTVariable:
+		if varType, ok := c.vars[node.value.(string)]; ok {
+			return varType, nil
+		}
+	case ASTFunctionExpression:
+		return c.inferCall(node, t)
+	}
+	return anyType, nil
+}
+
+// bind infers the types of the variables bound by bindings for input of
+// type t and puts them in scope.  It returns the variables that were in
+// scope before, to be restored once the body has been inferred.
+func (c *typeChecker) bind(bindings []ASTNode, t TypeSet) (map[string]TypeSet, error) {
+	vars := make(map[string]TypeSet, len(c.vars)+len(bindings))
+	for name, varType := range c.vars {
+		vars[name] = varType
+	}
+	for _, binding := range bindings {
+		bound, err := c.infer(binding, t)
+		if err != nil {
+			return nil, err
+		}
+		vars[binding.value.(string)] = bound
+	}
+	outer := c.vars
+	c.vars = vars
+	return outer, nil
+}
+
+// onlyNull returns the TypeSet of a multi-select, for input of type t,
+// with a child that fails with err.  A null input gives null without the
+// children being evaluated, so the multi-select only always fails when
+// its input cannot be null.
+func onlyNull(t TypeSet, err error) (TypeSet, error) {
+	if t.Has(NullKind) {
+		return nullType, nil
+	}
+	return TypeSet{}, err
+}
+
+// whenHas returns result if t has kind k, and no type otherwise.
+func whenHas(t TypeSet, k Kind, result TypeSet) TypeSet {
+	if t.Has(k) {
+		return result
+	}
+	return TypeSet{}
+}
+
+// nonKind returns null if t has kinds other than k, the result of
+// operations on a value of the wrong kind.
+func nonKind(t TypeSet, k Kind) TypeSet {
+	if t.without(k).kinds != 0 {
+		return nullType
+	}
+	return TypeSet{}
+}
+
+// member returns the TypeSet of a field of a value of type t.
+func (c *typeChecker) member(t TypeSet, name string) TypeSet {
+	return t.Member(name).union(nonKind(t, ObjectKind))
+}
+
+// memberValues returns the TypeSet of the values of the objects in t.
+func memberValues(t TypeSet) TypeSet {
+	if !t.Has(ObjectKind) {
+		return TypeSet{}
+	}
+	if t.others == nil {
+		return anyType
+	}
+	values := *t.others
+	for _, member := range t.members {
+		values = values.union(member)
+	}
+	return values
+}
+
+// project returns the TypeSet of a projection of right over the elements,
+// of type elem, of the values of kind k in left.  Null results are left
+// out of projections.  The values in left may be empty, so if right
+// always fails, the projection only produces empty arrays.
+func (c *typeChecker) project(right ASTNode, left TypeSet, k Kind, elem TypeSet) TypeSet {
+	projected, err := c.infer(right, elem)
+	if err != nil {
+		projected = TypeSet{}
+	}
+	return whenHas(left, k, arrayOf(projected.without(NullKind))).union(nonKind(left, k))
+}
+
+// mayShortCircuit reports whether the right side of an || or &&
+// expression, given by op, may be skipped for a left side of type left:
+// whether left may be true for ||, or false for &&.  Only numbers are
+// always true and only null is always false.
+func mayShortCircuit(op NodeType, left TypeSet) bool {
+	if left.kinds == 0 {
+		return true
+	}
+	if op == ASTOrExpression {
+		return left.without(NullKind).kinds != 0
+	}
+	return left.without(NumberKind).kinds != 0
+}
+
+// inferCall checks the arguments of a function call against its signature
+// and returns the TypeSet of its result.
+func (c *typeChecker) inferCall(node ASTNode, t TypeSet) (TypeSet, error) {
+	name, ok := node.value.(string)
+	if !ok {
+		return TypeSet{}, validationError(c.expression, node, fmt.Sprintf("Invalid function name: %v", node.value))
+	}
+	entry, ok := c.registry.lookup(name)
+	if !ok {
+		return TypeSet{}, validationError(c.expression, node, "Unknown function: "+name+"()")
+	}
+	if err := entry.checkArity(len(node.children)); err != nil {
+		return TypeSet{}, validationError(c.expression, node, errorMessage(err))
+	}
+	// Expression references are evaluated against the elements of the
+	// array the function is given.
+	refInput := anyType
+	args := make([]TypeSet, len(node.children))
+	for i, arg := range node.children {
+		if isExpRef(arg) {
+			continue
+		}
+		argType, err := c.infer(arg, t)
+		if err != nil {
+			return TypeSet{}, err
+		}
+		if argType.Has(ArrayKind) && refInput.isAny() {
+			refInput = argType.Elem()
+		}
+		args[i] = argType
+	}
+	for i, arg := range node.children {
+		if isExpRef(arg) {
+			// The array may be empty, or the function may not call the
+			// reference, so a reference that always fails has no type.
+			refType, err := c.inferRef(arg, t, refInput)
+			if err != nil {
+				refType = TypeSet{}
+			}
+			args[i] = refType
+		}
+		// Functions registered without a signature accept anything.
+		if entry.unchecked {
+			continue
+		}
+		spec := entry.arguments[len(entry.arguments)-1]
+		if i < len(entry.arguments) {
+			spec = entry.arguments[i]
+		}
+		if !spec.accepts(arg, args[i]) {
+			return TypeSet{}, validationError(c.expression, arg,
+				fmt.Sprintf("%s(): argument %d: expected %s, got %s", name, i, spec, describeArg(arg, args[i])))
+		}
+	}
+	if !entry.builtin {
+		return anyType, nil
+	}
+	return builtinResultType(name, node, args, c)
+}
+
+// isExpRef reports whether node evaluates to an expression reference:
+// whether it is one, or a let expression whose body is.
+func isExpRef(node ASTNode) bool {
+	for node.nodeType == ASTLetExpression {
+		node = node.children[len(node.children)-1]
+	}
+	return node.nodeType == ASTExpRef
+}
+
+// inferRef returns the TypeSet of the results of the expression reference
+// arg for elements of type refInput.  The bindings of the let expressions
+// around the reference are inferred for input of type t, the input of the
+// function call.
+func (c *typeChecker) inferRef(arg ASTNode, t, refInput TypeSet) (TypeSet, error) {
+	if arg.nodeType == ASTExpRef {
+		return c.infer(arg.children[0], refInput)
+	}
+	last := len(arg.children) - 1
+	outer, err := c.bind(arg.children[:last], t)
+	if err != nil {
+		return TypeSet{}, err
+	}
+	result, err := c.inferRef(arg.children[last], t, refInput)
+	c.vars = outer
+	return result, err
+}
+
+// accepts reports whether an argument of type t, given by node, can ever
+// satisfy the spec.  An argument with no type is never evaluated.
+func (a argSpec) accepts(node ASTNode, t TypeSet) bool {
+	isRef := isExpRef(node)
+	for _, want := range a.types {
+		var ok bool
+		switch want {
+		case jpAny, jpUnknown:
+			return true
+		case jpExpref:
+			ok = isRef
+		case jpNumber, jpString, jpArray, jpObject:
+			ok = !isRef && (t.kinds == 0 || t.onlyKinds(argKinds[want]).kinds != 0)
+		case jpArrayNumber, jpArrayString:
+			elem := t.Elem()
+			ok = !isRef && (t.kinds == 0 ||
+				t.Has(ArrayKind) && (elem.kinds == 0 || elem.onlyKinds(argKinds[want]).kinds != 0))
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// argKinds maps the argument types to their TypeSets, or to the TypeSets
+// of their elements for typed arrays.
+var argKinds = map[jpType]TypeSet{
+	jpNumber:      numberType,
+	jpString:      stringType,
+	jpArray:       arrayType,
+	jpObject:      objectType,
+	jpArrayNumber: numberType,
+	jpArrayString: stringType,
+}
+
+func (a argSpec) String() string {
+	types := make([]string, len(a.types))
+	for i, t := range a.types {
+		types[i] = string(t)
+	}
+	return strings.Join(types, " or ")
+}
+
+func describeArg(node ASTNode, t TypeSet) string {
+	if isExpRef(node) {
+		return "expref"
+	}
+	return t.String()
+}
+
+// builtinResultType returns the TypeSet of the result of the builtin
+// function name, called with arguments of the given types.  Expression
+// reference arguments have the type of the expression's results.
+func builtinResultType(name string, node ASTNode, args []TypeSet, c *typeChecker) (TypeSet, error) {
+	switch name {
+	case "abs", "ceil", "floor", "length", "sum":
+		return numberType, nil
+	case "avg":
+		return numberType.union(nullType), nil
+	case "to_number":
+		return numberType.union(nullType), nil
+	case "contains", "contains_any", "starts_with", "ends_with":
+		return booleanType, nil
+	case "type", "join", "to_string":
+		return stringType, nil
+	case "keys":
+		return arrayOf(stringType), nil
+	case "values":
+		return arrayOf(memberValues(args[0])), nil
+	case "descendants":
+		return arrayType, nil
+	case "max", "min":
+		return args[0].Elem().onlyKinds(kindType(NumberKind, StringKind)).union(nullType), nil
+	case "max_by", "min_by", "sort_by":
+		if key := args[1]; key.kinds != 0 && key.onlyKinds(kindType(NumberKind, StringKind)).kinds == 0 {
+			return TypeSet{}, validationError(c.expression, node.children[1],
+				fmt.Sprintf("%s(): argument 1: expected the expression to return number or string, got %s", name, key))
+		}
+		if name == "sort_by" {
+			return arrayOf(args[0].Elem()), nil
+		}
+		return args[0].Elem().union(nullType), nil
+	case "map":
+		return arrayOf(args[0]), nil
+	case "sort", "shuffle", "dedup", "dedup_by", "slice":
+		return arrayOf(args[0].Elem()), nil
+	case "reverse":
+		return args[0].onlyKinds(stringType).union(whenHas(args[0], ArrayKind, arrayOf(args[0].Elem()))), nil
+	case "to_array":
+		result := whenHas(args[0], ArrayKind, arrayOf(args[0].Elem()))
+		if others := args[0].without(ArrayKind); others.kinds != 0 {
+			result = result.union(arrayOf(others))
+		}
+		return result, nil
+	case "merge", "from_items":
+		return objectType, nil
+	case "items", "zip":
+		return arrayOf(arrayType), nil
+	case "not_null":
+		var result TypeSet
+		for _, arg := range args {
+			result = result.union(arg.without(NullKind))
+		}
+		return result.union(nullType), nil
+	}
+	return anyType, nil
+}
+
+// schemaType converts a JSON Schema to the TypeSet of the values it
+// allows.
+func schemaType(schema []byte) (TypeSet, error) {
+	if schema == nil {
+		return anyType, nil
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(schema, &decoded); err != nil {
+		return TypeSet{}, fmt.Errorf("invalid schema: %s", err)
+	}
+	s := &schemaConverter{root: decoded, expanding: map[string]bool{}}
+	return s.convert(decoded), nil
+}
+
+type schemaConverter struct {
+	root      interface{}
+	expanding map[string]bool // References being converted, to stop recursion
+}
+
+func (s *schemaConverter) convert(schema interface{}) TypeSet {
+	object, ok := schema.(map[string]interface{})
+	if !ok {
+		// true allows anything and false nothing.
+		if schema == false {
+			return TypeSet{}
+		}
+		return anyType
+	}
+	if ref, ok := object["$ref"].(string); ok {
+		return s.reference(ref)
+	}
+	if value, ok := object["const"]; ok {
+		return typeOfValue(value)
+	}
+	if values, ok := object["enum"].([]interface{}); ok {
+		return typeOfValue(values).Elem()
+	}
+	for _, keyword := range []string{"anyOf", "oneOf"} {
+		if alternatives, ok := object[keyword].([]interface{}); ok {
+			var t TypeSet
+			for _, alternative := range alternatives {
+				t = t.union(s.convert(alternative))
+			}
+			return t
+		}
+	}
+	t := anyType
+	switch types := object["type"].(type) {
+	case string:
+		t = schemaKind(types)
+	case []interface{}:
+		t = TypeSet{}
+		for _, name := range types {
+			if name, ok := name.(string); ok {
+				t = t.union(schemaKind(name))
+			}
+		}
+	}
+	if t.Has(ArrayKind) {
+		if items, ok := object["items"]; ok {
+			elem := s.convert(items)
+			t.elem = &elem
+		}
+	}
+	if t.Has(ObjectKind) {
+		properties, _ := object["properties"].(map[string]interface{})
+		required := map[string]bool{}
+		if names, ok := object["required"].([]interface{}); ok {
+			for _, name := range names {
+				if name, ok := name.(string); ok {
+					required[name] = true
+				}
+			}
+		}
+		if len(properties) > 0 {
+			t.members = map[string]TypeSet{}
+			for name, property := range properties {
+				member := s.convert(property)
+				if !required[name] {
+					member = member.union(nullType)
+				}
+				t.members[name] = member
+			}
+		}
+		if additional, ok := object["additionalProperties"]; ok {
+			others := s.convert(additional)
+			t.others = &others
+		}
+	}
+	return t
+}
+
+func schemaKind(name string) TypeSet {
+	switch name {
+	case "null":
+		return nullType
+	case "boolean":
+		return booleanType
+	case "number", "integer":
+		return numberType
+	case "string":
+		return stringType
+	case "array":
+		return arrayType
+	case "object":
+		return objectType
+	}
+	return TypeSet{}
+}
+
+// reference converts the schema that ref, a JSON pointer into the root
+// schema such as "#/$defs/item", refers to.  Recursive references are
+// taken to allow anything.
+func (s *schemaConverter) reference(ref string) TypeSet {
+	if s.expanding[ref] || !strings.HasPrefix(ref, "#") {
+		return anyType
+	}
+	target := s.root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/")[1:] {
+		token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+		object, ok := target.(map[string]interface{})
+		if !ok {
+			return anyType
+		}
+		target = object[token]
+	}
+	s.expanding[ref] = true
+	defer delete(s.expanding, ref)
+	return s.convert(target)
+}
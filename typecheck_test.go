@@ -0,0 +1,266 @@
+package jmespath
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const itemsSchema = `{
+	"type": "object",
+	"required": ["items", "owner"],
+	"properties": {
+		"items": {
+			"type": "array",
+			"items": {"$ref": "#/$defs/item"}
+		},
+		"owner": {"type": ["string", "null"]},
+		"count": {"type": "integer"},
+		"meta": {"type": "object", "additionalProperties": {"type": "string"}},
+		"fixed": {"type": "object", "properties": {"a": {"const": 1}}, "required": ["a"], "additionalProperties": false},
+		"status": {"enum": ["on", "off"]},
+		"either": {"anyOf": [{"type": "number"}, {"type": "boolean"}]},
+		"tree": {"$ref": "#/$defs/tree"}
+	},
+	"$defs": {
+		"item": {
+			"type": "object",
+			"required": ["name", "active", "price"],
+			"properties": {
+				"name": {"type": "string"},
+				"active": {"type": "boolean"},
+				"price": {"type": "number"},
+				"tags": {"type": "array", "items": {"type": "string"}}
+			}
+		},
+		"tree": {
+			"type": "object",
+			"properties": {"children": {"type": "array", "items": {"$ref": "#/$defs/tree"}}}
+		}
+	}
+}`
+
+func TestResultType(t *testing.T) {
+	var tests = []struct {
+		expression string
+		expected   string
+	}{
+		{"items[?active].name", "array[string]"},
+		{"items[*].tags", "array[array[string]]"},
+		{"items[*].tags[]", "array[string]"},
+		{"items[0]", "null | object"},
+		{"items[0].name", "null | string"},
+		{"items[:2].price", "array[number]"},
+		{"owner", "null | string"},
+		{"owner || 'nobody'", "string"},
+		{"count", "null | number"},
+		{"meta.anything", "null | string"},
+		{"meta.*", "null | array[string]"},
+		{"fixed.a", "null | number"},
+		{"fixed.b", "null"},
+		{"status", "null | string"},
+		{"either", "null | boolean | number"},
+		{"tree.children", "null | array"},
+		{"tree.children[0].children", "any"},
+		{"missing", "any"},
+		{"length(items)", "number"},
+		{"sort_by(items, &price)[*].name", "array[string]"},
+		{"max_by(items, &price)", "null | object"},
+		{"map(&name, items)", "array[string]"},
+		{"keys(@)", "array[string]"},
+		{"to_array(owner)", "array[null | string]"},
+		{"not_null(owner, count)", "null | number | string"},
+		{"items[0].price > `1`", "boolean"},
+		{"{n: owner, c: count}", "object"},
+		{"[owner, count]", "array[null | number | string]"},
+		{"`[1, \"a\"]`", "array[number | string]"},
+		{"$.items | length(@)", "number"},
+		{"let $i = items in $i[*].name", "array[string]"},
+		{"let $o = owner in items[*].[name, $o]", "array[array[null | string]]"},
+		{"let $o = owner in let $o = count in $o", "null | number"},
+		{"let $o = owner in $p", "any"},
+		{"items[0].price * `2`", "number"},
+		{"-count", "number"},
+		{"count ? owner : items", "null | string | array[object]"},
+		{"count ? abs(owner) : items[0]", "null | object"},
+		{"items[?abs(name)]", "array[none]"},
+		{"owner && abs(owner)", "null | string"},
+		{"descendants(items)", "array"},
+	}
+	for _, tt := range tests {
+		jp := MustCompile(tt.expression)
+		result, err := jp.ResultType([]byte(itemsSchema))
+		if assert.Nil(t, err, tt.expression) {
+			assert.Equal(t, tt.expected, result.String(), tt.expression)
+		}
+	}
+}
+
+func TestResultTypeWithoutSchema(t *testing.T) {
+	var tests = []struct {
+		expression string
+		expected   string
+	}{
+		{"foo", "any"},
+		{"foo[*].bar", "null | array[boolean | number | string | array | object]"},
+		{"length(foo)", "number"},
+		{"foo == bar", "boolean"},
+		{"'x'", "string"},
+		{"`[]`[*].foo", "array[none]"},
+	}
+	for _, tt := range tests {
+		result, err := MustCompile(tt.expression).ResultType(nil)
+		if assert.Nil(t, err, tt.expression) {
+			assert.Equal(t, tt.expected, result.String(), tt.expression)
+		}
+	}
+}
+
+func TestTypeSetAccessors(t *testing.T) {
+	assert := assert.New(t)
+	result, err := MustCompile("items").ResultType([]byte(itemsSchema))
+	assert.Nil(err)
+	assert.Equal([]Kind{ArrayKind}, result.Kinds())
+	assert.True(result.Has(ArrayKind))
+	assert.False(result.Has(NullKind))
+	assert.Equal("string", result.Elem().Member("name").String())
+	assert.Equal("null | array[string]", result.Elem().Member("tags").String())
+	assert.Equal("none", result.Member("name").String())
+}
+
+func TestTypeErrors(t *testing.T) {
+	var tests = []struct {
+		expression string
+		message    string
+		offset     int
+	}{
+		{"abs('x')", "abs(): argument 0: expected number, got string", 5},
+		{"length(`1`)", "length(): argument 0: expected string or array or object, got number", 8},
+		{"sum(items[*].name)", "sum(): argument 0: expected array[number], got array[string]", 9},
+		{"starts_with(count, 'a')", "starts_with(): argument 0: expected string, got null | number", 12},
+		{"sort_by(items, owner)", "sort_by(): argument 1: expected expref, got null | string", 15},
+		{"sort_by(items, &tags)", "sort_by(): argument 1: expected the expression to return number or string, got null | array[string]", 15},
+		{"`1` && abs('x')", "abs(): argument 0: expected number, got string", 12},
+		{"`null` || abs('x')", "abs(): argument 0: expected number, got string", 15},
+		{"length(abs(items))", "abs(): argument 0: expected number, got array[object]", 11},
+		{"abs(`1`, `2`)", "abs(): incorrect number of args: expected 1, got 2", 0},
+		{"items[0].price * owner", "operator *: expected a number, got null | string", 17},
+		{"-items", "operator -: expected a number, got array[object]", 1},
+		{"count ? abs(owner) : length(count)", "abs(): argument 0: expected number, got null | string", 12},
+		{"nope(@)", "Unknown function: nope()", 0},
+		{"`1` | [abs('x')]", "abs(): argument 0: expected number, got string", 12},
+		{"items | {a: abs(`1`, `2`)}", "abs(): incorrect number of args: expected 1, got 2", 12},
+	}
+	for _, tt := range tests {
+		for _, search := range []bool{false, true} {
+			var err error
+			if search {
+				_, err = Search(tt.expression, nil, WithTypeCheck([]byte(itemsSchema)))
+			} else {
+				_, err = MustCompile(tt.expression).ResultType([]byte(itemsSchema))
+			}
+			if syntaxError, ok := err.(SyntaxError); assert.True(t, ok, "%s: %v", tt.expression, err) {
+				assert.Equal(t, "SyntaxError: "+tt.message, syntaxError.Error(), tt.expression)
+				assert.Equal(t, tt.offset, syntaxError.Offset, tt.expression)
+				assert.Equal(t, tt.expression, syntaxError.Expression)
+			}
+		}
+	}
+}
+
+func TestTypeCheckAcceptsPossibleTypes(t *testing.T) {
+	for _, expression := range []string{
+		"abs(foo)",
+		"abs(either)",
+		"length(owner || items)",
+		"sort_by(items, &tree)",
+		"`[]`[*].abs(@)",
+		"sum(`[]`)",
+		"custom(`1`)",
+		// Parts that always fail may not be evaluated.
+		"`false` && abs('x')",
+		"`true` || abs('x')",
+		"`[]`[*].abs('x')",
+		"map(&abs('x'), `[]`)",
+		"foo[*].abs('x')",
+		"foo[?abs('x')]",
+		"min_by(`[]`, &abs('x'))",
+		"[abs('x')]",
+		"{a: abs('x')}",
+		"`null` | [abs('x')]",
+	} {
+		registry := NewFunctionRegistry()
+		registry.Register("custom", func([]Value, *Executor) (interface{}, error) { return nil, nil })
+		_, err := Compile(expression, WithTypeCheck(nil), WithFunctionRegistry(registry))
+		assert.Nil(t, err, expression)
+	}
+	for _, expression := range []string{"abs(either)", "items[?abs(name)]"} {
+		_, err := Compile(expression, WithTypeCheck([]byte(itemsSchema)))
+		assert.Nil(t, err, expression)
+	}
+	_, err := Compile("foo", WithTypeCheck([]byte("{")))
+	assert.EqualError(t, err, "invalid schema: unexpected end of JSON input")
+	_, err = Compile("(`0`)()", WithTypeCheck(nil))
+	assert.IsType(t, SyntaxError{}, err)
+}
+
+// typeContains reports whether v is one of the values in t.
+func typeContains(t TypeSet, v interface{}) bool {
+	v = force_parse(v)
+	kind := NullKind
+	switch v := v.(type) {
+	case bool:
+		kind = BooleanKind
+	case float64:
+		kind = NumberKind
+	case string:
+		kind = StringKind
+	case []interface{}:
+		kind = ArrayKind
+		for _, item := range v {
+			if !typeContains(t.Elem(), item) {
+				return false
+			}
+		}
+	case map[string]interface{}:
+		kind = ObjectKind
+		for key, value := range v {
+			if !typeContains(t.Member(key), value) {
+				return false
+			}
+		}
+	}
+	return t.Has(kind)
+}
+
+// TestResultTypeCompliance checks that the result of every passing
+// compliance test is in the TypeSet inferred for its input.
+func TestResultTypeCompliance(t *testing.T) {
+	for _, filename := range whiteListed {
+		var testSuites []TestSuite
+		data, err := ioutil.ReadFile(filename)
+		if !assert.Nil(t, err) {
+			continue
+		}
+		assert.Nil(t, json.Unmarshal(data, &testSuites))
+		for _, testsuite := range testSuites {
+			input := typeOfValue(testsuite.Given)
+			for _, testcase := range testsuite.TestCases {
+				if testcase.Error != "" {
+					continue
+				}
+				jp := MustCompile(testcase.Expression)
+				for _, root := range []TypeSet{input, anyType} {
+					checker := &typeChecker{expression: testcase.Expression, registry: defaultRegistry, root: root}
+					result, err := checker.infer(jp.ast, root)
+					if assert.Nil(t, err, testcase.Expression) {
+						assert.True(t, typeContains(result, testcase.Result),
+							"%s: %v is not in %s", testcase.Expression, testcase.Result, result)
+					}
+				}
+			}
+		}
+	}
+}
@@ -2,13 +2,13 @@ package jmespath
 
 import (
 	"errors"
-	"encoding/json"
 	"reflect"
 )
 
 // IsFalse determines if an object is false based on the JMESPath spec.
 // JMESPath defines false values to be any of:
-// - An empty string array, or hash.
+// - An empty string array, or hash.  Structs count as hashes of the
+//   fields json.Marshal would encode.
 // - The boolean value false.
 // - nil
 func isFalse(value interface{}) bool {
@@ -24,14 +24,21 @@ func isFalse(value interface{}) bool {
 	case nil:
 		return true
 	}
+	if a, ok := value.(Accessor); ok {
+		switch a.Kind() {
+		case ArrayKind, ObjectKind:
+			return a.Len() == 0
+		}
+		return isFalse(accessed(a))
+	}
+	// Structs and marshalers are judged by their JSON form.
+	if converted, ok := jsonValue(value); ok {
+		return isFalse(converted)
+	}
 	// Try the reflection cases before returning false.
 	rv := reflect.ValueOf(value)
 	switch rv.Kind() {
-	case reflect.Struct:
-		// A struct type will never be false, even if
-		// all of its values are the zero type.
-		return false
-	case reflect.Slice, reflect.Map:
+	case reflect.Slice, reflect.Array, reflect.Map:
 		return rv.Len() == 0
 	case reflect.Ptr:
 		if rv.IsNil() {
@@ -68,13 +75,21 @@ func slice(slice []interface{}, parts []sliceParam) ([]interface{}, error) {
 	}
 	start, stop, step := computed[0], computed[1], computed[2]
 	result := []interface{}{}
+	// Stop before i += step would pass stop, since a large step could
+	// overflow i.
 	if step > 0 {
 		for i := start; i < stop; i += step {
 			result = append(result, slice[i])
+			if step >= stop-i {
+				break
+			}
 		}
 	} else {
 		for i := start; i > stop; i += step {
 			result = append(result, slice[i])
+			if step <= stop-i {
+				break
+			}
 		}
 	}
 	return result, nil
@@ -85,7 +100,7 @@ func computeSliceParams(length int, parts []sliceParam) ([]int, error) {
 	if !parts[2].Specified {
 		step = 1
 	} else if parts[2].N == 0 {
-		return nil, errors.New("Invalid slice, step cannot be 0")
+		return nil, runtimeError(InvalidValue, "Invalid slice, step cannot be 0")
 	} else {
 		step = parts[2].N
 	}
@@ -138,31 +153,22 @@ func capSlice(length int, actual int, step int) int {
 	return actual
 }
 
-// ToArrayNum converts an empty interface type to a slice of float64.
-// If any element in the array cannot be converted, then nil is returned
-// along with a second value of false.
-func toArrayNum(data interface{}) ([]float64, bool) {
-	// Is there a better way to do this with reflect?
-	if d, ok := data.([]interface{}); ok {
-		result := make([]float64, len(d))
+// ToArrayNum returns the elements of an array of numbers along with their
+// values.  If any element is not a number, then nil is returned along with
+// a third value of false.
+func toArrayNum(data interface{}) ([]interface{}, []number, bool) {
+	if d, ok := toInterfaceSlice(data); ok {
+		result := make([]number, len(d))
 		for i, el := range d {
-			item, ok := el.(float64)
+			item, ok := asNumber(el)
 			if !ok {
-				item_num, ok2 := el.(json.Number)
-				if !ok2 {
-					return nil, false
-				}
-				var err error
-				item, err = item_num.Float64()
-				if err != nil {
-					return nil, false
-				}
+				return nil, nil, false
 			}
 			result[i] = item
 		}
-		return result, true
+		return d, result, true
 	}
-	return nil, false
+	return nil, nil, false
 }
 
 // ToArrayStr converts an empty interface type to a slice of strings.
@@ -171,8 +177,7 @@ func toArrayNum(data interface{}) ([]float64, bool) {
 // converted, then the converted data, along with a second value of true,
 // will be returned.
 func toArrayStr(data interface{}) ([]string, bool) {
-	// Is there a better way to do this with reflect?
-	if d, ok := data.([]interface{}); ok {
+	if d, ok := toInterfaceSlice(data); ok {
 		result := make([]string, len(d))
 		for i, el := range d {
 			item, ok := el.(string)
@@ -186,11 +191,43 @@ func toArrayStr(data interface{}) ([]string, bool) {
 	return nil, false
 }
 
-func isSliceType(v interface{}) bool {
-	if v == nil {
-		return false
+// valueSet tracks the distinct values seen by dedup() and dedup_by().
+// Hashable scalars are kept in a map; anything else is compared with
+// DeepEqual.
+type valueSet struct {
+	scalars map[interface{}]bool
+	others  []interface{}
+}
+
+// add records v and reports whether it had not been seen before.
+func (s *valueSet) add(v interface{}) bool {
+	key, ok := v, true
+	switch v.(type) {
+	case nil, bool, string:
+	default:
+		// Numbers are equal by value, whatever their Go types.
+		var n number
+		if n, ok = asNumber(v); ok {
+			key = n.key()
+		}
+	}
+	if ok {
+		if s.scalars == nil {
+			s.scalars = make(map[interface{}]bool)
+		}
+		if s.scalars[key] {
+			return false
+		}
+		s.scalars[key] = true
+		return true
+	}
+	for _, other := range s.others {
+		if DeepEqual(v, other) {
+			return false
+		}
 	}
-	return reflect.TypeOf(v).Kind() == reflect.Slice
+	s.others = append(s.others, v)
+	return true
 }
 
 func stripPtrs(rv reflect.Value) (reflect.Value, error) {
@@ -1,8 +1,10 @@
 package jmespath
 
 import (
-	"github.com/stretchr/testify/assert"
+	"math"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestSlicePositiveStep(t *testing.T) {
@@ -18,6 +20,20 @@ func TestSlicePositiveStep(t *testing.T) {
 	assert.Equal(input[:3], result)
 }
 
+func TestSliceLargeStep(t *testing.T) {
+	assert := assert.New(t)
+	input := []interface{}{0, 1, 2, 3, 4}
+	result, err := slice(input, []sliceParam{{1, true}, {0, false}, {math.MaxInt64, true}})
+	assert.Nil(err)
+	assert.Equal([]interface{}{1}, result)
+	result, err = slice(input, []sliceParam{{3, true}, {0, false}, {-math.MaxInt64, true}})
+	assert.Nil(err)
+	assert.Equal([]interface{}{3}, result)
+	result, err = slice(input, []sliceParam{{0, false}, {0, false}, {math.MinInt64, true}})
+	assert.Nil(err)
+	assert.Equal([]interface{}{4}, result)
+}
+
 func TestIsFalseJSONTypes(t *testing.T) {
 	assert := assert.New(t)
 	assert.True(isFalse(false))
@@ -38,9 +54,15 @@ func TestIsFalseWithUserDefinedStructs(t *testing.T) {
 	nilStruct := nilStructType{SliceOfPointers: nil}
 	assert.True(isFalse(nilStruct.SliceOfPointers))
 
-	// A user defined struct will never be false though,
-	// even if it's fields are the zero type.
+	// A user defined struct is an object with its fields as members, so
+	// it is true even if its fields are the zero type.
 	assert.False(isFalse(nilStruct))
+	// Unless encoding/json would leave every field out.
+	assert.True(isFalse(struct{}{}))
+	assert.True(isFalse(struct {
+		A string `json:"a,omitempty"`
+		B string `json:"-"`
+	}{B: "b"}))
 }
 
 func TestIsFalseWithNilInterface(t *testing.T) {
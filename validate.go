@@ -0,0 +1,67 @@
+package jmespath
+
+import (
+	"errors"
+	"fmt"
+)
+
+// WithFunctionValidation makes Compile and Search check every function
+// call in the expression once it has been parsed: the function must exist
+// in the active FunctionRegistry and be called with an acceptable number
+// of arguments.  Failures are reported as a SyntaxError pointing at the
+// offending call instead of surfacing only when evaluation reaches it.
+//
+// Functions must be registered before the expression is compiled when
+// this option is used.
+func WithFunctionValidation() Option {
+	return func(c *config) {
+		c.validateFunctions = true
+	}
+}
+
+// validateFunctions walks the AST and resolves each function expression
+// against registry.
+func validateFunctions(node ASTNode, expression string, registry *FunctionRegistry) error {
+	if node.nodeType == ASTFunctionExpression {
+		name, ok := node.value.(string)
+		if !ok {
+			return validationError(expression, node, fmt.Sprintf("Invalid function name: %v", node.value))
+		}
+		entry, ok := registry.lookup(name)
+		if !ok {
+			return validationError(expression, node, "Unknown function: "+name+"()")
+		}
+		if err := entry.checkArity(len(node.children)); err != nil {
+			return validationError(expression, node, errorMessage(err))
+		}
+	}
+	if keyExpr, ok := node.value.(ASTNode); ok {
+		if err := validateFunctions(keyExpr, expression, registry); err != nil {
+			return err
+		}
+	}
+	for _, child := range node.children {
+		if err := validateFunctions(child, expression, registry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// errorMessage returns the message of err, without the prefix that
+// RuntimeError adds to it.
+func errorMessage(err error) string {
+	var runtimeErr RuntimeError
+	if errors.As(err, &runtimeErr) {
+		return runtimeErr.msg
+	}
+	return err.Error()
+}
+
+func validationError(expression string, node ASTNode, msg string) SyntaxError {
+	return SyntaxError{
+		msg:        msg,
+		Expression: expression,
+		Offset:     node.position,
+	}
+}
@@ -0,0 +1,99 @@
+package jmespath
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var functionValidationTests = []struct {
+	expression string
+	offset     int
+}{
+	{"nosuchfn(foo)", 0},
+	{"foo.bar | nosuchfn(@)", 10},
+	{"length(foo, bar)", 0},
+	{"foo[?length() > `1`]", 5},
+	{"{a: abs(`1`, `2`)}", 4},
+	{"sort_by(@, &not_null())", 12},
+	{"merge()", 0},
+}
+
+func TestFunctionValidationErrors(t *testing.T) {
+	assert := assert.New(t)
+	for _, tt := range functionValidationTests {
+		_, err := Compile(tt.expression, WithFunctionValidation())
+		if !assert.NotNil(err, tt.expression) {
+			continue
+		}
+		syntaxError, ok := err.(SyntaxError)
+		if assert.True(ok, tt.expression) {
+			assert.Equal(tt.offset, syntaxError.Offset, tt.expression)
+		}
+		// Without validation the same expressions still compile.
+		_, err = Compile(tt.expression)
+		assert.Nil(err, tt.expression)
+	}
+}
+
+func TestFunctionValidationAcceptsValidCalls(t *testing.T) {
+	assert := assert.New(t)
+	for _, expression := range []string{
+		"length(@)",
+		"not_null(a, b, c)",
+		"merge(a, b)",
+		"sort_by(@, &length(name))",
+		"{key: to_string(@)}",
+	} {
+		_, err := Compile(expression, WithFunctionValidation())
+		assert.Nil(err, expression)
+	}
+}
+
+func TestFunctionValidationUsesRegistry(t *testing.T) {
+	assert := assert.New(t)
+	registry := NewFunctionRegistry()
+	assert.Nil(registry.Register("custom", constantFunction(nil)))
+	_, err := Compile("custom(a, b, c)", WithFunctionRegistry(registry), WithFunctionValidation())
+	assert.Nil(err)
+	_, err = Compile("custom(a)", WithFunctionValidation())
+	assert.NotNil(err)
+
+	assert.Nil(registry.RegisterWithSignature("typed", []ArgSpec{
+		{Types: []ArgType{TypeString}},
+	}, constantFunction(nil)))
+	_, err = Search("typed(a, b)", nil, WithFunctionRegistry(registry), WithFunctionValidation())
+	assert.NotNil(err)
+}
+
+func TestFunctionValidationAcceptsComplianceExpressions(t *testing.T) {
+	assert := assert.New(t)
+	for _, filename := range whiteListed {
+		var testSuites []TestSuite
+		data, err := ioutil.ReadFile(filename)
+		if !assert.Nil(err) {
+			continue
+		}
+		if !assert.Nil(json.Unmarshal(data, &testSuites)) {
+			continue
+		}
+		for _, testsuite := range testSuites {
+			for _, testcase := range testsuite.TestCases {
+				if testcase.Error != "" {
+					continue
+				}
+				_, err := Compile(testcase.Expression, WithFunctionValidation())
+				assert.Nil(err, testcase.Expression)
+			}
+		}
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("incorrect number of args", errorMessage(runtimeError(ArityMismatch, "incorrect number of args")))
+	assert.Equal("other failure", errorMessage(errors.New("other failure")))
+}
@@ -0,0 +1,119 @@
+package jmespath
+
+import "sort"
+
+// SearchWithVars is like Search, but the expression can refer to the
+// values in vars as variables: "$org" is vars["org"].  This lets values
+// from outside be used in an expression without being written into its
+// text.  A let expression in the expression can shadow these variables,
+// and "$" on its own still refers to data.
+//
+// Every variable returned by Variables must be in vars; otherwise the
+// search fails with an UndefinedVariable error before anything is
+// evaluated.  Variables that the expression does not use are ignored.
+func (jp *JMESPath) SearchWithVars(data interface{}, vars map[string]interface{}) (interface{}, error) {
+	for _, ref := range jp.variables {
+		if _, ok := vars[ref.value.(string)]; !ok {
+			err := runtimeError(UndefinedVariable, "undefined variable: $%s", ref.value)
+			return nil, withExpression(locateError(err, ref), jp.expression)
+		}
+	}
+	intr := jp.intr.search(nil)
+	if len(vars) > 0 {
+		intr.scope = &scope{vars: make(map[string]interface{}, len(vars))}
+		for name, value := range vars {
+			intr.scope.vars[name] = accessed(value)
+		}
+	}
+	return jp.run(intr, data)
+}
+
+// Variables returns the names, without the "$", of the variables that the
+// expression refers to outside any let expression that binds them.  They
+// are the variables SearchWithVars requires, sorted by name.
+func (jp *JMESPath) Variables() []string {
+	names := make([]string, len(jp.variables))
+	for i, ref := range jp.variables {
+		names[i] = ref.value.(string)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// freeVariables returns the first reference to each variable in ast that
+// is not bound by a let expression around it, in the order they appear.
+func freeVariables(ast ASTNode) []ASTNode {
+	var refs []ASTNode
+	seen := make(map[string]bool)
+	bound := make(map[string]int)
+	var visit func(node ASTNode)
+	visit = func(node ASTNode) {
+		switch node.nodeType {
+		case ASTVariable:
+			name := node.value.(string)
+			if bound[name] == 0 && !seen[name] {
+				seen[name] = true
+				refs = append(refs, node)
+			}
+			return
+		case ASTLetExpression:
+			last := len(node.children) - 1
+			for _, binding := range node.children[:last] {
+				visit(binding)
+			}
+			for _, binding := range node.children[:last] {
+				bound[binding.value.(string)]++
+			}
+			visit(node.children[last])
+			for _, binding := range node.children[:last] {
+				bound[binding.value.(string)]--
+			}
+			return
+		}
+		if keyExpr, ok := node.value.(ASTNode); ok {
+			visit(keyExpr)
+		}
+		for _, child := range node.children {
+			visit(child)
+		}
+	}
+	visit(ast)
+	return refs
+}
+
+// scope holds the variables bound by a let expression, and through parent
+// those of the let expressions around it.  A nil *scope has no variables.
+type scope struct {
+	vars   map[string]interface{}
+	parent *scope
+}
+
+// lookup returns the value of the innermost binding of name.
+func (s *scope) lookup(name string) (interface{}, error) {
+	for ; s != nil; s = s.parent {
+		if value, ok := s.vars[name]; ok {
+			return value, nil
+		}
+	}
+	return nil, runtimeError(UndefinedVariable, "undefined variable: $%s", name)
+}
+
+// evaluateLet evaluates the bindings of a let expression in the enclosing
+// scope, so that they cannot refer to each other, and then its body with
+// them in scope.
+func (intr *treeInterpreter) evaluateLet(node ASTNode, value interface{}, rootValue interface{}) (interface{}, error) {
+	last := len(node.children) - 1
+	inner := &scope{vars: make(map[string]interface{}, last), parent: intr.scope}
+	for _, binding := range node.children[:last] {
+		bound, err := intr.execute(binding, value, rootValue)
+		if err != nil {
+			return nil, err
+		}
+		inner.vars[binding.value.(string)] = bound
+	}
+	outer := intr.scope
+	intr.scope = inner
+	result, err := intr.execute(node.children[last], value, rootValue)
+	intr.scope = outer
+	return result, err
+}
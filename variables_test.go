@@ -0,0 +1,77 @@
+package jmespath
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchWithVars(t *testing.T) {
+	data := map[string]interface{}{
+		"org": "root",
+		"users": []interface{}{
+			map[string]interface{}{"name": "a", "org": "x"},
+			map[string]interface{}{"name": "b", "org": "y"},
+		},
+	}
+	vars := map[string]interface{}{
+		"org":   "y",
+		"names": []interface{}{"a", "b"},
+		"user":  scalars{Foo: "a"},
+	}
+	var tests = []struct {
+		expression string
+		expected   interface{}
+	}{
+		{"users[?org == $org].name", []interface{}{"b"}},
+		{"[$org, $.org, org]", []interface{}{"y", "root", "root"}},
+		{"users[?contains($names, name)].name", []interface{}{"a", "b"}},
+		{"let $org = 'x' in users[?org == $org].name", []interface{}{"a"}},
+		{"map(&[name, $org], users)", []interface{}{[]interface{}{"a", "y"}, []interface{}{"b", "y"}}},
+		{"$user.Foo", "a"},
+		{"users[0].name", "a"},
+	}
+	for _, opts := range [][]Option{nil, {WithBytecode()}, {WithOptimization()}} {
+		for _, tt := range tests {
+			result, err := MustCompile(tt.expression, opts...).SearchWithVars(data, vars)
+			if assert.Nil(t, err, tt.expression) {
+				assert.Equal(t, tt.expected, result, tt.expression)
+			}
+		}
+	}
+}
+
+func TestSearchWithVarsMissing(t *testing.T) {
+	assert := assert.New(t)
+	// The check does not depend on which parts are evaluated.
+	jp := MustCompile("`true` || [$a, $b]", WithOptimization())
+	_, err := jp.SearchWithVars(nil, map[string]interface{}{"a": 1.0})
+	if runtimeErr, ok := err.(RuntimeError); assert.True(ok, "%v", err) {
+		assert.Equal(UndefinedVariable, runtimeErr.Kind)
+		assert.Equal("RuntimeError: undefined variable: $b", runtimeErr.Error())
+		assert.Equal(15, runtimeErr.Offset)
+		assert.Equal("`true` || [$a, $b]", runtimeErr.Expression)
+	}
+	_, err = MustCompile("$a").Search(nil)
+	assert.NotNil(err)
+}
+
+func TestVariables(t *testing.T) {
+	var tests = []struct {
+		expression string
+		expected   []string
+	}{
+		{"foo", []string{}},
+		{"$.foo", []string{}},
+		{"[$b, $a, $b]", []string{"a", "b"}},
+		{"let $a = b in $a", []string{}},
+		{"let $a = $a in $a", []string{"a"}},
+		{"[let $a = b in $a, $a]", []string{"a"}},
+		{"let $a = b in let $b = $a in [$b, $c]", []string{"c"}},
+		{"{&$k: v}", []string{"k"}},
+		{"sort_by(items, &$key)", []string{"key"}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, MustCompile(tt.expression).Variables(), tt.expression)
+	}
+}
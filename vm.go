@@ -0,0 +1,472 @@
+package jmespath
+
+import (
+	"sync"
+)
+
+/* This is a bytecode backend.  The AST is lowered once into a flat
+   sequence of instructions for a small stack machine, which avoids
+   re-walking the tree and re-dispatching on node types on every search.
+
+   Every compiled expression expects the value it is evaluated against on
+   top of the stack and replaces it with its result, so a subexpression
+   such as "a.b" is simply the code for "a" followed by the code for "b".
+   Nodes the compiler does not lower are delegated to the tree
+   interpreter, and builtins are shared with it through functionCaller.
+*/
+
+// WithBytecode makes Compile and Search evaluate the expression on the
+// bytecode VM instead of walking the AST.  Results are the same on both
+// backends, except that Limits.MaxSteps counts executed instructions
+// rather than evaluated nodes.
+func WithBytecode() Option {
+	return func(c *config) {
+		c.bytecode = true
+	}
+}
+
+type opcode int
+
+const (
+	opRoot        opcode = iota // Replace the value with the root value
+	opLiteral                   // Replace the value with node.value
+	opField                     // Look up the field node.value
+	opIndex                     // Index an array by node.value
+	opSlice                     // Slice an array by node.value
+	opFlatten                   // Flatten one level of nested arrays
+	opNot                       // Replace the value with its negated truthiness
+	opDup                       // Push a copy of the top of the stack
+	opSwap                      // Exchange the two values on top of the stack
+	opPop                       // Discard the top of the stack
+	opKey                       // Push the key node.value of a key-value pair
+	opCompare                   // Pop right and left and push the comparison node.value
+	opArithmetic                // Pop right and left and push the result of the operator node.value
+	opNegate                    // Negate the number on top of the stack
+	opJumpIfNil                 // Jump to arg if the value is nil
+	opJumpIfFalse               // Pop a condition and jump to arg if it is false
+	opJump                      // Jump to arg
+	opOr                        // Pop the left result; if true, it replaces the value and jumps to arg
+	opAnd                       // Pop the left result; if false, it replaces the value and jumps to arg
+	opMakeList                  // Pop arg values and push them as an array
+	opMakeHash                  // Pop arg value-key pairs and push them as an object
+	opHashKey                   // Convert the value to a string key
+	opExpRef                    // Replace the value with a reference to refs[arg]
+	opApplyRef                  // Pop a reference and apply it to the value below it
+	opCall                      // Pop the value and arg arguments and call the function node
+	opIterArray                 // Pop an array and iterate over it, or push nil and jump to arg
+	opIterValues                // Pop an object and iterate over its values, or push nil and jump to arg
+	opNext                      // Push the next element, or jump to arg when there are none left
+	opFilter                    // Pop a condition; if false, pop the element and jump to arg
+	opCollect                   // Pop a result, keep it if it is not nil and jump to arg
+	opEndIter                   // Finish the innermost iteration and push what it collected
+	opEvaluate                  // Evaluate node with the tree interpreter
+)
+
+type instruction struct {
+	op   opcode
+	arg  int      // Jump target or count, depending on op
+	node *ASTNode // Node the instruction was compiled from
+}
+
+// program is the compiled form of an expression.  Expression references
+// are compiled into programs of their own so that functions such as
+// sort_by can run them directly.
+type program struct {
+	code []instruction
+	refs []*program
+}
+
+// compileProgram lowers ast into a program.
+func compileProgram(ast ASTNode) *program {
+	prog := &program{}
+	prog.compile(&ast)
+	return prog
+}
+
+func (prog *program) emit(op opcode, arg int, node *ASTNode) int {
+	prog.code = append(prog.code, instruction{op: op, arg: arg, node: node})
+	return len(prog.code) - 1
+}
+
+// patch points the jump at index to the next instruction to be emitted.
+func (prog *program) patch(index int) {
+	prog.code[index].arg = len(prog.code)
+}
+
+// compileWith emits code that evaluates node against the value on top of
+// the stack and leaves the result above it, keeping the value.
+func (prog *program) compileWith(node *ASTNode) {
+	prog.emit(opDup, 0, node)
+	prog.compile(node)
+}
+
+func (prog *program) compile(node *ASTNode) {
+	switch node.nodeType {
+	case ASTIdentity, ASTCurrentNode:
+	case ASTRootNode:
+		prog.emit(opRoot, 0, node)
+	case ASTLiteral:
+		prog.emit(opLiteral, 0, node)
+	case ASTField:
+		prog.emit(opField, 0, node)
+	case ASTIndex:
+		prog.emit(opIndex, 0, node)
+	case ASTSlice:
+		prog.emit(opSlice, 0, node)
+	case ASTIndexExpression:
+		prog.compile(&node.children[0])
+		prog.compile(&node.children[1])
+	case ASTPipe, ASTSubexpression:
+		for i := range node.children {
+			prog.compile(&node.children[i])
+		}
+	case ASTKeyValPair:
+		prog.compile(&node.children[0])
+	case ASTFlatten:
+		prog.compile(&node.children[0])
+		prog.emit(opFlatten, 0, node)
+	case ASTNotExpression:
+		prog.compile(&node.children[0])
+		prog.emit(opNot, 0, node)
+	case ASTComparator:
+		prog.compileWith(&node.children[0])
+		prog.emit(opSwap, 0, node)
+		prog.compile(&node.children[1])
+		prog.emit(opCompare, 0, node)
+	case ASTArithmetic:
+		prog.compileWith(&node.children[0])
+		prog.emit(opSwap, 0, node)
+		prog.compile(&node.children[1])
+		prog.emit(opArithmetic, 0, node)
+	case ASTNegate:
+		prog.compile(&node.children[0])
+		prog.emit(opNegate, 0, node)
+	case ASTConditional:
+		prog.compileWith(&node.children[0])
+		otherwise := prog.emit(opJumpIfFalse, 0, node)
+		prog.compile(&node.children[1])
+		end := prog.emit(opJump, 0, node)
+		prog.patch(otherwise)
+		prog.compile(&node.children[2])
+		prog.patch(end)
+	case ASTOrExpression, ASTAndExpression:
+		op := opOr
+		if node.nodeType == ASTAndExpression {
+			op = opAnd
+		}
+		prog.compileWith(&node.children[0])
+		jump := prog.emit(op, 0, node)
+		prog.compile(&node.children[1])
+		prog.patch(jump)
+	case ASTExpRef:
+		ref := &program{}
+		ref.compile(&node.children[0])
+		prog.refs = append(prog.refs, ref)
+		prog.emit(opExpRef, len(prog.refs)-1, node)
+	case ASTFunctionExpression:
+		for i := range node.children {
+			prog.compileWith(&node.children[i])
+			prog.emit(opSwap, 0, node)
+		}
+		prog.emit(opCall, len(node.children), node)
+	case ASTMultiSelectList:
+		jump := prog.emit(opJumpIfNil, 0, node)
+		for i := range node.children {
+			prog.compileWith(&node.children[i])
+			prog.emit(opSwap, 0, node)
+		}
+		prog.emit(opPop, 0, node)
+		prog.emit(opMakeList, len(node.children), node)
+		prog.patch(jump)
+	case ASTMultiSelectHash:
+		jump := prog.emit(opJumpIfNil, 0, node)
+		for i := range node.children {
+			child := &node.children[i]
+			prog.compileWith(&child.children[0])
+			prog.emit(opSwap, 0, node)
+			if keyExpr, ok := child.value.(ASTNode); ok {
+				prog.emit(opDup, 0, node)
+				prog.compileWith(&keyExpr)
+				prog.emit(opApplyRef, 0, node)
+				prog.emit(opHashKey, 0, node)
+			} else {
+				prog.emit(opKey, 0, child)
+			}
+			prog.emit(opSwap, 0, node)
+		}
+		prog.emit(opPop, 0, node)
+		prog.emit(opMakeHash, len(node.children), node)
+		prog.patch(jump)
+	case ASTProjection, ASTValueProjection, ASTFilterProjection:
+		op := opIterArray
+		if node.nodeType == ASTValueProjection {
+			op = opIterValues
+		}
+		prog.compile(&node.children[0])
+		start := prog.emit(op, 0, node)
+		loop := prog.emit(opNext, 0, node)
+		if node.nodeType == ASTFilterProjection {
+			prog.compileWith(&node.children[2])
+			prog.emit(opFilter, loop, node)
+		}
+		prog.compile(&node.children[1])
+		prog.emit(opCollect, loop, node)
+		prog.patch(loop)
+		prog.emit(opEndIter, 0, node)
+		prog.patch(start)
+	default:
+		prog.emit(opEvaluate, 0, node)
+	}
+}
+
+// iteration is the state of a projection being run by the VM.
+type iteration struct {
+	items     []interface{}
+	next      int
+	collected []interface{}
+}
+
+// vm holds the stacks used to run programs.  Functions that apply an
+// expression reference run it on the same vm, above the frames of the
+// program that called them.
+type vm struct {
+	stack []interface{}
+	iters []iteration
+}
+
+func (m *vm) push(value interface{}) {
+	m.stack = append(m.stack, value)
+}
+
+func (m *vm) pop() interface{} {
+	value := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return value
+}
+
+func (m *vm) top() interface{} {
+	return m.stack[len(m.stack)-1]
+}
+
+func (m *vm) setTop(value interface{}) {
+	m.stack[len(m.stack)-1] = value
+}
+
+// vmPool keeps the stacks of finished searches for reuse.
+var vmPool = sync.Pool{
+	New: func() interface{} { return &vm{} },
+}
+
+// release clears the stacks so that they don't keep search results alive
+// and returns m to vmPool.
+func (m *vm) release() {
+	stack := m.stack[:cap(m.stack)]
+	for i := range stack {
+		stack[i] = nil
+	}
+	iters := m.iters[:cap(m.iters)]
+	for i := range iters {
+		iters[i] = iteration{}
+	}
+	m.stack, m.iters = stack[:0], iters[:0]
+	vmPool.Put(m)
+}
+
+// run evaluates prog against value on the VM, sharing the per-search state
+// of intr with any tree evaluation the program falls back to.
+func (intr *treeInterpreter) run(prog *program, value interface{}, rootValue interface{}) (interface{}, error) {
+	if intr.err != nil {
+		return nil, intr.err
+	}
+	if intr.vm == nil {
+		intr.vm = vmPool.Get().(*vm)
+		defer func() {
+			intr.vm.release()
+			intr.vm = nil
+		}()
+	}
+	m := intr.vm
+	stackBase, iterBase := len(m.stack), len(m.iters)
+	m.push(value)
+	result, err := intr.runCode(m, prog, rootValue)
+	m.stack = m.stack[:stackBase]
+	m.iters = m.iters[:iterBase]
+	return result, err
+}
+
+func (intr *treeInterpreter) runCode(m *vm, prog *program, rootValue interface{}) (interface{}, error) {
+	code := prog.code
+	for pc := 0; pc < len(code); pc++ {
+		ins := &code[pc]
+		if intr.ctx != nil {
+			if err := intr.checkContext(); err != nil {
+				return nil, err
+			}
+		}
+		if intr.limits.MaxSteps > 0 {
+			intr.evaluated++
+			if intr.evaluated > intr.limits.MaxSteps {
+				return nil, intr.limitExceeded("MaxSteps", intr.limits.MaxSteps, *ins.node)
+			}
+		}
+		var result interface{}
+		var err error
+		produced := false
+		switch ins.op {
+		case opRoot:
+			m.setTop(rootValue)
+		case opLiteral:
+			m.setTop(ins.node.value)
+		case opField:
+			if object, ok := m.top().(map[string]interface{}); ok {
+				m.setTop(accessed(object[ins.node.value.(string)]))
+				break
+			}
+			result, err = intr.fieldFromStructOrMap(ins.node.value.(string), m.top())
+			m.setTop(result)
+		case opIndex:
+			m.setTop(indexValue(ins.node.value.(int), m.top()))
+		case opSlice:
+			result, err = intr.sliceValue(*ins.node, m.top())
+			m.setTop(result)
+			produced = true
+		case opFlatten:
+			result, err = intr.flatten(m.top())
+			m.setTop(result)
+			produced = true
+		case opNot:
+			m.setTop(isFalse(m.top()))
+		case opDup:
+			m.push(m.top())
+		case opSwap:
+			n := len(m.stack)
+			m.stack[n-1], m.stack[n-2] = m.stack[n-2], m.stack[n-1]
+		case opPop:
+			m.pop()
+		case opKey:
+			m.push(ins.node.value)
+		case opCompare:
+			right := m.pop()
+			result, err = compareValues(ins.node.value.(tokType), m.top(), right)
+			m.setTop(result)
+		case opArithmetic:
+			right := m.pop()
+			result, err = intr.arithmetic(ins.node.value.(tokType), m.top(), right)
+			m.setTop(result)
+		case opNegate:
+			result, err = intr.negate(m.top())
+			m.setTop(result)
+		case opJumpIfNil:
+			if m.top() == nil {
+				pc = ins.arg - 1
+			}
+		case opJumpIfFalse:
+			if isFalse(m.pop()) {
+				pc = ins.arg - 1
+			}
+		case opJump:
+			pc = ins.arg - 1
+		case opOr, opAnd:
+			left := m.pop()
+			if isFalse(left) == (ins.op == opAnd) {
+				m.setTop(left)
+				pc = ins.arg - 1
+			}
+		case opMakeList:
+			n := len(m.stack) - ins.arg
+			collected := make([]interface{}, ins.arg)
+			copy(collected, m.stack[n:])
+			m.stack = m.stack[:n]
+			m.push(collected)
+			result, produced = collected, true
+		case opMakeHash:
+			n := len(m.stack) - 2*ins.arg
+			collected := make(map[string]interface{}, ins.arg)
+			for i := n; i < len(m.stack); i += 2 {
+				collected[m.stack[i+1].(string)] = m.stack[i]
+			}
+			m.stack = m.stack[:n]
+			m.push(collected)
+			result, produced = collected, true
+		case opHashKey:
+			var key string
+			key, err = convToString(m.top())
+			m.setTop(key)
+		case opExpRef:
+			m.setTop(expRef{ref: ins.node.children[0], prog: prog.refs[ins.arg], scope: intr.scope})
+		case opApplyRef:
+			ref, ok := m.pop().(expRef)
+			if !ok {
+				err = runtimeError(TypeMismatch, "multi-select hash key is not an expression reference")
+				break
+			}
+			result, err = intr.executeRef(ref, m.top(), rootValue)
+			m.setTop(result)
+		case opCall:
+			m.pop()
+			n := len(m.stack) - ins.arg
+			args := make([]interface{}, ins.arg)
+			copy(args, m.stack[n:])
+			m.stack = m.stack[:n]
+			result, err = intr.callFunction(*ins.node, args, rootValue)
+			m.push(result)
+			produced = true
+		case opIterArray, opIterValues:
+			items, ok := iterationItems(ins.op, m.pop())
+			if !ok {
+				m.push(nil)
+				pc = ins.arg - 1
+				break
+			}
+			m.iters = append(m.iters, iteration{items: items, collected: []interface{}{}})
+		case opNext:
+			it := &m.iters[len(m.iters)-1]
+			if it.next >= len(it.items) {
+				pc = ins.arg - 1
+				break
+			}
+			m.push(it.items[it.next])
+			it.next++
+		case opFilter:
+			if isFalse(m.pop()) {
+				m.pop()
+				pc = ins.arg - 1
+			}
+		case opCollect:
+			if current := m.pop(); current != nil {
+				it := &m.iters[len(m.iters)-1]
+				it.collected = append(it.collected, current)
+			}
+			pc = ins.arg - 1
+		case opEndIter:
+			result = m.iters[len(m.iters)-1].collected
+			m.iters = m.iters[:len(m.iters)-1]
+			m.push(result)
+			produced = true
+		case opEvaluate:
+			result, err = intr.execute(*ins.node, m.top(), rootValue)
+			m.setTop(result)
+		}
+		if err != nil {
+			if intr.err != nil {
+				return nil, intr.err
+			}
+			return nil, locateError(err, *ins.node)
+		}
+		if produced && (intr.limits.MaxElements > 0 || intr.limits.MaxBytes > 0) {
+			if err := intr.countResult(*ins.node, result); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return m.top(), nil
+}
+
+// iterationItems returns the elements a projection iterates over: the
+// elements of an array, or the values of an object for opIterValues.
+func iterationItems(op opcode, value interface{}) ([]interface{}, bool) {
+	if op == opIterValues {
+		return objectValues(value)
+	}
+	return toInterfaceSlice(value)
+}
@@ -0,0 +1,187 @@
+package jmespath
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const vmTestDocument = `{
+  "people": [
+    {"name": "b", "age": 30, "tags": ["x", "y"]},
+    {"name": "a", "age": 25, "tags": []},
+    {"name": "c", "age": 35, "tags": ["z"]}
+  ],
+  "nested": [[1, 2], [3, [4, 5]], 6],
+  "obj": {"one": 1, "two": 2},
+  "str": "hello"
+}`
+
+var vmTestExpressions = []string{
+	"people[*].name",
+	"people[?age > `26`].name",
+	"people[?age > `26` && name != 'c'] | [0].tags",
+	"sort_by(people, &age)[*].name",
+	"max_by(people, &age).name",
+	"map(&length(tags), people)",
+	"people[].tags[]",
+	"nested[]",
+	"nested[][]",
+	"sort(obj.*)",
+	"*.one",
+	"{a: str, b: obj.two, c: people[0].name}",
+	"people[*].{&name: age}",
+	"{&join('-', [str, str]): obj.one}",
+	"people[*].{n: name, t: length(tags)}",
+	"[str, obj.one, `null`][]",
+	"people[1:].name",
+	"nested[::-1]",
+	"str || obj",
+	"missing || str",
+	"obj.one && str",
+	"!obj.missing",
+	"people[?!contains(tags, 'x')].name",
+	"people[*].tags[?@ == 'y']",
+	"$.people[?age == $.people[0].age].name",
+	"length(people) | to_string(@)",
+	"missing[*].foo",
+	"str[*]",
+	"obj | keys(@) | sort(@) | join(',', @)",
+	"people[*].[age > `26` ? name : age]",
+	"map(&(tags ? tags[0] : name), people)",
+	"obj.missing ? abs(str) : obj.one ? str : obj",
+}
+
+func TestBytecodeMatchesTreeInterpreter(t *testing.T) {
+	assert := assert.New(t)
+	var data interface{}
+	assert.Nil(json.Unmarshal([]byte(vmTestDocument), &data))
+	for _, expression := range vmTestExpressions {
+		expected, expectedErr := Search(expression, data)
+		actual, actualErr := Search(expression, data, WithBytecode())
+		assert.Equal(expectedErr, actualErr, expression)
+		assert.Equal(expected, actual, expression)
+	}
+}
+
+func TestBytecodeMatchesTreeInterpreterOnTypedData(t *testing.T) {
+	assert := assert.New(t)
+	data := map[string]interface{}{
+		"items": []scalars{{Foo: "b", Bar: "1"}, {Foo: "a", Bar: "2"}},
+		"ints":  []int{3, 1, 2},
+		"words": map[string]string{"x": "y"},
+	}
+	for _, expression := range []string{
+		"items[*].Foo",
+		"items[?Foo == 'a'].Bar",
+		"sort_by(items, &Foo)[0].Bar",
+		"ints[1]",
+		"ints[-1:]",
+		"ints[*]",
+		"words.x",
+	} {
+		expected, expectedErr := Search(expression, data)
+		actual, actualErr := Search(expression, data, WithBytecode())
+		assert.Equal(expectedErr, actualErr, expression)
+		assert.Equal(expected, actual, expression)
+	}
+}
+
+func TestBytecodeErrorsMatchTreeInterpreter(t *testing.T) {
+	assert := assert.New(t)
+	data := map[string]interface{}{"foo": []interface{}{1.0, "x"}, "bar": "baz"}
+	for _, expression := range []string{
+		"foo[*].abs(@)",
+		"sort_by(foo, &abs(@))",
+		"{a: bar, b: length(bar, bar)}",
+		"{&abs(bar): bar}",
+		"foo[?@ > 'x']",
+		"nope(bar)",
+		"`[1]`[::0]",
+	} {
+		_, expected := Search(expression, data)
+		_, actual := Search(expression, data, WithBytecode())
+		assert.NotNil(actual, expression)
+		assert.Equal(expected, actual, expression)
+	}
+}
+
+func TestBytecodeCustomFunctions(t *testing.T) {
+	assert := assert.New(t)
+	registry := NewFunctionRegistry()
+	registry.Register("apply", func(input []Value, executor *Executor) (interface{}, error) {
+		return executor.Execute(input[0], input[1].Interface())
+	})
+	compiled := MustCompile("apply(&[name, age], people[0])", WithFunctionRegistry(registry), WithBytecode())
+	var data interface{}
+	assert.Nil(json.Unmarshal([]byte(vmTestDocument), &data))
+	result, err := compiled.Search(data)
+	assert.Nil(err)
+	assert.Equal([]interface{}{"b", 30.0}, result)
+}
+
+func TestBytecodeLimitsAndContext(t *testing.T) {
+	assert := assert.New(t)
+	items := make([]interface{}, 1000)
+	for i := range items {
+		items[i] = []interface{}{float64(i), float64(i)}
+	}
+	_, err := Search("[*][*]", items, WithBytecode(), WithLimits(Limits{MaxElements: 1500}))
+	assert.Equal("MaxElements", limitError(t, err).Limit)
+	_, err = Search("sort_by(@, &[0])", items, WithBytecode(), WithLimits(Limits{MaxSteps: 100}))
+	assert.Equal("MaxSteps", limitError(t, err).Limit)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = MustCompile("[*][*]", WithBytecode()).SearchContext(ctx, items)
+	assert.Equal(context.Canceled, err)
+}
+
+func TestBytecodeConcurrentSearches(t *testing.T) {
+	var data interface{}
+	assert.Nil(t, json.Unmarshal([]byte(vmTestDocument), &data))
+	compiled := MustCompile("sort_by(people, &age)[*].{n: name, t: tags[0]}", WithBytecode())
+	expected, _ := compiled.Search(data)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				actual, err := compiled.Search(data)
+				assert.Nil(t, err)
+				assert.Equal(t, expected, actual)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func benchmarkBackend(b *testing.B, expression string, opts ...Option) {
+	var data interface{}
+	json.Unmarshal([]byte(vmTestDocument), &data)
+	compiled := MustCompile(expression, opts...)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		compiled.Search(data)
+	}
+}
+
+func BenchmarkTreeFilterProjection(b *testing.B) {
+	benchmarkBackend(b, "people[?age > `26`].{n: name, t: length(tags)}")
+}
+
+func BenchmarkBytecodeFilterProjection(b *testing.B) {
+	benchmarkBackend(b, "people[?age > `26`].{n: name, t: length(tags)}", WithBytecode())
+}
+
+func BenchmarkTreeSortBy(b *testing.B) {
+	benchmarkBackend(b, "sort_by(people, &age)[*].name")
+}
+
+func BenchmarkBytecodeSortBy(b *testing.B) {
+	benchmarkBackend(b, "sort_by(people, &age)[*].name", WithBytecode())
+}